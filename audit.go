@@ -1,15 +1,45 @@
 package scipipe
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
 	"time"
 )
 
 type AuditInfo struct {
+	TaskID     string `json:",omitempty"`
 	Command    string
 	Params     map[string]string
 	Keys       map[string]string
 	ExecTimeMS time.Duration
 	Upstream   map[string]*AuditInfo
+	CondaEnv   string            `json:",omitempty"`
+	Modules    []string          `json:",omitempty"`
+	RunID      string            `json:",omitempty"`
+	Globals    map[string]string `json:",omitempty"`
+	// PreExisting marks audit info that was synthesized for an already
+	// existing file, with Workflow.BackfillAudit, rather than recorded when
+	// the file was produced. Only Path and SHA256 are set in it.
+	PreExisting bool   `json:",omitempty"`
+	Path        string `json:",omitempty"`
+	SHA256      string `json:",omitempty"`
+	// MaxRSSKB, UserTimeMS and SystemTimeMS are the resources used by the
+	// command (see ResourceUsage), when available
+	MaxRSSKB     int64 `json:",omitempty"`
+	UserTimeMS   int64 `json:",omitempty"`
+	SystemTimeMS int64 `json:",omitempty"`
+	// CacheKey is a hash of the task ID, globals, prepend string and
+	// environment, and the sizes and modification times of the inputs, of
+	// the task that produced the output, used by RerunIfChanged
+	CacheKey string `json:",omitempty"`
 }
 
 func NewAuditInfo() *AuditInfo {
@@ -21,3 +51,335 @@ func NewAuditInfo() *AuditInfo {
 		Upstream:   make(map[string]*AuditInfo),
 	}
 }
+
+// backfillAuditInfo writes a synthesized audit info for the existing file of
+// ip, marked as pre-existing, if there is no audit info for it
+func backfillAuditInfo(ip *InformationPacket) {
+	if _, err := ip.auditStore().read(ip.GetPath()); !os.IsNotExist(err) {
+		return
+	}
+	auditInfo := NewAuditInfo()
+	auditInfo.PreExisting = true
+	auditInfo.Path = ip.GetPath()
+	auditInfo.SHA256 = ip.GetSHA256()
+	ip.SetAuditInfo(auditInfo)
+	ip.WriteAuditLogToFile()
+	Info.Printf("Backfilled audit info for pre-existing file: %s\n", ip.GetPath())
+}
+
+// ----------------------------------------------------------------------------
+// Audit storage
+// ----------------------------------------------------------------------------
+
+// AuditMode specifies how the audit info of outputs is stored
+type AuditMode int
+
+const (
+	// AuditModeFiles writes the audit info of each output to a JSON file
+	// next to it, named as the output plus ".audit.json"
+	AuditModeFiles AuditMode = iota
+	// AuditModeGzip writes the audit info of each output to a gzip-compressed
+	// JSON file next to it, named as the output plus ".audit.json.gz"
+	AuditModeGzip AuditMode = iota
+	// AuditModeCentral appends the audit info of all outputs to a single
+	// audit log for the workflow, in JSON lines format, with one entry per
+	// line, containing the path of the output and its audit info. For outputs
+	// with multiple entries, the last one is used.
+	AuditModeCentral AuditMode = iota
+)
+
+// auditStore reads and writes the (JSON encoded) audit info of outputs, by
+// the path of the output
+type auditStore interface {
+	// read returns the audit info of path, or an error satisfying
+	// os.IsNotExist if there is none
+	read(path string) ([]byte, error)
+	write(path string, data []byte) error
+	// filePath returns the path of the file containing the audit info of path
+	filePath(path string) string
+	// ownFile returns the path of the file containing the audit info of path,
+	// if it is a separate file for path only
+	ownFile(path string) (string, bool)
+	remove(path string) error
+	rename(oldPath string, newPath string) error
+}
+
+// defaultAuditStore is the audit store of packets not created by a workflow,
+// such as by IPGen or other components. It detects the layout of the audit
+// info of each path from disk, so that audit info written by workflows in any
+// AuditMode can be read back with plain packets.
+var defaultAuditStore auditStore = &detectingAuditStore{
+	plain:      &fileAuditStore{suffix: ".audit.json"},
+	compressed: &fileAuditStore{suffix: ".audit.json.gz", gzip: true},
+}
+
+var (
+	// centralAuditStores holds the central audit stores by the path of their
+	// log, so that workflows sharing a log share its store, and so that
+	// defaultAuditStore can find audit info in them
+	centralAuditStores   = map[string]*centralAuditStore{}
+	centralAuditStoresMx sync.Mutex
+)
+
+// newAuditStore returns an audit store according to mode, using the central
+// audit log at centralLogPath with AuditModeCentral
+func newAuditStore(mode AuditMode, centralLogPath string) auditStore {
+	switch mode {
+	case AuditModeGzip:
+		return &fileAuditStore{suffix: ".audit.json.gz", gzip: true}
+	case AuditModeCentral:
+		centralAuditStoresMx.Lock()
+		defer centralAuditStoresMx.Unlock()
+		if store, ok := centralAuditStores[centralLogPath]; ok {
+			return store
+		}
+		store := &centralAuditStore{logPath: centralLogPath}
+		centralAuditStores[centralLogPath] = store
+		return store
+	default:
+		return &fileAuditStore{suffix: ".audit.json"}
+	}
+}
+
+// fileAuditStore stores the audit info of each output in a separate file,
+// optionally gzip-compressed
+type fileAuditStore struct {
+	suffix string
+	gzip   bool
+}
+
+func (s *fileAuditStore) read(path string) ([]byte, error) {
+	f, err := os.Open(s.filePath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !s.gzip {
+		return ioutil.ReadAll(f)
+	}
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	return ioutil.ReadAll(gzr)
+}
+
+func (s *fileAuditStore) write(path string, data []byte) error {
+	if !s.gzip {
+		return ioutil.WriteFile(s.filePath(path), data, 0644)
+	}
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	if _, err := gzw.Write(data); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.filePath(path), buf.Bytes(), 0644)
+}
+
+func (s *fileAuditStore) filePath(path string) string {
+	return path + s.suffix
+}
+
+func (s *fileAuditStore) ownFile(path string) (string, bool) {
+	return s.filePath(path), true
+}
+
+func (s *fileAuditStore) remove(path string) error {
+	err := os.Remove(s.filePath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileAuditStore) rename(oldPath string, newPath string) error {
+	if _, err := os.Stat(s.filePath(oldPath)); err != nil {
+		return nil
+	}
+	return os.Rename(s.filePath(oldPath), s.filePath(newPath))
+}
+
+// centralAuditStore stores the audit info of all outputs in a single,
+// append-only, log file in JSON lines format. The log is indexed by output
+// path in memory, when first read, and read again if its size changes other
+// than by writes of the store, such as when written by another program.
+type centralAuditStore struct {
+	logPath   string
+	mx        sync.Mutex
+	index     map[string][]byte
+	indexSize int64
+}
+
+// centralAuditEntry is one line in the central audit log
+type centralAuditEntry struct {
+	Path      string
+	AuditInfo json.RawMessage
+}
+
+// loadIndex reads the log into the in-memory index, if not already done. The
+// mutex has to be held by the caller.
+func (s *centralAuditStore) loadIndex() error {
+	size := int64(0)
+	if fi, err := os.Stat(s.logPath); err == nil {
+		size = fi.Size()
+	}
+	if s.index != nil && size == s.indexSize {
+		return nil
+	}
+	index := map[string][]byte{}
+	f, err := os.Open(s.logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			entry := &centralAuditEntry{}
+			err := dec.Decode(entry)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("Could not read central audit log %s: %s", s.logPath, err)
+			}
+			index[entry.Path] = entry.AuditInfo
+		}
+	}
+	s.index = index
+	s.indexSize = size
+	return nil
+}
+
+func (s *centralAuditStore) read(path string) ([]byte, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	data, ok := s.index[path]
+	if !ok {
+		return nil, &os.PathError{Op: "read audit info", Path: path, Err: os.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (s *centralAuditStore) write(path string, data []byte) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, data); err != nil {
+		return err
+	}
+	line, err := json.Marshal(&centralAuditEntry{Path: path, AuditInfo: compacted.Bytes()})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.index[path] = compacted.Bytes()
+	s.indexSize += int64(len(line) + 1)
+	return nil
+}
+
+func (s *centralAuditStore) filePath(path string) string {
+	return s.logPath
+}
+
+func (s *centralAuditStore) ownFile(path string) (string, bool) {
+	return "", false
+}
+
+// remove does nothing, since the log is append-only. A new entry replaces
+// the old one, if the output is produced again.
+func (s *centralAuditStore) remove(path string) error {
+	return nil
+}
+
+func (s *centralAuditStore) rename(oldPath string, newPath string) error {
+	data, err := s.read(oldPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.write(newPath, data)
+}
+
+// detectingAuditStore reads the audit info of each path from wherever it is
+// found: a plain audit file, a gzip-compressed one, or the central audit log
+// of a workflow created in this program. Audit info for paths without any is
+// written to plain audit files.
+type detectingAuditStore struct {
+	plain      *fileAuditStore
+	compressed *fileAuditStore
+}
+
+// locate returns the store containing the audit info of path, or the plain
+// file store if there is none
+func (s *detectingAuditStore) locate(path string) auditStore {
+	if _, err := os.Stat(s.plain.filePath(path)); err == nil {
+		return s.plain
+	}
+	if _, err := os.Stat(s.compressed.filePath(path)); err == nil {
+		return s.compressed
+	}
+	centralAuditStoresMx.Lock()
+	logPaths := []string{}
+	for logPath := range centralAuditStores {
+		logPaths = append(logPaths, logPath)
+	}
+	sort.Strings(logPaths)
+	stores := []*centralAuditStore{}
+	for _, logPath := range logPaths {
+		stores = append(stores, centralAuditStores[logPath])
+	}
+	centralAuditStoresMx.Unlock()
+	for _, store := range stores {
+		if _, err := store.read(path); err == nil {
+			return store
+		}
+	}
+	return s.plain
+}
+
+func (s *detectingAuditStore) read(path string) ([]byte, error) {
+	return s.locate(path).read(path)
+}
+
+func (s *detectingAuditStore) write(path string, data []byte) error {
+	return s.locate(path).write(path, data)
+}
+
+func (s *detectingAuditStore) filePath(path string) string {
+	return s.locate(path).filePath(path)
+}
+
+func (s *detectingAuditStore) ownFile(path string) (string, bool) {
+	return s.locate(path).ownFile(path)
+}
+
+func (s *detectingAuditStore) remove(path string) error {
+	return s.locate(path).remove(path)
+}
+
+func (s *detectingAuditStore) rename(oldPath string, newPath string) error {
+	return s.locate(oldPath).rename(oldPath, newPath)
+}
@@ -0,0 +1,129 @@
+package scipipe
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditModes(t *testing.T) {
+	initTestLogs()
+
+	testCases := []struct {
+		mode        AuditMode
+		auditFile   string
+		noAuditFile string
+	}{
+		{AuditModeGzip, "/tmp/auditmode_out.txt.audit.json.gz", "/tmp/auditmode_out.txt.audit.json"},
+		{AuditModeCentral, "/tmp/auditmode.audit.jsonl", "/tmp/auditmode_out.txt.audit.json"},
+	}
+	for _, tc := range testCases {
+		wf := NewWorkflow("TestAuditModesWf", 4)
+		wf.AuditMode = tc.mode
+		wf.AuditLogPath = "/tmp/auditmode.audit.jsonl"
+		echo := wf.NewProc("echo", "echo hej > {o:out}")
+		echo.SetPathStatic("out", "/tmp/auditmode_out.txt")
+		wf.ConnectLast(echo.Out("out"))
+		wf.Run()
+
+		_, err := os.Stat(tc.auditFile)
+		assert.Nil(t, err, "Audit file missing, in audit mode %d", tc.mode)
+		_, err = os.Stat(tc.noAuditFile)
+		assert.True(t, os.IsNotExist(err), "Plain audit file should not be written, in audit mode %d", tc.mode)
+
+		ip := wf.NewInformationPacket("/tmp/auditmode_out.txt")
+		assert.Equal(t, "echo hej > /tmp/auditmode_out.txt.tmp", ip.GetAuditInfo().Command, "Audit info not read back, in audit mode %d", tc.mode)
+
+		ip.Rename("/tmp/auditmode_renamed.txt")
+		renamedIP := wf.NewInformationPacket("/tmp/auditmode_renamed.txt")
+		assert.Equal(t, "echo hej > /tmp/auditmode_out.txt.tmp", renamedIP.GetAuditInfo().Command, "Audit info not found after rename, in audit mode %d", tc.mode)
+
+		// A fresh store has to read the audit info back from disk
+		freshIP := NewInformationPacket("/tmp/auditmode_renamed.txt")
+		freshIP.audits = newAuditStore(tc.mode, "/tmp/auditmode.audit.jsonl")
+		if tc.mode == AuditModeCentral {
+			freshIP.audits = &centralAuditStore{logPath: "/tmp/auditmode.audit.jsonl"}
+		}
+		assert.Equal(t, "echo hej > /tmp/auditmode_out.txt.tmp", freshIP.GetAuditInfo().Command, "Audit info not read back from disk, in audit mode %d", tc.mode)
+
+		// Plain packets have to find the audit info in any layout
+		assert.Equal(t, "echo hej > /tmp/auditmode_out.txt.tmp", NewInformationPacket("/tmp/auditmode_renamed.txt").GetAuditInfo().Command, "Audit info not found by plain packet, in audit mode %d", tc.mode)
+
+		cleanFiles("/tmp/auditmode_renamed.txt", "/tmp/auditmode_renamed.txt.audit.json.gz", "/tmp/auditmode.audit.jsonl")
+	}
+}
+
+func TestAuditModes_ConcurrentWorkflows(t *testing.T) {
+	initTestLogs()
+
+	centralWf := NewWorkflow("TestAuditModesCentralWf", 4)
+	centralWf.AuditMode = AuditModeCentral
+	centralWf.AuditLogPath = "/tmp/auditmode_sep.audit.jsonl"
+	foo := centralWf.NewProc("foo", "sleep 0.1 && echo foo {p:i} > {o:out}")
+	foo.SetPathPattern("out", "/tmp/auditmode_sep_foo_{p:i}.txt")
+	foo.ParamPort("i").ConnectStr("1", "2", "3")
+	centralWf.ConnectLast(foo.Out("out"))
+
+	filesWf := NewWorkflow("TestAuditModesFilesWf", 4)
+	bar := filesWf.NewProc("bar", "sleep 0.1 && echo bar {p:i} > {o:out}")
+	bar.SetPathPattern("out", "/tmp/auditmode_sep_bar_{p:i}.txt")
+	bar.ParamPort("i").ConnectStr("1", "2", "3")
+	filesWf.ConnectLast(bar.Out("out"))
+
+	var wg sync.WaitGroup
+	for _, wf := range []*Workflow{centralWf, filesWf} {
+		wg.Add(1)
+		go func(wf *Workflow) {
+			defer wg.Done()
+			wf.Run()
+		}(wf)
+	}
+	wg.Wait()
+
+	for _, i := range []string{"1", "2", "3"} {
+		fooPath := "/tmp/auditmode_sep_foo_" + i + ".txt"
+		barPath := "/tmp/auditmode_sep_bar_" + i + ".txt"
+		_, err := os.Stat(fooPath + ".audit.json")
+		assert.True(t, os.IsNotExist(err), "The central workflow should not write audit files")
+		_, err = os.Stat(barPath + ".audit.json")
+		assert.Nil(t, err, "The other workflow should write plain audit files")
+		assert.Equal(t, "sleep 0.1 && echo foo "+i+" > "+fooPath+".tmp", NewInformationPacket(fooPath).GetAuditInfo().Command, "Audit info should be read from the central log")
+		assert.Equal(t, "sleep 0.1 && echo bar "+i+" > "+barPath+".tmp", NewInformationPacket(barPath).GetAuditInfo().Command, "Audit info should be read from the audit file")
+		cleanFiles(fooPath, barPath)
+	}
+	cleanFiles("/tmp/auditmode_sep.audit.jsonl")
+}
+
+func TestBackfillAudit(t *testing.T) {
+	initTestLogs()
+
+	preExisting := NewInformationPacket("/tmp/backfill_foo.txt")
+	cleanFiles(preExisting.GetPath(), preExisting.GetAuditFilePath(), "/tmp/backfill_bar.txt", "/tmp/backfill_bar.txt.audit.json")
+	err := ioutil.WriteFile(preExisting.GetPath(), []byte("foo\n"), 0644)
+	assert.Nil(t, err)
+
+	wf := NewWorkflow("TestBackfillAuditWf", 4)
+	wf.BackfillAudit = true
+	foo := wf.NewProc("foo", "echo foo > {o:out}")
+	foo.SetPathStatic("out", preExisting.GetPath())
+	f2b := wf.NewProc("f2b", "sed 's/foo/bar/' {i:in} > {o:out}")
+	f2b.SetPathStatic("out", "/tmp/backfill_bar.txt")
+	f2b.In("in").Connect(foo.Out("out"))
+	wf.ConnectLast(f2b.Out("out"))
+	wf.Run()
+
+	auditInfo := NewInformationPacket(preExisting.GetPath()).GetAuditInfo()
+	assert.True(t, auditInfo.PreExisting, "Backfilled audit info should be marked as pre-existing")
+	assert.Equal(t, preExisting.GetPath(), auditInfo.Path)
+	// SHA-256 of "foo\n"
+	assert.Equal(t, "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c", auditInfo.SHA256)
+
+	upstream := NewInformationPacket("/tmp/backfill_bar.txt").GetAuditInfo().Upstream[preExisting.GetPath()]
+	if assert.NotNil(t, upstream, "Backfilled audit info missing in downstream audit info") {
+		assert.True(t, upstream.PreExisting)
+	}
+	cleanFiles(preExisting.GetPath(), preExisting.GetAuditFilePath(), "/tmp/backfill_bar.txt", "/tmp/backfill_bar.txt.audit.json")
+}
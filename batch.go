@@ -0,0 +1,240 @@
+package scipipe
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	str "strings"
+	"sync"
+	"time"
+)
+
+// ================== BatchExecutor ==================
+
+// BatchExecutor collects the formatted commands of multiple tasks, and executes
+// them together as one single shell script, to amortize the overhead of
+// starting up each job separately, which can be significant on some
+// schedulers.
+//
+// A batch is executed as soon as BatchSize commands have been collected, or
+// when Window has passed since the first command of the batch was added,
+// whichever happens first. A batch is counted as one task (using one slot)
+// against the max concurrent tasks of the workflow.
+//
+// The exit status, stdout and stderr of each command in the batch are recorded
+// separately, and handed back to the task it belongs to, so that a failing
+// command in a batch only makes its own task fail, while the other tasks of
+// the batch succeed as usual. If the batch script itself can not be executed, all tasks in the
+// batch fail.
+//
+// Processes with a BatchExecutor can not use Script, Sandbox,
+// FailOnStderrPattern, or other stdout and stderr modes than
+// OutputModeCapture, which makes the validation of the workflow fail.
+type BatchExecutor struct {
+	BatchSize int
+	Window    time.Duration
+	mx        sync.Mutex
+	pending   []*batchItem
+	timer     *time.Timer
+}
+
+type batchItem struct {
+	task *SciTask
+	done chan error
+}
+
+// NewBatchExecutor returns a BatchExecutor executing up to batchSize commands
+// at a time, waiting at most window for a batch to fill up.
+func NewBatchExecutor(batchSize int, window time.Duration) *BatchExecutor {
+	if batchSize < 1 {
+		Error.Fatalf("BatchExecutor: Batch size has to be at least 1, was %d\n", batchSize)
+	}
+	return &BatchExecutor{
+		BatchSize: batchSize,
+		Window:    window,
+	}
+}
+
+// Supports returns an error if the process p uses features that can not be
+// used with commands executed in batches
+func (be *BatchExecutor) Supports(p *SciProcess) error {
+	unsupported := []string{}
+	if p.Script {
+		unsupported = append(unsupported, "Script")
+	}
+	if p.Sandbox {
+		unsupported = append(unsupported, "Sandbox")
+	}
+	if p.FailOnStderrPattern != nil {
+		unsupported = append(unsupported, "FailOnStderrPattern")
+	}
+	if p.StdoutMode != OutputModeCapture || p.StderrMode != OutputModeCapture {
+		unsupported = append(unsupported, "other StdoutMode and StderrMode than OutputModeCapture")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Process %s: Can not be executed with a BatchExecutor, since it uses: %s", p.name, str.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Execute adds the command of task t to the current batch, and blocks until
+// the batch has been executed, returning the error of the task's own command,
+// if any.
+func (be *BatchExecutor) Execute(t *SciTask) error {
+	item := &batchItem{
+		task: t,
+		done: make(chan error, 1),
+	}
+	be.mx.Lock()
+	be.pending = append(be.pending, item)
+	if len(be.pending) >= be.BatchSize {
+		batch := be.takeBatch()
+		be.mx.Unlock()
+		go be.runBatch(batch)
+	} else {
+		if len(be.pending) == 1 {
+			be.timer = time.AfterFunc(be.Window, be.flush)
+		}
+		be.mx.Unlock()
+	}
+	return <-item.done
+}
+
+// flush executes the currently pending batch, if it is not empty
+func (be *BatchExecutor) flush() {
+	be.mx.Lock()
+	batch := be.takeBatch()
+	be.mx.Unlock()
+	if len(batch) > 0 {
+		be.runBatch(batch)
+	}
+}
+
+// takeBatch returns the pending batch and starts a new one. The mutex has to
+// be held by the caller.
+func (be *BatchExecutor) takeBatch() []*batchItem {
+	if be.timer != nil {
+		be.timer.Stop()
+		be.timer = nil
+	}
+	batch := be.pending
+	be.pending = nil
+	return batch
+}
+
+// runBatch executes all the commands of the batch in one shell script, and
+// hands back the exit status of each command to its task. The slot of the
+// batch is released before that, since the workflow might finish as soon as
+// the tasks are done.
+func (be *BatchExecutor) runBatch(batch []*batchItem) {
+	wf := batch[0].task.workflow
+	wf.IncConcurrentTasks(1)
+	errs := be.executeBatch(wf, batch)
+	wf.DecConcurrentTasks(1)
+
+	for i, item := range batch {
+		item.done <- errs[i]
+	}
+}
+
+func (be *BatchExecutor) executeBatch(wf *Workflow, batch []*batchItem) []error {
+	errs := make([]error, len(batch))
+	setAll := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// The exit statuses of the commands, and their stdout and stderr, are
+	// written to files in a directory of the batch, to be handed back to
+	// their tasks separately
+	batchDir, err := ioutil.TempDir("", "scipipe_batch_")
+	if err != nil {
+		return setAll(fmt.Errorf("Could not create directory for batch: %s", err))
+	}
+	defer os.RemoveAll(batchDir)
+	statusPath := filepath.Join(batchDir, "status")
+
+	script := ""
+	for i, item := range batch {
+		Audit.Printf("Task:%-12s [%s] Executing command in batch: %s\n", item.task.Name, item.task.ID, item.task.Command)
+		// Each command is executed with the shell of its task, while the
+		// batch script itself, which only runs the commands and records their
+		// exit statuses, is executed with the package-level ShellCommand
+		stdoutPath, stderrPath := batchOutputPaths(batchDir, i)
+		script += fmt.Sprintf("%s > %s 2> %s\necho \"%d $?\" >> %s\n", shellCommandLine(item.task.shell(), item.task.envCommand()), shellQuote(stdoutPath), shellQuote(stderrPath), i, shellQuote(statusPath))
+	}
+	Debug.Printf("BatchExecutor: Executing batch of %d commands\n", len(batch))
+	command := exec.CommandContext(wf.ctx, ShellCommand[0], append(ShellCommand[1:len(ShellCommand):len(ShellCommand)], script)...)
+	if wf.killsProcessGroups() {
+		killProcessGroupOnCancel(command)
+	}
+	out, err := command.CombinedOutput()
+	if err != nil {
+		return setAll(fmt.Errorf("Batch script failed!\nScript:\n%s\n\nOutput:\n%s\n", script, string(out)))
+	}
+
+	exitStatuses, err := readBatchExitStatuses(statusPath)
+	if err != nil {
+		return setAll(err)
+	}
+	for i, item := range batch {
+		stdoutPath, stderrPath := batchOutputPaths(batchDir, i)
+		stdout, _ := ioutil.ReadFile(stdoutPath)
+		stderr, _ := ioutil.ReadFile(stderrPath)
+		item.task.Stdout = string(stdout)
+		item.task.Stderr = string(stderr)
+		exitStatus, ok := exitStatuses[i]
+		if !ok {
+			errs[i] = fmt.Errorf("No exit status recorded for command in batch: %s", item.task.Command)
+			continue
+		}
+		item.task.ExitStatus = exitStatus
+		if exitStatus != 0 {
+			errs[i] = &CommandError{
+				Command:  item.task.Command,
+				ExitCode: exitStatus,
+				Stdout:   item.task.Stdout,
+				Stderr:   item.task.Stderr,
+			}
+		}
+	}
+	return errs
+}
+
+// batchOutputPaths returns the paths of the files that the stdout and stderr
+// of command i of a batch are written to, in the directory batchDir
+func batchOutputPaths(batchDir string, i int) (stdoutPath string, stderrPath string) {
+	return filepath.Join(batchDir, strconv.Itoa(i)+".stdout"), filepath.Join(batchDir, strconv.Itoa(i)+".stderr")
+}
+
+// readBatchExitStatuses reads the status file written by a batch script,
+// containing the index of each command and its exit status, one per line
+func readBatchExitStatuses(path string) (map[int]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open status file for batch: %s", err)
+	}
+	defer f.Close()
+
+	exitStatuses := map[int]int{}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		fields := str.Fields(scan.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		idx, idxErr := strconv.Atoi(fields[0])
+		exitStatus, statusErr := strconv.Atoi(fields[1])
+		if idxErr != nil || statusErr != nil {
+			continue
+		}
+		exitStatuses[idx] = exitStatus
+	}
+	return exitStatuses, scan.Err()
+}
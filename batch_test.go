@@ -0,0 +1,52 @@
+package scipipe
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchExecutor(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestBatchExecutorWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/batch_" + t.Param("msg") + ".txt" })
+	echo.BatchExecutor = NewBatchExecutor(2, 100*time.Millisecond)
+	echo.ParamPort("msg").ConnectStr("a", "b", "c")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		_, err := os.Stat("/tmp/batch_" + msg + ".txt")
+		assert.Nil(t, err, "Output of batched command missing for: "+msg)
+	}
+	cleanFiles("/tmp/batch_a.txt", "/tmp/batch_b.txt", "/tmp/batch_c.txt")
+}
+
+func TestBatchExecutor_PartialFailure(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestBatchExecutorPartialFailureWf", 4)
+	be := NewBatchExecutor(2, time.Second)
+	okTask := NewSciTask(wf, "ok", "echo ok out; echo ok err >&2", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	failTask := NewSciTask(wf, "fail", "echo fail out; echo fail err >&2; exit 3", nil, nil, nil, nil, "", ExecModeLocal, 1)
+
+	errs := make(chan error)
+	go func() { errs <- be.Execute(okTask) }()
+	failErr := be.Execute(failTask)
+	okErr := <-errs
+
+	assert.Nil(t, okErr, "Successful command in batch should not return error")
+	assert.NotNil(t, failErr, "Failing command in batch should return error")
+	if cmdErr, ok := failErr.(*CommandError); assert.True(t, ok, "Error should be a *CommandError") {
+		assert.Equal(t, 3, cmdErr.ExitCode, "Wrong exit code of failing command in batch")
+		assert.Equal(t, "fail out\n", cmdErr.Stdout, "Error should only contain the stdout of its own command")
+		assert.Equal(t, "fail err\n", cmdErr.Stderr, "Error should only contain the stderr of its own command")
+	}
+	assert.Equal(t, "ok out\n", okTask.Stdout, "Stdout of batched command not set on its task")
+	assert.Equal(t, "ok err\n", okTask.Stderr, "Stderr of batched command not set on its task")
+	assert.Equal(t, "fail out\n", failTask.Stdout)
+}
@@ -0,0 +1,42 @@
+package scipipe
+
+import (
+	"time"
+)
+
+// clock provides the current time and waiting, so that a mock clock can be
+// used in tests of timing dependent behaviour, without real waits
+type clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker delivers the current time on the channel returned by C, once per the
+// interval it was created with, until stopped, like time.Ticker
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the clock used by default, using the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) ticker       { return &realTicker{time.NewTicker(d)} }
+
+// realTicker is the ticker of realClock, wrapping a time.Ticker
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t *realTicker) Stop()               { t.ticker.Stop() }
+
+// clk is the clock used throughout scipipe. It can be replaced in tests.
+var clk clock = realClock{}
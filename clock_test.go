@@ -0,0 +1,161 @@
+package scipipe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockClock is a clock for tests, where sleeping advances the time instantly,
+// and tickers tick only when the time is advanced
+type mockClock struct {
+	mx      sync.Mutex
+	now     time.Time
+	slept   []time.Duration
+	onSleep func()
+	tickers []*mockTicker
+}
+
+// mockTicker is a ticker of a mockClock
+type mockTicker struct {
+	clock    *mockClock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.c }
+
+func (t *mockTicker) Stop() {
+	t.clock.mx.Lock()
+	defer t.clock.mx.Unlock()
+	t.stopped = true
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *mockClock) Sleep(d time.Duration) {
+	c.mx.Lock()
+	c.slept = append(c.slept, d)
+	onSleep := c.onSleep
+	c.mx.Unlock()
+	c.Advance(d)
+	if onSleep != nil {
+		onSleep()
+	}
+}
+
+// Advance moves the time forward by d, making the tickers that are due tick.
+// As for time.Ticker, ticks are dropped for slow receivers.
+func (c *mockClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.interval)
+		}
+	}
+}
+
+func (c *mockClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *mockClock) NewTicker(d time.Duration) ticker {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	t := &mockTicker{clock: c, c: make(chan time.Time, 1), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// useMockClock replaces the clock with a mock clock, and returns it together
+// with a function restoring the real clock
+func useMockClock() (*mockClock, func()) {
+	mock := newMockClock()
+	clk = mock
+	return mock, func() { clk = realClock{} }
+}
+
+func TestWaitForLaunch_MockClock(t *testing.T) {
+	mock, restore := useMockClock()
+	defer restore()
+
+	wf := NewWorkflow("TestWaitForLaunchMockClockWf", 4)
+	p := wf.NewProc("p", "echo hej")
+	p.MaxLaunchesPerSecond = 4
+
+	var lastLaunch time.Time
+	for i := 0; i < 3; i++ {
+		lastLaunch = p.waitForLaunch(lastLaunch)
+	}
+
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, 250 * time.Millisecond}, mock.slept, "Wrong waits between launches")
+}
+
+func TestAtomize_MockClock(t *testing.T) {
+	mock, restore := useMockClock()
+	defer restore()
+
+	ip := NewInformationPacket("/tmp/atomize_mockclock.txt")
+	// Create the temp file only when the atomizing has started waiting for it
+	mock.onSleep = func() {
+		ip.WriteTempFile([]byte("hej\n"))
+	}
+	ip.Atomize()
+
+	assert.True(t, ip.Exists(), "File should exist after atomizing")
+	assert.Equal(t, []time.Duration{time.Second}, mock.slept, "Atomize should have waited once, without real waiting")
+	cleanFiles(ip.GetPath())
+}
+
+func TestMockClock_Ticker(t *testing.T) {
+	mock := newMockClock()
+	tick := mock.NewTicker(time.Second)
+
+	mock.Advance(500 * time.Millisecond)
+	select {
+	case <-tick.C():
+		t.Error("Ticker should not tick before its interval has passed")
+	default:
+	}
+
+	mock.Advance(500 * time.Millisecond)
+	select {
+	case tm := <-tick.C():
+		assert.Equal(t, mock.Now(), tm, "Wrong time of tick")
+	default:
+		t.Error("Ticker should tick when its interval has passed")
+	}
+
+	tick.Stop()
+	mock.Advance(time.Second)
+	select {
+	case <-tick.C():
+		t.Error("Stopped ticker should not tick")
+	default:
+	}
+}
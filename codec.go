@@ -0,0 +1,70 @@
+package scipipe
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+// ================== Codec ==================
+
+// Codec serializes a stream of Go values (records) to a file, and back. It is
+// used to back a port with files in a specific format, so that records
+// produced in one process can be persisted to disk, and read back in a
+// downstream process, or in a later, resumed, run of the workflow, where the
+// existing file is picked up in place of re-running the producer.
+//
+// Note that ports in scipipe are not (yet) typed, so the record types written
+// and read have to be agreed upon by the producing and the consuming process.
+type Codec interface {
+	NewEncoder(w io.Writer) RecordEncoder
+	NewDecoder(r io.Reader) RecordDecoder
+}
+
+// RecordEncoder writes one record at a time to an underlying writer
+type RecordEncoder interface {
+	Encode(v interface{}) error
+}
+
+// RecordDecoder reads one record at a time from an underlying reader, and
+// returns io.EOF when there are no more records
+type RecordDecoder interface {
+	Decode(v interface{}) error
+}
+
+// JSONLinesCodec writes records as newline-delimited JSON, one record per
+// line. This is the default codec of ports, as the files are easy to inspect.
+type JSONLinesCodec struct{}
+
+func (c JSONLinesCodec) NewEncoder(w io.Writer) RecordEncoder {
+	return json.NewEncoder(w)
+}
+
+func (c JSONLinesCodec) NewDecoder(r io.Reader) RecordDecoder {
+	return json.NewDecoder(r)
+}
+
+// GobCodec writes records as a gob stream, which is more compact and faster
+// to decode than JSON, but not human readable
+type GobCodec struct{}
+
+func (c GobCodec) NewEncoder(w io.Writer) RecordEncoder {
+	return gob.NewEncoder(w)
+}
+
+func (c GobCodec) NewDecoder(r io.Reader) RecordDecoder {
+	return gob.NewDecoder(r)
+}
+
+// SetPortCodec sets the codec used for the files sent or received on port.
+// When two ports are connected, a codec set on one of them is also set on the
+// other one, so the codec only needs to be set on one side, before connecting.
+func SetPortCodec(port *FilePort, codec Codec) {
+	port.codec = codec
+}
+
+// sameCodec tells whether the codecs c1 and c2 are of the same type
+func sameCodec(c1 Codec, c2 Codec) bool {
+	return reflect.TypeOf(c1) == reflect.TypeOf(c2)
+}
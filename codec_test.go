@@ -0,0 +1,81 @@
+package scipipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecTestRecord struct {
+	Name  string
+	Count int
+}
+
+func TestSetPortCodec_PropagatesOnConnect(t *testing.T) {
+	initTestLogs()
+
+	outPort := NewFilePort()
+	inPort := NewFilePort()
+	assert.IsType(t, JSONLinesCodec{}, inPort.Codec(), "Ports should use JSON lines by default")
+
+	SetPortCodec(outPort, GobCodec{})
+	inPort.Connect(outPort)
+	assert.IsType(t, GobCodec{}, inPort.Codec(), "Codec should be propagated to the connected port")
+}
+
+func TestCodecs_SerializeAndResumeStream(t *testing.T) {
+	initTestLogs()
+
+	records := []codecTestRecord{{"a", 1}, {"b", 2}, {"c", 3}}
+	for _, codec := range []Codec{JSONLinesCodec{}, GobCodec{}} {
+		path := "/tmp/codec_test_records.dat"
+		cleanFiles(path)
+
+		producerRuns := 0
+		// produce writes the records to a file sent on a port, unless the file
+		// already exists, as would be the case in a resumed run
+		produce := func() []codecTestRecord {
+			outPort := NewFilePort()
+			inPort := NewFilePort()
+			SetPortCodec(outPort, codec)
+			inPort.Connect(outPort)
+
+			go func() {
+				defer outPort.Close()
+				ip := NewInformationPacket(path)
+				if !ip.Exists() {
+					producerRuns++
+					recCh := make(chan codecTestRecord)
+					go func() {
+						defer close(recCh)
+						for _, rec := range records {
+							recCh <- rec
+						}
+					}()
+					ip.WriteRecords(outPort.Codec(), recCh)
+				}
+				outPort.Send(ip)
+			}()
+
+			readRecs := []codecTestRecord{}
+			for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+				ip.ReadRecords(inPort.Codec(), &readRecs)
+			}
+			return readRecs
+		}
+
+		assert.Equal(t, records, produce(), "Wrong records read back with codec %T", codec)
+		assert.Equal(t, records, produce(), "Wrong records read back with codec %T, in resumed run", codec)
+		assert.Equal(t, 1, producerRuns, "Records should only be written once, with codec %T", codec)
+
+		recCh := make(chan codecTestRecord, len(records))
+		NewInformationPacket(path).ReadRecords(codec, recCh)
+		readRecs := []codecTestRecord{}
+		for rec := range recCh {
+			readRecs = append(readRecs, rec)
+		}
+		assert.Equal(t, records, readRecs, "Wrong records read back on channel with codec %T", codec)
+
+		cleanFiles(path)
+	}
+}
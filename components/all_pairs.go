@@ -0,0 +1,89 @@
+package components
+
+import (
+	"path/filepath"
+
+	"github.com/scipipe/scipipe"
+)
+
+// AllPairs receives all the information packets on its In in-port, and then
+// sends them paired up on its A and B out-ports, one pair at a time, for every
+// unordered pair of packets (or every ordered pair, with Ordered set), such as
+// for all-vs-all comparisons. A downstream process with in-ports connected to
+// A and B then gets one task per pair. With IncludeSelf set, each packet is
+// also paired with itself.
+//
+// All packets are tagged with the key "pair", combining the file names of
+// the pair, such as "x.txt_vs_y.txt", which can be used for naming outputs.
+// The keys of the packets sent on B are prefixed with "b.", so that they do
+// not clash with the keys of the packets sent on A, when combined downstream.
+//
+// Note that all packets have to be received before any pairs can be sent,
+// and that the number of pairs grows quadratically with the number of
+// packets.
+type AllPairs struct {
+	scipipe.Process
+	name        string
+	In          *scipipe.FilePort
+	A           *scipipe.FilePort
+	B           *scipipe.FilePort
+	Ordered     bool
+	IncludeSelf bool
+}
+
+// NewAllPairs returns a new AllPairs, sending unordered pairs without
+// self-pairs
+func NewAllPairs(wf *scipipe.Workflow, name string) *AllPairs {
+	p := &AllPairs{
+		name: name,
+		In:   scipipe.NewFilePort(),
+		A:    scipipe.NewFilePort(),
+		B:    scipipe.NewFilePort(),
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *AllPairs) Name() string {
+	return p.name
+}
+
+func (p *AllPairs) IsConnected() bool {
+	return p.In.IsConnected() && p.A.IsConnected() && p.B.IsConnected()
+}
+
+// Run the AllPairs
+func (p *AllPairs) Run() {
+	defer p.A.Close()
+	defer p.B.Close()
+
+	ips := []*scipipe.InformationPacket{}
+	for ip := p.In.Recv(); ip != nil; ip = p.In.Recv() {
+		ips = append(ips, ip)
+	}
+	for i, a := range ips {
+		for j, b := range ips {
+			if (i == j && !p.IncludeSelf) || (j < i && !p.Ordered) {
+				continue
+			}
+			pairKey := filepath.Base(a.GetPath()) + "_vs_" + filepath.Base(b.GetPath())
+			p.A.Send(pairedIP(a, "", pairKey))
+			p.B.Send(pairedIP(b, "b.", pairKey))
+		}
+	}
+}
+
+// pairedIP returns a new packet for the same file as ip, with the keys of ip
+// prefixed with keyPrefix, and the key "pair" set to pairKey. A new packet is
+// needed, since the same packet is part of many pairs.
+func pairedIP(ip *scipipe.InformationPacket, keyPrefix string, pairKey string) *scipipe.InformationPacket {
+	auditInfo := *ip.GetAuditInfo()
+	auditInfo.Keys = map[string]string{}
+	for k, v := range ip.GetKeys() {
+		auditInfo.Keys[keyPrefix+k] = v
+	}
+	auditInfo.Keys["pair"] = pairKey
+	newIP := scipipe.NewInformationPacket(ip.GetPath())
+	newIP.SetAuditInfo(&auditInfo)
+	return newIP
+}
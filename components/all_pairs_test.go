@@ -0,0 +1,48 @@
+package components
+
+import (
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllPairs(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	testCases := []struct {
+		ordered     bool
+		includeSelf bool
+		expected    []string
+	}{
+		{false, false, []string{"x_vs_y", "x_vs_z", "y_vs_z"}},
+		{false, true, []string{"x_vs_x", "x_vs_y", "x_vs_z", "y_vs_y", "y_vs_z", "z_vs_z"}},
+		{true, false, []string{"x_vs_y", "x_vs_z", "y_vs_x", "y_vs_z", "z_vs_x", "z_vs_y"}},
+	}
+	for _, tc := range testCases {
+		wf := scipipe.NewWorkflow("TestAllPairsWf", 4)
+		ipGen := scipipe.NewIPGen(wf, "ipgen", "/tmp/allpairs/x", "/tmp/allpairs/y", "/tmp/allpairs/z")
+		allPairs := NewAllPairs(wf, "allpairs")
+		allPairs.Ordered = tc.ordered
+		allPairs.IncludeSelf = tc.includeSelf
+		allPairs.In.Connect(ipGen.Out)
+
+		inA := scipipe.NewFilePort()
+		inA.Connect(allPairs.A)
+		inB := scipipe.NewFilePort()
+		inB.Connect(allPairs.B)
+		go ipGen.Run()
+		go allPairs.Run()
+
+		pairs := []string{}
+		for a := inA.Recv(); a != nil; a = inA.Recv() {
+			b := inB.Recv()
+			pair := a.GetPath()[len(a.GetPath())-1:] + "_vs_" + b.GetPath()[len(b.GetPath())-1:]
+			assert.Equal(t, pair, a.GetKey("pair"), "Wrong pair key on A")
+			assert.Equal(t, pair, b.GetKey("pair"), "Wrong pair key on B")
+			pairs = append(pairs, pair)
+		}
+		assert.Nil(t, inB.Recv(), "B should be closed together with A")
+		assert.Equal(t, tc.expected, pairs, "Wrong pairs, with ordered = %v, includeSelf = %v", tc.ordered, tc.includeSelf)
+	}
+}
@@ -0,0 +1,88 @@
+package components
+
+import (
+	"path/filepath"
+	str "strings"
+
+	"github.com/scipipe/scipipe"
+)
+
+// ChecksumAlgorithm is a digest algorithm that can be used by Checksummer
+type ChecksumAlgorithm string
+
+const (
+	ChecksumMD5    ChecksumAlgorithm = "md5"
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+)
+
+// Checksummer computes a checksum of each file coming in on its In in-port,
+// with the algorithm Algorithm, and writes it to a sidecar file, named as the
+// file plus ".md5" or ".sha256", in the format of md5sum and sha256sum (the
+// hex digest, two spaces and the file name), so that it can be checked with
+// "md5sum -c" in the directory of the file. The digest is added to the packet
+// as the key "md5" or "sha256", after which the packet is passed on, on its
+// Out out-port. If the sidecar file already exists, the digest is read from
+// it, instead of being computed again.
+type Checksummer struct {
+	scipipe.Process
+	name      string
+	In        *scipipe.FilePort
+	Out       *scipipe.FilePort
+	Algorithm ChecksumAlgorithm
+}
+
+// NewChecksummer returns a new Checksummer, computing checksums with the
+// algorithm algo
+func NewChecksummer(wf *scipipe.Workflow, name string, algo ChecksumAlgorithm) *Checksummer {
+	if algo != ChecksumMD5 && algo != ChecksumSHA256 {
+		scipipe.Error.Fatalf("Checksummer %s: Unknown checksum algorithm: %s\n", name, algo)
+	}
+	p := &Checksummer{
+		name:      name,
+		In:        scipipe.NewFilePort(),
+		Out:       scipipe.NewFilePort(),
+		Algorithm: algo,
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *Checksummer) Name() string {
+	return p.name
+}
+
+func (p *Checksummer) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// Run the Checksummer
+func (p *Checksummer) Run() {
+	defer p.Out.Close()
+
+	for ip := p.In.Recv(); ip != nil; ip = p.In.Recv() {
+		sidecar := scipipe.NewInformationPacket(ip.GetPath() + "." + string(p.Algorithm))
+		var digest string
+		if sidecar.Exists() {
+			scipipe.Info.Printf("Checksummer %s: Checksum file already exists, so not computing checksum again: %s\n", p.name, sidecar.GetPath())
+			fields := str.Fields(string(sidecar.Read()))
+			if len(fields) == 0 {
+				scipipe.Error.Fatalf("Checksummer %s: Empty checksum file: %s\n", p.name, sidecar.GetPath())
+			}
+			digest = fields[0]
+		} else {
+			digest = p.checksum(ip)
+			sidecar.WriteTempFile([]byte(digest + "  " + filepath.Base(ip.GetPath()) + "\n"))
+			sidecar.Atomize()
+		}
+		ip.AddKey(string(p.Algorithm), digest)
+		ip.WriteAuditLogToFile()
+		p.Out.Send(ip)
+	}
+}
+
+func (p *Checksummer) checksum(ip *scipipe.InformationPacket) string {
+	if p.Algorithm == ChecksumMD5 {
+		return ip.GetMD5()
+	}
+	return ip.GetSHA256()
+}
@@ -0,0 +1,57 @@
+package components
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksummer(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	content := []byte("ACGT\nTTGA\n")
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	expectedDigests := map[ChecksumAlgorithm]string{
+		ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+		ChecksumSHA256: hex.EncodeToString(sha256Sum[:]),
+	}
+
+	path := "/tmp/checksummer_test.fa"
+	for algo, expectedDigest := range expectedDigests {
+		err := ioutil.WriteFile(path, content, 0644)
+		assert.Nil(t, err)
+
+		wf := scipipe.NewWorkflow("TestChecksummerWf", 4)
+		checksummer := NewChecksummer(wf, "checksummer", algo)
+		src := scipipe.NewFilePort()
+		checksummer.In.Connect(src)
+		inPort := scipipe.NewFilePort()
+		inPort.Connect(checksummer.Out)
+
+		go func() {
+			defer src.Close()
+			src.Send(scipipe.NewInformationPacket(path))
+		}()
+		go checksummer.Run()
+
+		ip := inPort.Recv()
+		assert.Equal(t, path, ip.GetPath(), "Packet should be passed on")
+		assert.Equal(t, expectedDigest, ip.GetKey(string(algo)), "Wrong digest key for %s", algo)
+		assert.Nil(t, inPort.Recv(), "Out-port should be closed after all packets")
+
+		sidecar, err := ioutil.ReadFile(path + "." + string(algo))
+		assert.Nil(t, err, "Sidecar file missing for %s", algo)
+		assert.Equal(t, expectedDigest+"  checksummer_test.fa\n", string(sidecar), "Wrong content in sidecar file for %s", algo)
+
+		os.Remove(path)
+		os.Remove(path + "." + string(algo))
+		os.Remove(path + ".audit.json")
+	}
+}
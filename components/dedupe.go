@@ -0,0 +1,68 @@
+package components
+
+import (
+	"github.com/scipipe/scipipe"
+)
+
+// Dedupe forwards the packets coming in on its In in-port to its Out
+// out-port, dropping any packet with the same dedup key as an earlier one, so
+// that only the first packet for each key is forwarded. By default, the key
+// is the path of the packet. With Key set, the value of that key of the
+// packet (see InformationPacket.GetKey) is used instead, and with ByContent
+// set, the SHA-256 checksum of the content of the file, so that files with
+// identical content are deduplicated even if their paths differ. The keys
+// seen so far are kept in memory.
+type Dedupe struct {
+	scipipe.Process
+	name      string
+	In        *scipipe.FilePort
+	Out       *scipipe.FilePort
+	Key       string
+	ByContent bool
+}
+
+// NewDedupe returns a new Dedupe, deduplicating packets by path
+func NewDedupe(wf *scipipe.Workflow, name string) *Dedupe {
+	p := &Dedupe{
+		name: name,
+		In:   scipipe.NewFilePort(),
+		Out:  scipipe.NewFilePort(),
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *Dedupe) Name() string {
+	return p.name
+}
+
+func (p *Dedupe) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// dedupKey returns the key to deduplicate ip by
+func (p *Dedupe) dedupKey(ip *scipipe.InformationPacket) string {
+	switch {
+	case p.ByContent:
+		return ip.GetSHA256()
+	case p.Key != "":
+		return ip.GetKey(p.Key)
+	}
+	return ip.GetPath()
+}
+
+// Run the Dedupe
+func (p *Dedupe) Run() {
+	defer p.Out.Close()
+
+	seen := map[string]bool{}
+	for ip := p.In.Recv(); ip != nil; ip = p.In.Recv() {
+		key := p.dedupKey(ip)
+		if seen[key] {
+			scipipe.Debug.Printf("Dedupe %s: Dropping duplicate packet: %s\n", p.name, ip.GetPath())
+			continue
+		}
+		seen[key] = true
+		p.Out.Send(ip)
+	}
+}
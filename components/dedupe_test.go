@@ -0,0 +1,57 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+// runDedupe runs dd on packets for paths, and returns the paths of the
+// forwarded packets
+func runDedupe(wf *scipipe.Workflow, dd *Dedupe, paths ...string) []string {
+	gen := scipipe.NewIPGen(wf, "gen", paths...)
+	dd.In.Connect(gen.Out)
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(dd.Out)
+	go gen.Run()
+	go dd.Run()
+
+	outPaths := []string{}
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		outPaths = append(outPaths, ip.GetPath())
+	}
+	return outPaths
+}
+
+func TestDedupe(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestDedupeWf", 4)
+	dd := NewDedupe(wf, "dedupe")
+	outPaths := runDedupe(wf, dd, "a.txt", "b.txt", "a.txt", "c.txt", "b.txt", "a.txt")
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, outPaths, "Duplicates should only be forwarded once")
+}
+
+func TestDedupe_ByContent(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	files := map[string]string{
+		"/tmp/dedupe_1.txt": "foo\n",
+		"/tmp/dedupe_2.txt": "bar\n",
+		"/tmp/dedupe_3.txt": "foo\n",
+	}
+	for path, content := range files {
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		assert.Nil(t, err)
+		defer os.Remove(path)
+	}
+
+	wf := scipipe.NewWorkflow("TestDedupeByContentWf", 4)
+	dd := NewDedupe(wf, "dedupe")
+	dd.ByContent = true
+	outPaths := runDedupe(wf, dd, "/tmp/dedupe_1.txt", "/tmp/dedupe_2.txt", "/tmp/dedupe_3.txt")
+	assert.Equal(t, []string{"/tmp/dedupe_1.txt", "/tmp/dedupe_2.txt"}, outPaths, "Files with duplicate content should only be forwarded once")
+}
@@ -0,0 +1,160 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scipipe/scipipe"
+)
+
+// DirWatcher watches a directory for new files with names matching a glob
+// pattern (such as "*.fastq.gz"), by polling it every PollInterval, and sends
+// an InformationPacket on its Out out-port for each new file, once it is
+// complete. This can be used for long-running ingestion workflows, where
+// input files arrive over time, such as from a sequencer.
+//
+// To avoid emitting partially written files, a file is by default considered
+// complete when its size and modification time have not changed for
+// StableFor. With UseDoneMarker set, a file is instead considered complete
+// when a marker file, with the same path plus ".done", exists.
+//
+// The watching terminates, and the Out port is closed (so that Workflow.Run
+// can complete), either when Stop is called, or when a file named SentinelFile
+// (if set) appears in the directory. In the latter case, all the matching files
+// existing when the sentinel file is found are emitted when complete, before
+// terminating.
+type DirWatcher struct {
+	scipipe.Process
+	name          string
+	dir           string
+	pattern       string
+	Out           *scipipe.FilePort
+	PollInterval  time.Duration
+	StableFor     time.Duration
+	UseDoneMarker bool
+	SentinelFile  string
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewDirWatcher returns a new DirWatcher, watching the directory dir for files
+// matching the glob pattern
+func NewDirWatcher(wf *scipipe.Workflow, name string, dir string, pattern string) *DirWatcher {
+	p := &DirWatcher{
+		name:         name,
+		dir:          dir,
+		pattern:      pattern,
+		Out:          scipipe.NewFilePort(),
+		PollInterval: time.Second,
+		StableFor:    5 * time.Second,
+		stop:         make(chan struct{}),
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *DirWatcher) Name() string {
+	return p.name
+}
+
+func (p *DirWatcher) IsConnected() bool {
+	return p.Out.IsConnected()
+}
+
+// Stop makes the DirWatcher stop watching and close its out-port, after the
+// current poll. Files not yet emitted are not emitted.
+func (p *DirWatcher) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// fileState is the last observed state of a file, and since when it has been
+// in that state
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// Run the DirWatcher
+func (p *DirWatcher) Run() {
+	defer p.Out.Close()
+
+	emitted := map[string]bool{}
+	states := map[string]fileState{}
+	for {
+		sentinelFound := false
+		if p.SentinelFile != "" {
+			if _, err := os.Stat(filepath.Join(p.dir, p.SentinelFile)); err == nil {
+				sentinelFound = true
+			}
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p.dir, p.pattern))
+		scipipe.CheckErr(err)
+		pending := 0
+		for _, path := range matches {
+			if emitted[path] || p.isHelperFile(path) {
+				continue
+			}
+			if p.isComplete(path, states) {
+				scipipe.Debug.Printf("DirWatcher %s: Found new file: %s\n", p.name, path)
+				emitted[path] = true
+				delete(states, path)
+				p.Out.Send(scipipe.NewInformationPacket(path))
+			} else {
+				pending++
+			}
+		}
+
+		if sentinelFound && pending == 0 {
+			scipipe.Info.Printf("DirWatcher %s: Found sentinel file %s, so stopping\n", p.name, p.SentinelFile)
+			return
+		}
+		select {
+		case <-p.stop:
+			scipipe.Info.Printf("DirWatcher %s: Stopped\n", p.name)
+			return
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// isHelperFile returns true for files that should never be emitted, even if
+// matching the pattern, such as marker, audit and temporary files
+func (p *DirWatcher) isHelperFile(path string) bool {
+	base := filepath.Base(path)
+	if p.SentinelFile != "" && base == p.SentinelFile {
+		return true
+	}
+	for _, ext := range []string{".done", ".audit.json", ".audit.json.gz", ".tmp", ".fifo"} {
+		if strings.HasSuffix(base, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isComplete checks if the file at path is completely written, according to
+// the configured strategy, updating the observed states of files as needed
+func (p *DirWatcher) isComplete(path string, states map[string]fileState) bool {
+	if p.UseDoneMarker {
+		_, err := os.Stat(path + ".done")
+		return err == nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	state, seen := states[path]
+	if !seen || state.size != fi.Size() || !state.modTime.Equal(fi.ModTime()) {
+		states[path] = fileState{size: fi.Size(), modTime: fi.ModTime(), since: now}
+		return false
+	}
+	return now.Sub(state.since) >= p.StableFor
+}
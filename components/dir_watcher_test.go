@@ -0,0 +1,43 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirWatcher(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	dir := "/tmp/dirwatcher_test"
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, 0777)
+	assert.Nil(t, err)
+
+	wf := scipipe.NewWorkflow("TestDirWatcherWf", 4)
+	watcher := NewDirWatcher(wf, "watcher", dir, "*.txt")
+	watcher.PollInterval = 10 * time.Millisecond
+	watcher.StableFor = 50 * time.Millisecond
+	watcher.SentinelFile = "ALL_DONE"
+
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(watcher.Out)
+	go watcher.Run()
+
+	ioutil.WriteFile(dir+"/a.txt", []byte("a\n"), 0644)
+	time.Sleep(100 * time.Millisecond)
+	ioutil.WriteFile(dir+"/b.txt", []byte("b\n"), 0644)
+	ioutil.WriteFile(dir+"/ALL_DONE", []byte{}, 0644)
+
+	paths := []string{}
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		paths = append(paths, ip.GetPath())
+	}
+	assert.Equal(t, []string{dir + "/a.txt", dir + "/b.txt"}, paths, "Wrong files emitted")
+
+	os.RemoveAll(dir)
+}
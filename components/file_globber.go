@@ -2,10 +2,16 @@ package components
 
 import (
 	"path/filepath"
+	"sort"
 
 	"github.com/scipipe/scipipe"
 )
 
+// FileGlobber sends one InformationPacket on its Out-port for each file path
+// matching the shell-style glob pattern (see filepath.Glob), in sorted order.
+// The pattern is resolved when the process runs, so it can match files
+// produced earlier in the workflow. If the pattern is malformed, an error is
+// logged and nothing is sent.
 type FileGlobber struct {
 	scipipe.Process
 	name        string
@@ -31,7 +37,14 @@ func (p *FileGlobber) Run() {
 	defer p.Out.Close()
 
 	matches, err := filepath.Glob(p.globPattern)
-	scipipe.CheckErr(err)
+	if err != nil {
+		scipipe.Error.Printf("FileGlobber %s: Could not match file paths with the pattern %s: %s\n", p.name, p.globPattern, err)
+		return
+	}
+	if len(matches) == 0 {
+		scipipe.Warning.Printf("FileGlobber %s: No file paths matched the pattern %s\n", p.name, p.globPattern)
+	}
+	sort.Strings(matches)
 
 	for _, m := range matches {
 		ip := scipipe.NewInformationPacket(m)
@@ -0,0 +1,44 @@
+package components
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileGlobber(t *testing.T) {
+	scipipe.InitLogWarning()
+	dir := "/tmp/file_globber_test"
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, 0777)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"c.txt", "a.txt", "b.txt", "other.csv"} {
+		err := ioutil.WriteFile(dir+"/"+name, []byte(name), 0644)
+		assert.Nil(t, err)
+	}
+
+	runGlob := func(pattern string) []string {
+		fg := NewFileGlobber(scipipe.NewWorkflow("TestFileGlobberWf", 4), "globber", pattern)
+		sent := fg.Out.Subscribe()
+		fg.Run()
+		paths := []string{}
+		for ip := range sent {
+			paths = append(paths, ip.GetPath())
+		}
+		return paths
+	}
+
+	assert.Equal(t, []string{dir + "/a.txt", dir + "/b.txt", dir + "/c.txt"}, runGlob(dir+"/*.txt"), "Matching paths should be sent in sorted order")
+	assert.Equal(t, []string{}, runGlob(dir+"/*.fastq"), "Nothing should be sent when no paths match")
+
+	errorLog := &bytes.Buffer{}
+	scipipe.InitLog(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard, errorLog)
+	defer scipipe.InitLogWarning()
+	assert.Equal(t, []string{}, runGlob(dir+"/[a-"), "Nothing should be sent for a malformed pattern")
+	assert.Contains(t, errorLog.String(), "Could not match file paths with the pattern", "A malformed pattern should be reported as an error")
+}
@@ -0,0 +1,152 @@
+package components
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/scipipe/scipipe"
+)
+
+// FileStat is a statistic that can be computed by FileStats
+type FileStat int
+
+const (
+	// FileStatSize is the size of the file in bytes, added as the key
+	// "stats.size"
+	FileStatSize FileStat = iota
+	// FileStatLineCount is the number of lines in the file, added as the key
+	// "stats.lines"
+	FileStatLineCount
+	// FileStatSHA256 is the SHA256 checksum of the file, hex encoded, added as
+	// the key "stats.sha256"
+	FileStatSHA256
+	// FileStatFirstLine is the first line of the file, without the trailing
+	// newline, added as the key "stats.firstline"
+	FileStatFirstLine
+)
+
+var fileStatKeys = map[FileStat]string{
+	FileStatSize:      "stats.size",
+	FileStatLineCount: "stats.lines",
+	FileStatSHA256:    "stats.sha256",
+	FileStatFirstLine: "stats.firstline",
+}
+
+// FileStats computes cheap statistics for each file coming in on its In
+// in-port, adds them as keys to the information packet, and passes it on, on
+// its Out out-port. Each file is read only once, in a streaming fashion. Which
+// statistics to compute is configured with Stats, and defaults to all of them.
+// With WriteStatsFile set, the statistics are also written as JSON to a file
+// with the extension ".stats.json" added to the path of the input file.
+type FileStats struct {
+	scipipe.Process
+	name           string
+	In             *scipipe.FilePort
+	Out            *scipipe.FilePort
+	Stats          []FileStat
+	WriteStatsFile bool
+}
+
+// Instantiate a new FileStats, computing all available statistics
+func NewFileStats(wf *scipipe.Workflow, name string) *FileStats {
+	p := &FileStats{
+		name:  name,
+		In:    scipipe.NewFilePort(),
+		Out:   scipipe.NewFilePort(),
+		Stats: []FileStat{FileStatSize, FileStatLineCount, FileStatSHA256, FileStatFirstLine},
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *FileStats) Name() string {
+	return p.name
+}
+
+func (p *FileStats) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// Run the FileStats
+func (p *FileStats) Run() {
+	defer p.Out.Close()
+	go p.In.RunMergeInputs()
+
+	for ip := range p.In.InChan {
+		stats := p.computeStats(ip)
+		ip.AddKeys(stats)
+		ip.WriteAuditLogToFile()
+		if p.WriteStatsFile {
+			statsJson, err := json.MarshalIndent(stats, "", "    ")
+			Check(err)
+			err = ioutil.WriteFile(ip.GetPath()+".stats.json", statsJson, 0644)
+			Check(err)
+		}
+		p.Out.Send(ip)
+	}
+}
+
+// computeStats reads the file of ip once, and computes the configured stats
+func (p *FileStats) computeStats(ip *scipipe.InformationPacket) map[string]string {
+	f := ip.Open()
+	defer f.Close()
+
+	hash := sha256.New()
+	var size, lineCount int64
+	firstLine := []byte{}
+	firstLineDone := false
+	endsWithNewline := true
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		chunk := buf[:n]
+		size += int64(n)
+		hash.Write(chunk)
+		lineCount += int64(bytes.Count(chunk, []byte("\n")))
+		if !firstLineDone {
+			if i := bytes.IndexByte(chunk, '\n'); i > -1 {
+				firstLine = append(firstLine, chunk[:i]...)
+				firstLineDone = true
+			} else {
+				firstLine = append(firstLine, chunk...)
+			}
+		}
+		if n > 0 {
+			endsWithNewline = chunk[n-1] == '\n'
+		}
+		if err == io.EOF {
+			break
+		}
+		Check(err)
+	}
+	// Count a last line without a trailing newline too
+	if !endsWithNewline {
+		lineCount++
+	}
+
+	stats := map[string]string{}
+	for _, stat := range p.Stats {
+		key, ok := fileStatKeys[stat]
+		if !ok {
+			scipipe.Error.Fatalf("FileStats %s: Unknown file stat: %d\n", p.name, stat)
+		}
+		switch stat {
+		case FileStatSize:
+			stats[key] = strconv.FormatInt(size, 10)
+		case FileStatLineCount:
+			stats[key] = strconv.FormatInt(lineCount, 10)
+		case FileStatSHA256:
+			stats[key] = hex.EncodeToString(hash.Sum(nil))
+		case FileStatFirstLine:
+			stats[key] = strings.TrimRight(string(firstLine), "\r")
+		}
+	}
+	return stats
+}
@@ -0,0 +1,42 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStats(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	path := "/tmp/filestats_test.txt"
+	err := ioutil.WriteFile(path, []byte("header\nline 2\nline 3"), 0644)
+	assert.Nil(t, err)
+
+	wf := scipipe.NewWorkflow("TestFileStatsWf", 4)
+	ipGen := scipipe.NewIPGen(wf, "ipgen", path)
+	stats := NewFileStats(wf, "stats")
+	stats.WriteStatsFile = true
+	stats.In.Connect(ipGen.Out)
+
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(stats.Out)
+
+	go ipGen.Run()
+	go stats.Run()
+	ip := inPort.Recv()
+
+	assert.Equal(t, "20", ip.GetKey("stats.size"), "Wrong size")
+	assert.Equal(t, "3", ip.GetKey("stats.lines"), "Wrong line count")
+	assert.Equal(t, "header", ip.GetKey("stats.firstline"), "Wrong first line")
+	assert.Equal(t, "8e9e8eda8d9779cfe1addcf68bcefb0866366bd8926756fa37cc35d593f64829", ip.GetKey("stats.sha256"), "Wrong checksum")
+	_, err = os.Stat(path + ".stats.json")
+	assert.Nil(t, err, "Stats file missing")
+
+	for _, f := range []string{path, path + ".audit.json", path + ".stats.json"} {
+		os.Remove(f)
+	}
+}
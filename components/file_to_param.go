@@ -0,0 +1,15 @@
+package components
+
+import (
+	"github.com/scipipe/scipipe"
+)
+
+// FileToParam reads the content of each file on its InFile in-port, and sends
+// it as a parameter value on its OutParam parameter port. It is another name
+// for IpToParamConverter, which see.
+type FileToParam = IpToParamConverter
+
+// Instantiate a new FileToParam
+func NewFileToParam(wf *scipipe.Workflow, name string) *FileToParam {
+	return NewIpToParamConverter(wf, name)
+}
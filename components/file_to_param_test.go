@@ -0,0 +1,45 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileToParam(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	path := "/tmp/filetoparam.txt"
+	err := ioutil.WriteFile(path, []byte("\n 42\t\nsecond line\n"), 0644)
+	assert.Nil(t, err)
+	defer os.Remove(path)
+
+	for _, tc := range []struct {
+		firstLineOnly bool
+		expected      string
+	}{
+		{false, "42\t\nsecond line"},
+		{true, "42"},
+	} {
+		wf := scipipe.NewWorkflow("TestFileToParamWf", 4)
+		f2p := NewFileToParam(wf, "file_to_param")
+		f2p.FirstLineOnly = tc.firstLineOnly
+		outPort := scipipe.NewFilePort()
+		f2p.InFile.Connect(outPort)
+		params := scipipe.NewParamPort()
+		params.Connect(f2p.OutParam)
+
+		go f2p.Run()
+		outPort.Send(scipipe.NewInformationPacket(path))
+		outPort.Close()
+
+		value, ok := <-params.Chan
+		assert.True(t, ok, "No value received")
+		assert.Equal(t, tc.expected, value, "Wrong value, with FirstLineOnly = %v", tc.firstLineOnly)
+		_, ok = <-params.Chan
+		assert.False(t, ok, "Param port should be closed after all files")
+	}
+}
@@ -7,12 +7,15 @@ import (
 
 // IpToParamConverter takes a file target on its FilePath in-port, reads its
 // content (assuming a single value), removing any newlines, spaces or tabs,
-// and sends the value on the OutParam parameter port.
+// and sends the value on the OutParam parameter port. By default the whole
+// content of the file is used as the value, but with FirstLineOnly set, only
+// the first line of the file is used.
 type IpToParamConverter struct {
 	scipipe.Process
-	name     string
-	InFile   *scipipe.FilePort
-	OutParam *scipipe.ParamPort
+	name          string
+	InFile        *scipipe.FilePort
+	OutParam      *scipipe.ParamPort
+	FirstLineOnly bool
 }
 
 // Instantiate a new IpToParamConverter
@@ -41,6 +44,12 @@ func (p *IpToParamConverter) Run() {
 
 	for ip := range p.InFile.InChan {
 		s := string(ip.Read())
+		if p.FirstLineOnly {
+			s = strings.TrimLeft(s, "\r\n")
+			if i := strings.Index(s, "\n"); i > -1 {
+				s = s[:i]
+			}
+		}
 		s = strings.Trim(s, " \r\n\t")
 		p.OutParam.Send(s)
 	}
@@ -0,0 +1,93 @@
+package components
+
+import (
+	"github.com/scipipe/scipipe"
+)
+
+// KeyRouter routes the information packets coming in on its In in-port to
+// different out-ports, based on the value of the key Key of each packet (see
+// InformationPacket.GetKey), such as for sending tumor and normal samples down
+// different branches of a workflow. There is one out-port per expected key
+// value, given when creating the router, and available with Out(value).
+//
+// Packets with any other value of the key (including packets without the key)
+// are sent on the Default out-port, or, with FailOnUnmatched set, make the
+// workflow fail. Since packets might be sent on Default, it has to be
+// connected unless FailOnUnmatched is set.
+type KeyRouter struct {
+	scipipe.Process
+	name            string
+	In              *scipipe.FilePort
+	Default         *scipipe.FilePort
+	Key             string
+	FailOnUnmatched bool
+	outPorts        map[string]*scipipe.FilePort
+}
+
+// NewKeyRouter returns a new KeyRouter, routing packets by the key key, with
+// one out-port for each of values
+func NewKeyRouter(wf *scipipe.Workflow, name string, key string, values ...string) *KeyRouter {
+	if len(values) == 0 {
+		scipipe.Error.Fatalf("KeyRouter %s: At least one key value to route on is needed\n", name)
+	}
+	p := &KeyRouter{
+		name:     name,
+		In:       scipipe.NewFilePort(),
+		Default:  scipipe.NewFilePort(),
+		Key:      key,
+		outPorts: map[string]*scipipe.FilePort{},
+	}
+	for _, value := range values {
+		p.outPorts[value] = scipipe.NewFilePort()
+	}
+	wf.AddProc(p)
+	return p
+}
+
+// Out returns the out-port for packets with the key value value
+func (p *KeyRouter) Out(value string) *scipipe.FilePort {
+	outPort, ok := p.outPorts[value]
+	if !ok {
+		scipipe.Error.Fatalf("KeyRouter %s: No out-port for key value '%s'. Please check your workflow code!\n", p.name, value)
+	}
+	return outPort
+}
+
+// GetOutPorts returns the out-ports for the expected key values, by value
+func (p *KeyRouter) GetOutPorts() map[string]*scipipe.FilePort {
+	return p.outPorts
+}
+
+func (p *KeyRouter) Name() string {
+	return p.name
+}
+
+func (p *KeyRouter) IsConnected() bool {
+	for _, outPort := range p.outPorts {
+		if !outPort.IsConnected() {
+			return false
+		}
+	}
+	return p.In.IsConnected() && (p.FailOnUnmatched || p.Default.IsConnected())
+}
+
+// Run the KeyRouter
+func (p *KeyRouter) Run() {
+	defer p.Default.Close()
+	for _, outPort := range p.outPorts {
+		defer outPort.Close()
+	}
+
+	for ip := p.In.Recv(); ip != nil; ip = p.In.Recv() {
+		value := ip.GetKey(p.Key)
+		if outPort, ok := p.outPorts[value]; ok {
+			outPort.Send(ip)
+			continue
+		}
+		if p.FailOnUnmatched {
+			scipipe.Error.Fatalf("KeyRouter %s: Unexpected value '%s' of key %s, for packet: %s\n", p.name, value, p.Key, ip.GetPath())
+		}
+		scipipe.Debug.Printf("KeyRouter %s: Sending packet with unmatched value '%s' of key %s on default port: %s\n", p.name, value, p.Key, ip.GetPath())
+		p.Default.Send(ip)
+	}
+}
@@ -0,0 +1,52 @@
+package components
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRouter(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestKeyRouterWf", 4)
+	router := NewKeyRouter(wf, "router", "type", "tumor", "normal")
+
+	src := scipipe.NewFilePort()
+	router.In.Connect(src)
+	go func() {
+		defer src.Close()
+		samples := [][]string{{"s1.bam", "tumor"}, {"s2.bam", "normal"}, {"s3.bam", "tumor"}, {"s4.bam", "unknown"}}
+		for _, sample := range samples {
+			ip := scipipe.NewInformationPacket(sample[0])
+			ip.AddKey("type", sample[1])
+			src.Send(ip)
+		}
+	}()
+
+	received := map[string][]string{}
+	receivedMx := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, outPort := range map[string]*scipipe.FilePort{"tumor": router.Out("tumor"), "normal": router.Out("normal"), "default": router.Default} {
+		inPort := scipipe.NewFilePort()
+		inPort.Connect(outPort)
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+				receivedMx.Lock()
+				received[name] = append(received[name], ip.GetPath())
+				receivedMx.Unlock()
+			}
+		}(name)
+	}
+	assert.True(t, router.IsConnected())
+	router.Run()
+	wg.Wait()
+
+	assert.Equal(t, []string{"s1.bam", "s3.bam"}, received["tumor"], "Wrong packets routed to tumor branch")
+	assert.Equal(t, []string{"s2.bam"}, received["normal"], "Wrong packets routed to normal branch")
+	assert.Equal(t, []string{"s4.bam"}, received["default"], "Unmatched packets should be routed to default port")
+}
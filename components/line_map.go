@@ -0,0 +1,95 @@
+package components
+
+import (
+	"bufio"
+
+	"github.com/scipipe/scipipe"
+)
+
+// LineMap applies the function MapFunc to each line of the files coming in
+// on its In in-port, and writes the resulting lines to new files, which are
+// sent on its Out out-port. Lines for which MapFunc returns an empty string
+// are dropped. This can be used for simple text transformations, instead of
+// spawning sed or awk. The files are processed line by line, so they are
+// never loaded into memory as a whole, but lines longer than MaxLineSize
+// bytes make the workflow fail.
+//
+// The output files are named as the input files, with Extension appended,
+// and are written to their temporary paths first, and atomized when done, as
+// usual. Output files that already exist are not re-created. The keys of the
+// input packets are added to the output packets.
+type LineMap struct {
+	scipipe.Process
+	name        string
+	In          *scipipe.FilePort
+	Out         *scipipe.FilePort
+	MapFunc     func(line string) string
+	Extension   string
+	MaxLineSize int
+}
+
+// NewLineMap returns a new LineMap, applying mapFunc to each line, and naming
+// the outputs by appending extension to the paths of the inputs, allowing
+// lines of up to 1 MiB
+func NewLineMap(wf *scipipe.Workflow, name string, extension string, mapFunc func(line string) string) *LineMap {
+	if extension == "" {
+		scipipe.Error.Fatalf("LineMap %s: Extension can not be empty, since outputs would overwrite inputs\n", name)
+	}
+	p := &LineMap{
+		name:        name,
+		In:          scipipe.NewFilePort(),
+		Out:         scipipe.NewFilePort(),
+		MapFunc:     mapFunc,
+		Extension:   extension,
+		MaxLineSize: 1024 * 1024,
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *LineMap) Name() string {
+	return p.name
+}
+
+func (p *LineMap) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// Run the LineMap
+func (p *LineMap) Run() {
+	defer p.Out.Close()
+
+	for inIP := p.In.Recv(); inIP != nil; inIP = p.In.Recv() {
+		outIP := scipipe.NewInformationPacket(inIP.GetPath() + p.Extension)
+		if outIP.Exists() {
+			scipipe.Info.Printf("LineMap %s: Output file already exists, so skipping: %s\n", p.name, outIP.GetPath())
+		} else {
+			p.mapLines(inIP, outIP)
+		}
+		outIP.AddKeys(inIP.GetKeys())
+		p.Out.Send(outIP)
+	}
+}
+
+// mapLines writes the mapped lines of the file of inIP to the file of outIP
+func (p *LineMap) mapLines(inIP *scipipe.InformationPacket, outIP *scipipe.InformationPacket) {
+	inFile := inIP.Open()
+	defer inFile.Close()
+	outFile := outIP.OpenWriteTemp()
+
+	scanner := bufio.NewScanner(inFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), p.MaxLineSize)
+	writer := bufio.NewWriter(outFile)
+	for scanner.Scan() {
+		line := p.MapFunc(scanner.Text())
+		if line == "" {
+			continue
+		}
+		_, err := writer.WriteString(line + "\n")
+		scipipe.Check(err, "LineMap "+p.name+": Could not write to file: "+outIP.GetTempPath())
+	}
+	scipipe.Check(scanner.Err(), "LineMap "+p.name+": Could not read lines of file: "+inIP.GetPath())
+	scipipe.Check(writer.Flush(), "LineMap "+p.name+": Could not write to file: "+outIP.GetTempPath())
+	scipipe.Check(outFile.Close(), "LineMap "+p.name+": Could not close file: "+outIP.GetTempPath())
+	outIP.Atomize()
+}
@@ -0,0 +1,38 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	str "strings"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineMap(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	inPath := "/tmp/linemap_in.txt"
+	outPath := inPath + ".upper"
+	err := ioutil.WriteFile(inPath, []byte("# comment\nfoo\nbar\n# another comment\nbaz\n"), 0644)
+	assert.Nil(t, err)
+	defer os.Remove(inPath)
+	defer os.Remove(outPath)
+
+	wf := scipipe.NewWorkflow("TestLineMapWf", 4)
+	gen := scipipe.NewIPGen(wf, "gen", inPath)
+	upper := NewLineMap(wf, "upper", ".upper", func(line string) string {
+		if str.HasPrefix(line, "#") {
+			return ""
+		}
+		return str.ToUpper(line)
+	})
+	upper.In.Connect(gen.Out)
+	wf.ConnectLast(upper.Out)
+	wf.Run()
+
+	out, err := ioutil.ReadFile(outPath)
+	assert.Nil(t, err, "Output file missing")
+	assert.Equal(t, "FOO\nBAR\nBAZ\n", string(out), "Wrong content of transformed file, or comment lines not dropped")
+}
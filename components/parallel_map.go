@@ -0,0 +1,106 @@
+package components
+
+import (
+	"sync"
+
+	"github.com/scipipe/scipipe"
+)
+
+// ParallelMap applies the function MapFunc to each information packet coming
+// in on its In in-port, using Workers worker go-routines in parallel, and sends
+// the resulting packets on its Out out-port. This can be used for CPU-bound
+// steps implemented in Go, rather than as shell commands. With Ordered set,
+// the outputs are sent in the same order as the inputs were received,
+// otherwise in the order they are finished.
+type ParallelMap struct {
+	scipipe.Process
+	name    string
+	In      *scipipe.FilePort
+	Out     *scipipe.FilePort
+	MapFunc func(ip *scipipe.InformationPacket) *scipipe.InformationPacket
+	Workers int
+	Ordered bool
+}
+
+// NewParallelMap returns a new ParallelMap, applying mapFunc using workers
+// worker go-routines, with ordered output
+func NewParallelMap(wf *scipipe.Workflow, name string, workers int, mapFunc func(ip *scipipe.InformationPacket) *scipipe.InformationPacket) *ParallelMap {
+	if workers < 1 {
+		scipipe.Error.Fatalf("ParallelMap %s: Number of workers has to be at least 1, was %d\n", name, workers)
+	}
+	p := &ParallelMap{
+		name:    name,
+		In:      scipipe.NewFilePort(),
+		Out:     scipipe.NewFilePort(),
+		MapFunc: mapFunc,
+		Workers: workers,
+		Ordered: true,
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *ParallelMap) Name() string {
+	return p.name
+}
+
+func (p *ParallelMap) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// indexedIP is an information packet together with its index in the input
+// stream
+type indexedIP struct {
+	idx int
+	ip  *scipipe.InformationPacket
+}
+
+// Run the ParallelMap
+func (p *ParallelMap) Run() {
+	defer p.Out.Close()
+	go p.In.RunMergeInputs()
+
+	inputs := make(chan indexedIP, scipipe.BUFSIZE)
+	results := make(chan indexedIP, scipipe.BUFSIZE)
+	go func() {
+		defer close(inputs)
+		idx := 0
+		for ip := range p.In.InChan {
+			inputs <- indexedIP{idx, ip}
+			idx++
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for in := range inputs {
+				results <- indexedIP{in.idx, p.MapFunc(in.ip)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	if !p.Ordered {
+		for res := range results {
+			p.Out.Send(res.ip)
+		}
+		return
+	}
+	// Buffer results finished out of order, until all preceding ones are sent
+	pending := map[int]*scipipe.InformationPacket{}
+	next := 0
+	for res := range results {
+		pending[res.idx] = res.ip
+		for ip, ok := pending[next]; ok; ip, ok = pending[next] {
+			p.Out.Send(ip)
+			delete(pending, next)
+			next++
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package components
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelMap(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	for _, ordered := range []bool{true, false} {
+		wf := scipipe.NewWorkflow("TestParallelMapWf", 4)
+		paths := []string{}
+		for i := 0; i < 20; i++ {
+			paths = append(paths, fmt.Sprintf("/tmp/parallelmap_%02d.txt", i))
+		}
+		ipGen := scipipe.NewIPGen(wf, "ipgen", paths...)
+		pmap := NewParallelMap(wf, "pmap", 4, func(ip *scipipe.InformationPacket) *scipipe.InformationPacket {
+			// Make packets with lower last digits finish later, to mix up
+			// the order
+			lastDigit := ip.GetPath()[len(ip.GetPath())-5] - '0'
+			time.Sleep(time.Duration(10-lastDigit) * time.Millisecond)
+			return scipipe.NewInformationPacket(ip.GetPath() + ".mapped")
+		})
+		pmap.Ordered = ordered
+		pmap.In.Connect(ipGen.Out)
+
+		inPort := scipipe.NewFilePort()
+		inPort.Connect(pmap.Out)
+		go ipGen.Run()
+		go pmap.Run()
+
+		outPaths := []string{}
+		for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+			outPaths = append(outPaths, ip.GetPath())
+		}
+
+		expected := []string{}
+		for _, path := range paths {
+			expected = append(expected, path+".mapped")
+		}
+		if !ordered {
+			sort.Strings(outPaths)
+		}
+		assert.Equal(t, expected, outPaths, "Wrong outputs from ParallelMap, with ordered = %v", ordered)
+	}
+}
@@ -0,0 +1,83 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/scipipe/scipipe"
+)
+
+// ParamToFile takes parameter values on its InParam parameter port, writes each
+// of them to a file of its own, in the directory dir, and sends the file on
+// its OutFile out-port. The files are named by the index of the value in the
+// stream (such as "dir/0.txt", "dir/1.txt" etc), or, with UseValueAsFileName
+// set, by the value itself (such as "dir/foo.txt"), with any slashes in it
+// replaced by underscores, so that files are never written outside of dir.
+// Each file is tagged with the value it contains, as the key "param". This is
+// the inverse of FileToParam.
+type ParamToFile struct {
+	scipipe.Process
+	name               string
+	workflow           *scipipe.Workflow
+	dir                string
+	InParam            *scipipe.ParamPort
+	OutFile            *scipipe.FilePort
+	UseValueAsFileName bool
+}
+
+// Instantiate a new ParamToFile, writing files to the directory dir
+func NewParamToFile(wf *scipipe.Workflow, name string, dir string) *ParamToFile {
+	p := &ParamToFile{
+		name:     name,
+		workflow: wf,
+		dir:      dir,
+		InParam:  scipipe.NewParamPort(),
+		OutFile:  scipipe.NewFilePort(),
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *ParamToFile) Name() string {
+	return p.name
+}
+
+func (p *ParamToFile) IsConnected() bool {
+	return p.InParam.IsConnected() && p.OutFile.IsConnected()
+}
+
+// Run the ParamToFile
+func (p *ParamToFile) Run() {
+	defer p.OutFile.Close()
+
+	err := os.MkdirAll(p.dir, 0777)
+	Check(err)
+
+	i := 0
+	for param := range p.InParam.Chan {
+		fileName := strconv.Itoa(i)
+		if p.UseValueAsFileName {
+			fileName = fileNameForValue(param)
+		}
+		ip := p.workflow.NewInformationPacket(filepath.Join(p.dir, fileName+".txt"))
+		ip.WriteTempFile([]byte(param))
+		ip.Atomize()
+		ip.AddKey("param", param)
+		ip.WriteAuditLogToFile()
+		p.OutFile.Send(ip)
+		i++
+	}
+}
+
+// fileNameForValue returns the file name (without extension) for the value,
+// with path separators replaced by underscores
+func fileNameForValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, value)
+}
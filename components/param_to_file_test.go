@@ -0,0 +1,59 @@
+package components
+
+import (
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamToFile(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestParamToFileWf", 4)
+	params := scipipe.NewParamPort()
+	params.ConnectStr("foo", "bar")
+	p2f := NewParamToFile(wf, "param_to_file", "/tmp/paramtofile")
+	p2f.InParam.Connect(params)
+
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(p2f.OutFile)
+
+	go p2f.Run()
+	expectedPaths := []string{"/tmp/paramtofile/0.txt", "/tmp/paramtofile/1.txt"}
+	for i, value := range []string{"foo", "bar"} {
+		ip := inPort.Recv()
+		assert.Equal(t, expectedPaths[i], ip.GetPath(), "Wrong path")
+		assert.Equal(t, value, string(ip.Read()), "Wrong content in file")
+		assert.Equal(t, value, ip.GetKey("param"), "Wrong param key")
+	}
+	assert.Nil(t, inPort.Recv(), "Out-port should be closed after all params")
+
+	os.RemoveAll("/tmp/paramtofile")
+}
+
+func TestParamToFile_UseValueAsFileName(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestParamToFileValueNameWf", 4)
+	params := scipipe.NewParamPort()
+	params.ConnectStr("foo", "../escaped", "a/b")
+	p2f := NewParamToFile(wf, "param_to_file", "/tmp/paramtofile_names")
+	p2f.UseValueAsFileName = true
+	p2f.InParam.Connect(params)
+
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(p2f.OutFile)
+
+	go p2f.Run()
+	expectedPaths := []string{"/tmp/paramtofile_names/foo.txt", "/tmp/paramtofile_names/.._escaped.txt", "/tmp/paramtofile_names/a_b.txt"}
+	for _, expectedPath := range expectedPaths {
+		ip := inPort.Recv()
+		assert.Equal(t, expectedPath, ip.GetPath(), "Files should be written directly in the directory")
+	}
+	_, err := os.Stat("/tmp/escaped.txt")
+	assert.True(t, os.IsNotExist(err), "File should not be written outside of the directory")
+
+	os.RemoveAll("/tmp/paramtofile_names")
+}
@@ -0,0 +1,68 @@
+package components
+
+import (
+	"github.com/scipipe/scipipe"
+)
+
+// RoundRobinMerge merges the streams coming in on its In in-ports, by sending
+// one packet from each of them in turn, in the order of the in-ports, on its
+// Out out-port. In-ports whose streams are exhausted are skipped, until all of
+// them are closed. Unlike the merging of multiple connections to a single
+// in-port, which happens in arbitrary order, this gives a deterministic, fair
+// interleaving, independent of the speed of the upstream processes. Note that
+// this means that it waits for the next packet on an in-port, even if packets
+// are available on other in-ports.
+type RoundRobinMerge struct {
+	scipipe.Process
+	name string
+	In   []*scipipe.FilePort
+	Out  *scipipe.FilePort
+}
+
+// NewRoundRobinMerge returns a new RoundRobinMerge, with numInPorts in-ports
+func NewRoundRobinMerge(wf *scipipe.Workflow, name string, numInPorts int) *RoundRobinMerge {
+	if numInPorts < 1 {
+		scipipe.Error.Fatalf("RoundRobinMerge %s: Number of in-ports has to be at least 1, was %d\n", name, numInPorts)
+	}
+	p := &RoundRobinMerge{
+		name: name,
+		Out:  scipipe.NewFilePort(),
+	}
+	for i := 0; i < numInPorts; i++ {
+		p.In = append(p.In, scipipe.NewFilePort())
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *RoundRobinMerge) Name() string {
+	return p.name
+}
+
+func (p *RoundRobinMerge) IsConnected() bool {
+	for _, inPort := range p.In {
+		if !inPort.IsConnected() {
+			return false
+		}
+	}
+	return p.Out.IsConnected()
+}
+
+// Run the RoundRobinMerge
+func (p *RoundRobinMerge) Run() {
+	defer p.Out.Close()
+
+	active := append([]*scipipe.FilePort{}, p.In...)
+	for len(active) > 0 {
+		stillActive := []*scipipe.FilePort{}
+		for _, inPort := range active {
+			ip := inPort.Recv()
+			if ip == nil {
+				continue
+			}
+			p.Out.Send(ip)
+			stillActive = append(stillActive, inPort)
+		}
+		active = stillActive
+	}
+}
@@ -0,0 +1,34 @@
+package components
+
+import (
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobinMerge(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestRoundRobinMergeWf", 4)
+	genA := scipipe.NewIPGen(wf, "gen_a", "a1", "a2", "a3", "a4")
+	genB := scipipe.NewIPGen(wf, "gen_b", "b1")
+	genC := scipipe.NewIPGen(wf, "gen_c", "c1", "c2")
+	rrMerge := NewRoundRobinMerge(wf, "rrmerge", 3)
+	rrMerge.In[0].Connect(genA.Out)
+	rrMerge.In[1].Connect(genB.Out)
+	rrMerge.In[2].Connect(genC.Out)
+
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(rrMerge.Out)
+	go genA.Run()
+	go genB.Run()
+	go genC.Run()
+	go rrMerge.Run()
+
+	paths := []string{}
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		paths = append(paths, ip.GetPath())
+	}
+	assert.Equal(t, []string{"a1", "b1", "c1", "a2", "c2", "a3", "a4"}, paths, "Wrong order of round-robin merged packets")
+}
@@ -0,0 +1,200 @@
+package components
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	str "strings"
+
+	"github.com/scipipe/scipipe"
+)
+
+// SeqFormat is a format of biological sequence files, for SeqSplitter
+type SeqFormat int
+
+const (
+	// SeqFormatFASTA is the FASTA format, where each record starts with a
+	// header line starting with ">", followed by any number of sequence lines
+	SeqFormatFASTA SeqFormat = iota
+	// SeqFormatFASTQ is the FASTQ format, where each record consists of four
+	// lines: a header line starting with "@", a sequence line, a separator
+	// line starting with "+", and a quality line
+	SeqFormatFASTQ
+)
+
+// SeqSplitter splits the sequence files (in FASTA or FASTQ format) coming in
+// on its In in-port into shards of RecordsPerShard records each (the last one
+// possibly with fewer), which are sent on its Out out-port. Unlike splitting
+// by a number of lines, records are never split across shards, even for
+// FASTA records with multi-line sequences.
+//
+// The shards are named as the input file, with ".shard_<index>" inserted
+// before the extension, such as "reads.shard_1.fq", and are tagged with the
+// key "shard", containing the index, starting at 1. The keys of the input
+// packets are added to the shards as well. Shards that already exist are not
+// re-created. Files not conforming to the format make the workflow fail.
+type SeqSplitter struct {
+	scipipe.Process
+	name            string
+	In              *scipipe.FilePort
+	Out             *scipipe.FilePort
+	Format          SeqFormat
+	RecordsPerShard int
+}
+
+// NewSeqSplitter returns a new SeqSplitter, splitting files in the format
+// format into shards of recordsPerShard records
+func NewSeqSplitter(wf *scipipe.Workflow, name string, format SeqFormat, recordsPerShard int) *SeqSplitter {
+	if recordsPerShard < 1 {
+		scipipe.Error.Fatalf("SeqSplitter %s: Records per shard has to be at least 1, was %d\n", name, recordsPerShard)
+	}
+	p := &SeqSplitter{
+		name:            name,
+		In:              scipipe.NewFilePort(),
+		Out:             scipipe.NewFilePort(),
+		Format:          format,
+		RecordsPerShard: recordsPerShard,
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *SeqSplitter) Name() string {
+	return p.name
+}
+
+func (p *SeqSplitter) IsConnected() bool {
+	return p.In.IsConnected() && p.Out.IsConnected()
+}
+
+// Run the SeqSplitter
+func (p *SeqSplitter) Run() {
+	defer p.Out.Close()
+
+	for ip := p.In.Recv(); ip != nil; ip = p.In.Recv() {
+		p.split(ip)
+	}
+}
+
+// split splits the file of inIP into shards, which are sent as they are
+// completed
+func (p *SeqSplitter) split(inIP *scipipe.InformationPacket) {
+	inFile := inIP.Open()
+	defer inFile.Close()
+	reader := bufio.NewReader(inFile)
+
+	var shard *seqShard
+	shardIdx := 0
+	recordsInShard := 0
+	// startRecord starts a new record, in a new shard if the current one is
+	// full
+	startRecord := func() {
+		if shard == nil || recordsInShard == p.RecordsPerShard {
+			if shard != nil {
+				p.sendShard(shard, inIP)
+			}
+			shardIdx++
+			shard = newSeqShard(inIP.GetPath(), shardIdx)
+			recordsInShard = 0
+		}
+		recordsInShard++
+	}
+
+	lineNo := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if !str.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			switch p.Format {
+			case SeqFormatFASTA:
+				if str.HasPrefix(line, ">") {
+					startRecord()
+				} else if shard == nil {
+					if str.TrimSpace(line) == "" {
+						continue
+					}
+					scipipe.Error.Fatalf("SeqSplitter %s: File is not in FASTA format, as it does not start with a '>' header line: %s\n", p.name, inIP.GetPath())
+				}
+			case SeqFormatFASTQ:
+				if lineNo%4 == 0 {
+					if str.TrimSpace(line) == "" {
+						continue
+					}
+					if !str.HasPrefix(line, "@") {
+						scipipe.Error.Fatalf("SeqSplitter %s: Invalid FASTQ record header, not starting with '@', on line %d of: %s\n", p.name, lineNo+1, inIP.GetPath())
+					}
+					startRecord()
+				} else if lineNo%4 == 2 && !str.HasPrefix(line, "+") {
+					scipipe.Error.Fatalf("SeqSplitter %s: Invalid FASTQ record separator, not starting with '+', on line %d of: %s\n", p.name, lineNo+1, inIP.GetPath())
+				}
+				lineNo++
+			}
+			shard.write(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		scipipe.Check(err, "SeqSplitter "+p.name+": Could not read file: "+inIP.GetPath())
+	}
+	if p.Format == SeqFormatFASTQ && lineNo%4 != 0 {
+		scipipe.Error.Fatalf("SeqSplitter %s: Incomplete FASTQ record at the end of: %s\n", p.name, inIP.GetPath())
+	}
+	if shard != nil {
+		p.sendShard(shard, inIP)
+	}
+}
+
+// sendShard finishes writing shard, and sends it, tagged with its index and
+// the keys of inIP
+func (p *SeqSplitter) sendShard(shard *seqShard, inIP *scipipe.InformationPacket) {
+	shard.finish()
+	shard.ip.AddKeys(inIP.GetKeys())
+	shard.ip.AddKey("shard", strconv.Itoa(shard.idx))
+	p.Out.Send(shard.ip)
+}
+
+// seqShard is a shard file being written by a SeqSplitter. If the shard
+// already exists, the writes are discarded.
+type seqShard struct {
+	ip     *scipipe.InformationPacket
+	idx    int
+	writer *bufio.Writer
+	closer io.Closer
+}
+
+func newSeqShard(inPath string, idx int) *seqShard {
+	ext := filepath.Ext(inPath)
+	shard := &seqShard{
+		ip:  scipipe.NewInformationPacket(str.TrimSuffix(inPath, ext) + ".shard_" + strconv.Itoa(idx) + ext),
+		idx: idx,
+	}
+	if shard.ip.Exists() {
+		scipipe.Info.Printf("Shard already exists, so not re-creating it: %s\n", shard.ip.GetPath())
+		shard.writer = bufio.NewWriter(ioutil.Discard)
+	} else {
+		f := shard.ip.OpenWriteTemp()
+		shard.writer = bufio.NewWriter(f)
+		shard.closer = f
+	}
+	return shard
+}
+
+func (s *seqShard) write(line string) {
+	_, err := s.writer.WriteString(line)
+	scipipe.Check(err, "Could not write to shard: "+s.ip.GetTempPath())
+}
+
+// finish flushes and closes the shard file, and atomizes it, unless it
+// already existed
+func (s *seqShard) finish() {
+	if s.closer == nil {
+		return
+	}
+	scipipe.Check(s.writer.Flush(), "Could not write to shard: "+s.ip.GetTempPath())
+	scipipe.Check(s.closer.Close(), "Could not close shard: "+s.ip.GetTempPath())
+	s.ip.Atomize()
+}
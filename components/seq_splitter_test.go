@@ -0,0 +1,61 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+// runSeqSplitter splits the file at inPath with a SeqSplitter, and returns
+// the contents of the shards, by the value of their "shard" key
+func runSeqSplitter(wfName string, inPath string, format SeqFormat, recordsPerShard int) map[string]string {
+	wf := scipipe.NewWorkflow(wfName, 4)
+	gen := scipipe.NewIPGen(wf, "gen", inPath)
+	splitter := NewSeqSplitter(wf, "splitter", format, recordsPerShard)
+	splitter.In.Connect(gen.Out)
+	inPort := scipipe.NewFilePort()
+	inPort.Connect(splitter.Out)
+	go gen.Run()
+	go splitter.Run()
+
+	shards := map[string]string{}
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		shards[ip.GetKey("shard")] = string(ip.Read())
+		os.Remove(ip.GetPath())
+	}
+	return shards
+}
+
+func TestSeqSplitter_FASTA(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	inPath := "/tmp/seqsplitter_test.fa"
+	fasta := ">seq1\nACGT\nACGT\n>seq2\nGGGG\n>seq3 with description\nTTTT\nTTTT\nTT\n"
+	assert.Nil(t, ioutil.WriteFile(inPath, []byte(fasta), 0644))
+	defer os.Remove(inPath)
+
+	shards := runSeqSplitter("TestSeqSplitterFASTAWf", inPath, SeqFormatFASTA, 2)
+	assert.Equal(t, map[string]string{
+		"1": ">seq1\nACGT\nACGT\n>seq2\nGGGG\n",
+		"2": ">seq3 with description\nTTTT\nTTTT\nTT\n",
+	}, shards, "Multi-line FASTA records should not be split across shards")
+}
+
+func TestSeqSplitter_FASTQ(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	inPath := "/tmp/seqsplitter_test.fq"
+	fastq := "@r1\nACGT\n+\nIIII\n@r2\nGGGG\n+\n@@@@\n@r3\nTTTT\n+r3\nIIII\n"
+	assert.Nil(t, ioutil.WriteFile(inPath, []byte(fastq), 0644))
+	defer os.Remove(inPath)
+
+	shards := runSeqSplitter("TestSeqSplitterFASTQWf", inPath, SeqFormatFASTQ, 1)
+	assert.Equal(t, map[string]string{
+		"1": "@r1\nACGT\n+\nIIII\n",
+		"2": "@r2\nGGGG\n+\n@@@@\n",
+		"3": "@r3\nTTTT\n+r3\nIIII\n",
+	}, shards, "FASTQ should be split on four-line records, even with qualities starting with '@'")
+}
@@ -0,0 +1,339 @@
+package components
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	str "strings"
+	"time"
+
+	"github.com/scipipe/scipipe"
+)
+
+// URLSource downloads the file at URL to Path, and sends it on its Out
+// out-port, for use as input to the rest of the workflow.
+//
+// Downloads are written to Path + ".partial", and moved in place when
+// complete. If the connection is broken, or the previous run of the workflow
+// was interrupted, the download is resumed from where it stopped, with an HTTP
+// range request, provided that the server supports it. The ETag (or else the
+// Last-Modified date) of the remote file is kept next to the partial file, in
+// Path + ".partial.validator", and sent with the range request (as If-Range),
+// so that the download starts over if the remote file has changed. Without
+// such a validator, the download is only resumed if it is verified with a
+// checksum afterwards. Failed requests are retried up to Retries times,
+// waiting RetryWait between them.
+//
+// If a checksum is known, either given explicitly with Checksum, or fetched
+// from a companion file next to the URL (URL + ".sha256" or URL + ".md5") with
+// FetchChecksum, the file is verified before it is sent. An existing file at
+// Path is only treated as a valid cached file if its checksum matches, and is
+// otherwise downloaded again. Without a checksum, an existing file is used as
+// is, like other existing outputs in scipipe.
+//
+// The sent file is tagged with the URL as the key "url", and, if verified,
+// the checksum as the key "checksum" (such as "sha256:<hex digest>").
+type URLSource struct {
+	scipipe.Process
+	name     string
+	workflow *scipipe.Workflow
+	Out      *scipipe.FilePort
+	URL      string
+	Path     string
+	// Checksum is the expected checksum of the file, in the form
+	// "<algorithm>:<hex digest>", where the algorithm is sha256 or md5
+	Checksum string
+	// FetchChecksum makes the checksum be fetched from URL + ".sha256", or
+	// else URL + ".md5", when Checksum is not set. The first
+	// whitespace-separated field of the file is used, as in the output of
+	// sha256sum and md5sum.
+	FetchChecksum bool
+	Retries       int
+	RetryWait     time.Duration
+	Client        *http.Client
+}
+
+// NewURLSource returns a new URLSource, downloading url to path
+func NewURLSource(wf *scipipe.Workflow, name string, url string, path string) *URLSource {
+	p := &URLSource{
+		name:      name,
+		workflow:  wf,
+		Out:       scipipe.NewFilePort(),
+		URL:       url,
+		Path:      path,
+		Retries:   3,
+		RetryWait: time.Second,
+		Client:    http.DefaultClient,
+	}
+	wf.AddProc(p)
+	return p
+}
+
+func (p *URLSource) Name() string {
+	return p.name
+}
+
+func (p *URLSource) IsConnected() bool {
+	return p.Out.IsConnected()
+}
+
+// Run the URLSource
+func (p *URLSource) Run() {
+	defer p.Out.Close()
+
+	checksum := p.Checksum
+	if checksum == "" && p.FetchChecksum {
+		var err error
+		checksum, err = p.fetchChecksum()
+		scipipe.Check(err, "URLSource "+p.name+": Could not fetch checksum for: "+p.URL)
+	}
+	algo, digest, err := parseChecksum(checksum)
+	scipipe.Check(err, "URLSource "+p.name+": Invalid checksum")
+
+	ip := p.workflow.NewInformationPacket(p.Path)
+	if ip.Exists() {
+		if digest == "" {
+			scipipe.Info.Printf("URLSource %s: File already exists, so not downloading: %s\n", p.name, p.Path)
+			p.send(ip, "")
+			return
+		}
+		actual, err := fileChecksum(p.Path, algo)
+		scipipe.Check(err, "URLSource "+p.name+": Could not compute checksum of: "+p.Path)
+		if actual == digest {
+			scipipe.Info.Printf("URLSource %s: File already exists, with a matching checksum, so not downloading: %s\n", p.name, p.Path)
+			p.send(ip, checksum)
+			return
+		}
+		scipipe.Warning.Printf("URLSource %s: Checksum of existing file does not match (%s:%s, expected %s), so downloading again: %s\n", p.name, algo, actual, checksum, p.Path)
+		scipipe.Check(os.Remove(p.Path), "URLSource "+p.name+": Could not remove file with mismatching checksum: "+p.Path)
+	}
+
+	partialPath := p.Path + ".partial"
+	if dir := filepath.Dir(p.Path); dir != "" {
+		scipipe.Check(os.MkdirAll(dir, 0777), "URLSource "+p.name+": Could not create directory: "+dir)
+	}
+	// A corrupt download might be due to a partial file that did not match the
+	// remote file, so it is downloaded once more from scratch, before giving up
+	for attempt := 1; ; attempt++ {
+		scipipe.Check(p.download(partialPath, digest != ""), "URLSource "+p.name+": Could not download: "+p.URL)
+		if digest == "" {
+			break
+		}
+		actual, err := fileChecksum(partialPath, algo)
+		scipipe.Check(err, "URLSource "+p.name+": Could not compute checksum of: "+partialPath)
+		if actual == digest {
+			break
+		}
+		scipipe.Check(os.Remove(partialPath), "URLSource "+p.name+": Could not remove download with mismatching checksum: "+partialPath)
+		removeValidator(partialPath)
+		if attempt == 2 {
+			scipipe.Error.Fatalf("URLSource %s: Checksum of download does not match (%s:%s, expected %s): %s\n", p.name, algo, actual, checksum, p.URL)
+		}
+		scipipe.Warning.Printf("URLSource %s: Checksum of download does not match (%s:%s, expected %s), so downloading again: %s\n", p.name, algo, actual, checksum, p.URL)
+	}
+	scipipe.Check(os.Rename(partialPath, p.Path), "URLSource "+p.name+": Could not move download in place: "+p.Path)
+	removeValidator(partialPath)
+	p.send(ip, checksum)
+}
+
+// send tags ip with the URL and checksum (if any), and sends it
+func (p *URLSource) send(ip *scipipe.InformationPacket, checksum string) {
+	ai := ip.GetAuditInfo()
+	ai.Keys["url"] = p.URL
+	// A checksum left in the audit file from an earlier run is removed, if the
+	// file was not verified this time
+	delete(ai.Keys, "checksum")
+	if checksum != "" {
+		ai.Keys["checksum"] = checksum
+	}
+	ip.WriteAuditLogToFile()
+	p.Out.Send(ip)
+}
+
+// download downloads the URL to path, resuming from the end of any existing
+// file at path, and retrying up to p.Retries times on errors. If verified is
+// set, the download is verified with a checksum afterwards, so that it can be
+// resumed even if the remote file can not be validated to be unchanged.
+func (p *URLSource) download(path string, verified bool) error {
+	var err error
+	for try := 0; try <= p.Retries; try++ {
+		if try > 0 {
+			scipipe.Warning.Printf("URLSource %s: Download failed (%s), so retrying (%d of %d) in %s: %s\n", p.name, err, try, p.Retries, p.RetryWait, p.URL)
+			time.Sleep(p.RetryWait)
+		}
+		if err = p.downloadOnce(path, verified); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// downloadOnce makes one request for the URL, for the remainder of the file at
+// path if it exists and can be resumed, and appends the response to it. The
+// download is only resumed if the remote file is unchanged since the file at
+// path was started, according to the validator stored next to it, or if
+// verified is set.
+func (p *URLSource) downloadOnce(path string, verified bool) error {
+	var offset int64
+	if fi, err := os.Stat(path); err == nil {
+		offset = fi.Size()
+	}
+	validator := readValidator(path)
+	if offset > 0 && validator == "" && !verified {
+		scipipe.Warning.Printf("URLSource %s: Can not tell if the remote file has changed since the partial download, so downloading it from scratch: %s\n", p.name, p.URL)
+		offset = 0
+	}
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		scipipe.Info.Printf("URLSource %s: Resuming download from byte %d: %s\n", p.name, offset, p.URL)
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete, if it has the full size of
+		// the (unchanged) remote file. Otherwise it is downloaded again.
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		os.Remove(path)
+		removeValidator(path)
+		return fmt.Errorf("Partial download does not match the size of the remote file (%s), so starting over", resp.Header.Get("Content-Range"))
+	case resp.StatusCode == http.StatusOK:
+		// The server does not support ranges, the remote file has changed,
+		// or nothing was downloaded before
+		flags |= os.O_TRUNC
+		if err := writeValidator(path, resp.Header); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Got unexpected HTTP status: %s", resp.Status)
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// validatorPath returns the path of the file keeping the validator of the
+// remote file of the partial download at path
+func validatorPath(path string) string {
+	return path + ".validator"
+}
+
+// readValidator returns the validator of the remote file of the partial
+// download at path, or an empty string if there is none
+func readValidator(path string) string {
+	validator, err := ioutil.ReadFile(validatorPath(path))
+	if err != nil {
+		return ""
+	}
+	return str.TrimSpace(string(validator))
+}
+
+// writeValidator stores the validator of the remote file, from the headers of
+// the response starting the partial download at path: the ETag, if it is a
+// strong one (which is required for If-Range), or else the Last-Modified
+// date. Any old validator is removed if there is neither.
+func writeValidator(path string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || str.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		removeValidator(path)
+		return nil
+	}
+	return ioutil.WriteFile(validatorPath(path), []byte(validator+"\n"), 0644)
+}
+
+// removeValidator removes the validator of the partial download at path, if
+// any
+func removeValidator(path string) {
+	os.Remove(validatorPath(path))
+}
+
+// fetchChecksum fetches the checksum from a companion file of the URL
+func (p *URLSource) fetchChecksum() (string, error) {
+	for _, algo := range []string{"sha256", "md5"} {
+		resp, err := p.Client.Get(p.URL + "." + algo)
+		if err != nil {
+			return "", err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Got unexpected HTTP status for %s: %s", p.URL+"."+algo, resp.Status)
+		}
+		fields := str.Fields(string(body))
+		if len(fields) == 0 {
+			return "", errors.New("Empty checksum file: " + p.URL + "." + algo)
+		}
+		return algo + ":" + fields[0], nil
+	}
+	return "", errors.New("No companion .sha256 or .md5 file found")
+}
+
+// parseChecksum splits a checksum of the form "<algorithm>:<hex digest>". An
+// empty checksum gives an empty algorithm and digest.
+func parseChecksum(checksum string) (algo string, digest string, err error) {
+	if checksum == "" {
+		return "", "", nil
+	}
+	parts := str.SplitN(checksum, ":", 2)
+	if len(parts) != 2 || (parts[0] != "sha256" && parts[0] != "md5") || parts[1] == "" {
+		return "", "", fmt.Errorf("Checksum should be on the form sha256:<hex digest> or md5:<hex digest>, was: %s", checksum)
+	}
+	return parts[0], str.ToLower(parts[1]), nil
+}
+
+// fileChecksum returns the hex digest of the file at path, with the algorithm
+// algo (sha256 or md5)
+func fileChecksum(path string, algo string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var h hash.Hash = sha256.New()
+	if algo == "md5" {
+		h = md5.New()
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,133 @@
+package components
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLSource(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	content := bytes.Repeat([]byte("ACGTACGTAC\n"), 1000)
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	var mx sync.Mutex
+	ranges := []string{}
+	etag := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ref.fa":
+			mx.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			mx.Unlock()
+			http.ServeContent(w, r, "ref.fa", time.Now(), bytes.NewReader(content))
+		case "/ref.fa.sha256":
+			w.Write([]byte(digest + "  ref.fa\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := "/tmp/url_source_test/ref.fa"
+	run := func(fetchChecksum bool) *scipipe.InformationPacket {
+		wf := scipipe.NewWorkflow("TestURLSourceWf", 4)
+		src := NewURLSource(wf, "url_source", server.URL+"/ref.fa", path)
+		src.FetchChecksum = fetchChecksum
+		src.RetryWait = 0
+		inPort := scipipe.NewFilePort()
+		inPort.Connect(src.Out)
+		go src.Run()
+		ip := inPort.Recv()
+		assert.Nil(t, inPort.Recv(), "Out-port should be closed after the file")
+		return ip
+	}
+	resetRanges := func() []string {
+		mx.Lock()
+		defer mx.Unlock()
+		rs := ranges
+		ranges = []string{}
+		return rs
+	}
+
+	// Resume an interrupted download
+	os.MkdirAll("/tmp/url_source_test", 0777)
+	err := ioutil.WriteFile(path+".partial", content[:4000], 0644)
+	assert.Nil(t, err)
+	ip := run(true)
+	assert.Equal(t, []string{"bytes=4000-"}, resetRanges(), "Download should be resumed with a range request")
+	assert.Equal(t, content, ip.Read(), "Wrong content of resumed download")
+	assert.Equal(t, server.URL+"/ref.fa", ip.GetKey("url"))
+	assert.Equal(t, "sha256:"+digest, ip.GetKey("checksum"))
+	_, err = os.Stat(path + ".partial")
+	assert.True(t, os.IsNotExist(err), "Partial file should be moved in place")
+
+	// Use a cached file with a matching checksum
+	ip = run(true)
+	assert.Equal(t, 0, len(resetRanges()), "Cached file with matching checksum should not be downloaded")
+	assert.Equal(t, content, ip.Read())
+
+	// Re-download a cached file with a mismatching checksum
+	err = ioutil.WriteFile(path, []byte("corrupt"), 0644)
+	assert.Nil(t, err)
+	ip = run(true)
+	assert.Equal(t, []string{""}, resetRanges(), "Cached file with mismatching checksum should be downloaded again")
+	assert.Equal(t, content, ip.Read(), "Wrong content of re-downloaded file")
+
+	// Without checksum, an existing file is used as is
+	err = ioutil.WriteFile(path, []byte("unverified"), 0644)
+	assert.Nil(t, err)
+	ip = run(false)
+	assert.Equal(t, 0, len(resetRanges()), "Existing file should not be downloaded without a checksum")
+	assert.Equal(t, "unverified", string(ip.Read()))
+	assert.NotContains(t, ip.GetKeys(), "checksum", "Unverified file should not get a checksum key")
+
+	// Without checksum or validator, a partial download is not resumed
+	os.Remove(path)
+	err = ioutil.WriteFile(path+".partial", []byte("stale"), 0644)
+	assert.Nil(t, err)
+	ip = run(false)
+	assert.Equal(t, []string{""}, resetRanges(), "Unvalidated partial download should be started over")
+	assert.Equal(t, content, ip.Read(), "Wrong content of restarted download")
+
+	// With a validator, the download is resumed only if the remote file is
+	// unchanged
+	mx.Lock()
+	etag = `"v1"`
+	mx.Unlock()
+	os.Remove(path)
+	err = ioutil.WriteFile(path+".partial", content[:4000], 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+".partial.validator", []byte(`"v1"`+"\n"), 0644)
+	assert.Nil(t, err)
+	ip = run(false)
+	assert.Equal(t, []string{"bytes=4000-"}, resetRanges(), "Validated download should be resumed")
+	assert.Equal(t, content, ip.Read(), "Wrong content of resumed download")
+	_, err = os.Stat(path + ".partial.validator")
+	assert.True(t, os.IsNotExist(err), "Validator should be removed when the download is complete")
+
+	os.Remove(path)
+	err = ioutil.WriteFile(path+".partial", []byte("old version"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+".partial.validator", []byte(`"v0"`+"\n"), 0644)
+	assert.Nil(t, err)
+	ip = run(false)
+	assert.Equal(t, content, ip.Read(), "Partial download of a changed remote file should be started over")
+
+	os.RemoveAll("/tmp/url_source_test")
+}
@@ -4,3 +4,53 @@ const (
 	// Standard buffer size used for channels connecting processes
 	BUFSIZE = 16
 )
+
+// FilePortBufferSize is the buffer size of the channels of file ports, that
+// is, of the data path between processes (parameter ports always use
+// BUFSIZE). Each connection, as well as the merged input channel of each
+// in-port, can hold this many packets, which lets producers run ahead of
+// consumers, at the cost of memory for the packets in flight. Setting it to
+// zero makes the data path unbuffered, and so strictly demand-driven: a
+// packet is only handed over when the consumer is ready to receive it,
+// bounding the memory used for huge streams, at the cost of less overlap
+// between processes (higher latency). It has to be set before any ports are
+// created.
+var FilePortBufferSize = BUFSIZE
+
+// DurableWrites makes scipipe fsync temporary files before they are renamed to
+// their final names when atomized, and fsync the directory containing them
+// after the rename. This protects against zero-length or partially written
+// output files after a crash, on networked file systems (such as NFS or
+// Lustre), where the rename might otherwise be persisted before the data. It
+// is off by default, because of the performance cost on local disks.
+var DurableWrites = false
+
+// TempPathScheme specifies how the paths of temporary output files, used before
+// outputs are atomized to their final paths, are formed
+type TempPathScheme int
+
+const (
+	// TempPathSuffix forms temporary paths by adding ".tmp" to the final path
+	TempPathSuffix TempPathScheme = iota
+	// TempPathUnique forms temporary paths by adding a token unique to each
+	// output (consisting of the process ID and a random string) plus ".tmp"
+	// to the final path, such as "out.txt.1234-abcdefgh.tmp", so that tasks in
+	// different runs, or retries, targeting the same output never write to the
+	// same temporary file
+	TempPathUnique TempPathScheme = iota
+)
+
+// TempPaths is the scheme used for temporary paths. It defaults to
+// TempPathSuffix, for backwards compatibility, and should only be changed
+// before any workflow is created.
+var TempPaths = TempPathSuffix
+
+// ShellCommand is the shell (the program and its arguments) that commands are
+// executed with, with the command appended as the last argument. It can be
+// overridden per process, with SciProcess.Shell.
+var ShellCommand = []string{"bash", "-c"}
+
+// FailOnUnconnectedSend makes sending on a file port without any connections
+// (such as an out-port that was never connected) an error, exiting the
+// program, instead of logging a warning, since the packets sent are lost.
+var FailOnUnconnectedSend = false
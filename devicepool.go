@@ -0,0 +1,102 @@
+package scipipe
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ----------------------------------------------------------------------------
+// Device pools
+// ----------------------------------------------------------------------------
+
+// DevicePool is a pool of devices, such as GPUs, or other named resources,
+// such as CPU sets or licenses, that tasks are given exclusive access to, one
+// device per task, while they execute. Processes use a pool with
+// UseDevicePool, upon which each of their tasks acquires a device from the
+// pool before it is executed, and releases it when done. The device is given
+// to the command in the environment variable EnvVar, and can also be found in
+// the Devices field of the task (such as for custom execution functions).
+//
+// Tasks block until a device is free, before they take up a slot among the
+// concurrent tasks of the workflow, so that tasks waiting for devices do not
+// hold back other tasks. A pool can be shared by several processes, in the
+// same or different workflows.
+type DevicePool struct {
+	EnvVar  string
+	devices chan string
+}
+
+// NewDevicePool returns a new pool of the devices devices, given to commands
+// in the environment variable envVar
+func NewDevicePool(envVar string, devices ...string) *DevicePool {
+	if len(devices) == 0 {
+		Error.Fatalf("Device pool for %s: No devices given\n", envVar)
+	}
+	pool := &DevicePool{
+		EnvVar:  envVar,
+		devices: make(chan string, len(devices)),
+	}
+	for _, device := range devices {
+		pool.devices <- device
+	}
+	return pool
+}
+
+// NewGPUPool returns a new pool of numGPUs GPUs, with the indices 0 to
+// numGPUs - 1, given to commands in CUDA_VISIBLE_DEVICES
+func NewGPUPool(numGPUs int) *DevicePool {
+	devices := []string{}
+	for i := 0; i < numGPUs; i++ {
+		devices = append(devices, strconv.Itoa(i))
+	}
+	return NewDevicePool("CUDA_VISIBLE_DEVICES", devices...)
+}
+
+// acquire blocks until a device is free, and returns it
+func (pool *DevicePool) acquire() string {
+	return <-pool.devices
+}
+
+// release returns the device to the pool
+func (pool *DevicePool) release(device string) {
+	pool.devices <- device
+}
+
+// acquireDevices acquires a device from each of the device pools of the task.
+// The pools are always acquired in the order of their environment variables,
+// so that tasks using the same pools can not deadlock.
+func (t *SciTask) acquireDevices() {
+	if len(t.devicePools) == 0 {
+		return
+	}
+	pools := append([]*DevicePool{}, t.devicePools...)
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].EnvVar < pools[j].EnvVar
+	})
+	t.Devices = map[string]string{}
+	for _, pool := range pools {
+		taskLogf(Debug, "Task:%-12s Waiting for a free device for %s [%s]\n", t.Name, pool.EnvVar, t.Command)
+		t.Devices[pool.EnvVar] = pool.acquire()
+		taskLogf(Debug, "Task:%-12s Acquired device %s=%s [%s]\n", t.Name, pool.EnvVar, t.Devices[pool.EnvVar], t.Command)
+	}
+}
+
+// releaseDevices releases the devices acquired by the task
+func (t *SciTask) releaseDevices() {
+	for _, pool := range t.devicePools {
+		if device, ok := t.Devices[pool.EnvVar]; ok {
+			pool.release(device)
+		}
+	}
+}
+
+// deviceEnv returns the environment variables for the devices acquired by the
+// task, on the form "NAME=value", sorted
+func (t *SciTask) deviceEnv() []string {
+	env := []string{}
+	for envVar, device := range t.Devices {
+		env = append(env, envVar+"="+device)
+	}
+	sort.Strings(env)
+	return env
+}
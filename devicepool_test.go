@@ -0,0 +1,68 @@
+package scipipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevicePool(t *testing.T) {
+	initTestLogs()
+	os.RemoveAll("/tmp/devicepool_test")
+	err := os.MkdirAll("/tmp/devicepool_test/locks", 0777)
+	assert.Nil(t, err)
+
+	wf := NewWorkflow("TestDevicePoolWf", 4)
+	wf.KeepGoing = true
+	// Creating the lock directory fails if another task is using the same
+	// device at the same time
+	gpu := wf.NewProc("gpu", "mkdir /tmp/devicepool_test/locks/gpu$CUDA_VISIBLE_DEVICES && sleep 0.3 && rmdir /tmp/devicepool_test/locks/gpu$CUDA_VISIBLE_DEVICES && echo {p:i} $CUDA_VISIBLE_DEVICES > {o:out}")
+	gpu.SetPathPattern("out", "/tmp/devicepool_test/{p:i}.txt")
+	gpu.ParamPort("i").ConnectStr("a", "b", "c", "d")
+	gpu.UseDevicePool(NewGPUPool(2))
+	wf.ConnectLast(gpu.Out("out"))
+
+	startTime := time.Now()
+	err = wf.Run()
+	assert.Nil(t, err, "No two tasks should use the same device at the same time")
+	assert.True(t, time.Since(startTime) >= 600*time.Millisecond, "Tasks should wait for free devices, with 4 tasks on 2 devices")
+
+	outputs, _ := filepath.Glob("/tmp/devicepool_test/*.txt")
+	assert.Equal(t, 4, len(outputs), "All tasks should have run")
+	for _, path := range outputs {
+		fields := strings.Fields(string(NewInformationPacket(path).Read()))
+		assert.Equal(t, 2, len(fields), "Device missing in output: %s", path)
+		if len(fields) == 2 {
+			assert.Contains(t, []string{"0", "1"}, fields[1], "Task got invalid device")
+		}
+	}
+
+	os.RemoveAll("/tmp/devicepool_test")
+}
+
+func TestDevicePool_BatchExecutor(t *testing.T) {
+	initTestLogs()
+	os.RemoveAll("/tmp/devicepool_batch_test")
+
+	wf := NewWorkflow("TestDevicePoolBatchWf", 4)
+	gpu := wf.NewProc("gpu", "echo $CUDA_VISIBLE_DEVICES > {o:out} # {p:i}")
+	gpu.SetPathPattern("out", "/tmp/devicepool_batch_test/{p:i}.txt")
+	gpu.ParamPort("i").ConnectStr("a", "b")
+	gpu.UseDevicePool(NewGPUPool(2))
+	gpu.BatchExecutor = NewBatchExecutor(2, 100*time.Millisecond)
+	wf.ConnectLast(gpu.Out("out"))
+	err := wf.Run()
+	assert.Nil(t, err)
+
+	devices := []string{}
+	for _, i := range []string{"a", "b"} {
+		devices = append(devices, strings.TrimSpace(string(NewInformationPacket("/tmp/devicepool_batch_test/"+i+".txt").Read())))
+	}
+	assert.ElementsMatch(t, []string{"0", "1"}, devices, "Batched commands should get the devices of their tasks")
+
+	os.RemoveAll("/tmp/devicepool_batch_test")
+}
@@ -0,0 +1,77 @@
+package scipipe
+
+import (
+	"syscall"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// Disk watch
+// ----------------------------------------------------------------------------
+
+type diskWatch struct {
+	path          string
+	minFreeBytes  uint64
+	checkInterval time.Duration
+}
+
+// SetDiskWatch makes the workflow check the free space on the filesystem
+// containing path before launching each new task. When the free space is below
+// minFreeBytes, a warning is logged, and new tasks are not started until the
+// free space is above the threshold again (such as after temporary files have
+// been cleaned up), re-checking every checkInterval. Already running tasks are
+// not affected.
+func (wf *Workflow) SetDiskWatch(path string, minFreeBytes uint64, checkInterval time.Duration) {
+	if checkInterval <= 0 {
+		Error.Fatalf("%s: Disk watch check interval has to be positive, was %s\n", wf.name, checkInterval)
+	}
+	wf.diskWatch = &diskWatch{
+		path:          path,
+		minFreeBytes:  minFreeBytes,
+		checkInterval: checkInterval,
+	}
+}
+
+// waitForFreeDisk blocks until the free disk space is above the threshold set
+// with SetDiskWatch, or the workflow is cancelled. It returns immediately if
+// no disk watch is set.
+func (wf *Workflow) waitForFreeDisk() {
+	dw := wf.diskWatch
+	if dw == nil {
+		return
+	}
+	warned := false
+	for {
+		free, err := freeDiskBytes(dw.path)
+		if err != nil {
+			Warning.Printf("%s: Could not check free disk space of %s, so not waiting: %s\n", wf.name, dw.path, err)
+			return
+		}
+		if free >= dw.minFreeBytes {
+			if warned {
+				Info.Printf("%s: Free disk space of %s is back above threshold (%d bytes free), so resuming launching of new tasks\n", wf.name, dw.path, free)
+			}
+			return
+		}
+		if !warned {
+			Warning.Printf("%s: Free disk space of %s is below threshold (%d < %d bytes), so pausing launching of new tasks\n", wf.name, dw.path, free, dw.minFreeBytes)
+			warned = true
+		}
+		select {
+		case <-clk.After(dw.checkInterval):
+		case <-wf.ctx.Done():
+			return
+		}
+	}
+}
+
+// freeDiskBytes returns the number of bytes available to unprivileged users on
+// the filesystem containing path. It is a variable so that it can be replaced
+// in tests.
+var freeDiskBytes = func(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
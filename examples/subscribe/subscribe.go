@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	sci "github.com/scipipe/scipipe"
+)
+
+func main() {
+	wf := sci.NewWorkflow("subscribe_wf", 4)
+
+	hello := wf.NewProc("hello", "echo Hello {p:name} > {o:out}")
+	hello.SetPathCustom("out", func(t *sci.SciTask) string {
+		return "hello_" + t.Param("name") + ".txt"
+	})
+	hello.ParamPort("name").ConnectStr("Alice", "Bob", "Carol")
+
+	// Subscribe to the outputs before running, to get them on a channel,
+	// while the sink still drives the workflow
+	outputs := hello.Out("out").Subscribe()
+	wf.ConnectLast(hello.Out("out"))
+
+	wf.Run()
+
+	for ip := range outputs {
+		fmt.Printf("%s: %s", ip.GetPath(), ip.Read())
+	}
+}
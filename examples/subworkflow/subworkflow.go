@@ -1,62 +1,52 @@
-// An example that shows how to create a sub-network / sub-workflow that can be
-// used as a component
+// An example that shows how to create a sub-workflow, that can be used as a
+// component, and be instantiated multiple times in a workflow
 package main
 
-import sp "github.com/scipipe/scipipe"
+import (
+	"strconv"
+
+	sp "github.com/scipipe/scipipe"
+)
 
 func main() {
 	// Main workflow
-	wfl := sp.NewWorkflow("foobar_wf", 4)
+	wf := sp.NewWorkflow("trim_wf", 4)
+
+	for _, sample := range []string{"sample1", "sample2"} {
+		reads := wf.NewProc("reads_"+sample, "echo ACGTACGTACGT > {o:reads}")
+		reads.SetPathStatic("reads", sample+".txt")
 
-	// Sub-workflow
-	fbn := NewFooBarSubWorkflow(wfl, "foobar_subwf")
+		// Sub-workflow, connected like any other process
+		trim := NewTrimSubWorkflow(wf, "trim_"+sample, 6)
+		trim.In("reads").Connect(reads.Out("reads"))
+
+		wf.ConnectLast(trim.Out("trimmed"))
+	}
 
-	// Connect
-	wfl.ConnectLast(fbn.Out)
-	wfl.Run()
+	wf.Run()
 }
 
 // ------------------------------------------------
-// FooBarSubWorkflow
+// Trimming sub-workflow
 // ------------------------------------------------
 
-type FooBarSubWorkflow struct {
-	sp.Process
-	name  string
-	Procs map[string]*sp.SciProcess
-	Out   *sp.FilePort
-}
-
-func NewFooBarSubWorkflow(wf *sp.Workflow, name string) *FooBarSubWorkflow {
-	fbn := &FooBarSubWorkflow{
-		name:  name,
-		Procs: make(map[string]*sp.SciProcess),
-	}
+// NewTrimSubWorkflow creates a sub-workflow that trims each line of the
+// incoming files to length characters, and then reverses them
+func NewTrimSubWorkflow(wf *sp.Workflow, name string, length int) *sp.SubWorkflow {
+	sub := sp.NewSubWorkflow(wf, name)
 
-	fbn.Procs["foo"] = sp.NewProc(wf, "foo", "echo foo > {o:foo}")
-	fbn.Procs["foo"].SetPathStatic("foo", "foo.txt")
+	trim := sub.NewProc("trim", "cut -c 1-"+strconv.Itoa(length)+" {i:in} > {o:out}")
+	trim.SetPathExtend("in", "out", ".trim")
 
-	fbn.Procs["f2b"] = sp.NewProc(wf, "f2b", "sed 's/foo/bar/g' {i:foo} > {o:bar}")
-	fbn.Procs["f2b"].SetPathReplace("foo", "bar", ".txt", ".bar.txt")
+	rev := sub.NewProc("rev", "rev {i:in} > {o:out}")
+	rev.SetPathExtend("in", "out", ".rev")
 
 	// Connect together inner processes
-	fbn.Procs["foo"].Out("foo").Connect(fbn.Procs["f2b"].In("foo"))
-
-	// Connect last port of inner process to subnetwork out-port
-	fbn.Out = fbn.Procs["f2b"].Out("bar")
-	return fbn
-}
-
-func (wf *FooBarSubWorkflow) Name() string {
-	return wf.name
-}
-
-func (wf *FooBarSubWorkflow) Run() {
-	for _, proc := range wf.Procs {
-		go proc.Run()
-	}
-}
+	rev.In("in").Connect(trim.Out("out"))
 
-func (wf *FooBarSubWorkflow) IsConnected() bool {
-	return wf.Out.IsConnected()
+	// Expose the ports of the inner processes, to connect to in the main
+	// workflow
+	sub.ExposeIn("reads", trim.In("in"))
+	sub.ExposeOut("trimmed", rev.Out("out"))
+	return sub
 }
@@ -0,0 +1,128 @@
+package scipipe
+
+import (
+	"fmt"
+	"sort"
+	str "strings"
+	"sync"
+)
+
+// ================== Executors ==================
+
+// TaskExecutor executes the command of a task, such as on the local computer,
+// or by submitting it to a cluster resource manager, and returns when it has
+// finished, with an error if it failed. The command has to write its outputs
+// to the temporary paths of the out-targets of the task (as in the formatted
+// command), from where they are atomized afterwards, as usual. BatchExecutor
+// is one example of a TaskExecutor.
+type TaskExecutor interface {
+	Execute(t *SciTask) error
+}
+
+// SupportChecker can be implemented by executors that can not execute the
+// commands of all processes, such as ones using features that only work for
+// commands executed locally (for example Script or Sandbox). Supports returns
+// an error if the process p uses any such features, upon which the
+// validation of the workflow fails (see Workflow.Validate), instead of the
+// features being silently ignored. SlurmExecutor and BatchExecutor implement
+// it.
+type SupportChecker interface {
+	Supports(p *SciProcess) error
+}
+
+// ExecutorOptions are the options that an executor is created with, by its
+// ExecutorFactory, as strings by name, so that they can come from
+// configuration files or command-line flags. Factories should return an error
+// for unknown options, which can be done with Check.
+type ExecutorOptions map[string]string
+
+// Check returns an error if there are any other options than the allowed ones
+func (opts ExecutorOptions) Check(allowed ...string) error {
+	isAllowed := map[string]bool{}
+	for _, name := range allowed {
+		isAllowed[name] = true
+	}
+	unknown := []string{}
+	for name := range opts {
+		if !isAllowed[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown executor option(s): %s (allowed are: %s)", str.Join(unknown, ", "), str.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// ExecutorFactory creates a new TaskExecutor with the options opts, for
+// registering it by name with RegisterExecutor
+type ExecutorFactory func(opts ExecutorOptions) (TaskExecutor, error)
+
+var (
+	executorFactories = map[string]ExecutorFactory{
+		"local": newLocalExecutor,
+		"slurm": newSlurmExecutor,
+	}
+	executorFactoriesMx sync.Mutex
+)
+
+// RegisterExecutor registers the executor factory under name, so that the
+// executor can be selected by name with Workflow.SetExecutor, such as from
+// configuration, without changing the code of the workflow. The executors
+// "local", executing commands on the local computer, and "slurm" (see
+// SlurmExecutor, with the options "args", "sbatch" and "scancel"), are always
+// registered.
+func RegisterExecutor(name string, factory ExecutorFactory) {
+	executorFactoriesMx.Lock()
+	defer executorFactoriesMx.Unlock()
+	if _, ok := executorFactories[name]; ok {
+		Error.Fatalf("An executor with the name '%s' is already registered\n", name)
+	}
+	executorFactories[name] = factory
+}
+
+// SetExecutor sets the executor that the commands of all tasks in the
+// workflow are executed with, to a new executor of the registered executor
+// name (see RegisterExecutor), created with the options opts. An error is
+// returned if no such executor is registered, or if it could not be created
+// with the options. Processes with a CustomExecute function or a
+// BatchExecutor set keep using those. If the executor implements
+// SupportChecker, the processes executed with it are checked with it when
+// the workflow is validated.
+func (wf *Workflow) SetExecutor(name string, opts ExecutorOptions) error {
+	executorFactoriesMx.Lock()
+	factory, ok := executorFactories[name]
+	registered := []string{}
+	for registeredName := range executorFactories {
+		registered = append(registered, registeredName)
+	}
+	executorFactoriesMx.Unlock()
+	if !ok {
+		sort.Strings(registered)
+		return fmt.Errorf("%s: No executor registered with the name '%s' (registered are: %s)", wf.name, name, str.Join(registered, ", "))
+	}
+	executor, err := factory(opts)
+	if err != nil {
+		return fmt.Errorf("%s: Could not create executor '%s': %s", wf.name, name, err)
+	}
+	wf.executor = executor
+	return nil
+}
+
+// LocalExecutor executes the commands of tasks on the local computer, which
+// is what is done by default
+type LocalExecutor struct{}
+
+func newLocalExecutor(opts ExecutorOptions) (TaskExecutor, error) {
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+	return &LocalExecutor{}, nil
+}
+
+// Execute executes the command of t on the local computer, trying its command
+// alternatives, if any, as long as the command fails
+func (e *LocalExecutor) Execute(t *SciTask) error {
+	return t.executeWithAlternatives()
+}
@@ -0,0 +1,195 @@
+package scipipe
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExecutor writes the given content to the outputs of the tasks, instead
+// of executing their commands, and records the commands
+type fakeExecutor struct {
+	content  string
+	commands []string
+	mx       sync.Mutex
+}
+
+func (e *fakeExecutor) Execute(t *SciTask) error {
+	e.mx.Lock()
+	e.commands = append(e.commands, t.Command)
+	e.mx.Unlock()
+	for _, oip := range t.OutTargets {
+		oip.WriteTempFile([]byte(e.content))
+	}
+	return nil
+}
+
+func TestRegisterExecutor(t *testing.T) {
+	initTestLogs()
+
+	var executor *fakeExecutor
+	RegisterExecutor("fake", func(opts ExecutorOptions) (TaskExecutor, error) {
+		if err := opts.Check("content"); err != nil {
+			return nil, err
+		}
+		if opts["content"] == "" {
+			return nil, errors.New("Option content is required")
+		}
+		executor = &fakeExecutor{content: opts["content"]}
+		return executor, nil
+	})
+
+	wf := NewWorkflow("TestRegisterExecutorWf", 4)
+	assert.Error(t, wf.SetExecutor("nonexisting", nil), "Selecting an unregistered executor should fail")
+	assert.Error(t, wf.SetExecutor("fake", ExecutorOptions{"content": "x", "other": "y"}), "Unknown options should be rejected")
+	err := wf.SetExecutor("fake", ExecutorOptions{"content": "fake output\n"})
+	assert.Nil(t, err)
+
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathPattern("out", "/tmp/executor_{p:msg}.txt")
+	echo.ParamPort("msg").ConnectStr("a", "b", "c")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, 3, len(executor.commands), "Executor should be invoked once for each task")
+	for _, msg := range []string{"a", "b", "c"} {
+		path := "/tmp/executor_" + msg + ".txt"
+		assert.Equal(t, "fake output\n", string(NewInformationPacket(path).Read()), "Output should be produced by the executor")
+		cleanFiles(path, path+".audit.json")
+	}
+
+	wf = NewWorkflow("TestRegisterExecutorLocalWf", 4)
+	err = wf.SetExecutor("local", nil)
+	assert.Nil(t, err, "The local executor should always be registered")
+	echo = wf.NewProc("echo", "echo local > {o:out}")
+	echo.SetPathStatic("out", "/tmp/executor_local.txt")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+	assert.Equal(t, "local\n", string(NewInformationPacket("/tmp/executor_local.txt").Read()))
+	cleanFiles("/tmp/executor_local.txt", "/tmp/executor_local.txt.audit.json")
+}
+
+// scriptlessExecutor is a fakeExecutor that does not support Script
+type scriptlessExecutor struct {
+	fakeExecutor
+}
+
+func (e *scriptlessExecutor) Supports(p *SciProcess) error {
+	if p.Script {
+		return errors.New("Script not supported")
+	}
+	return nil
+}
+
+func TestSupportChecker(t *testing.T) {
+	initTestLogs()
+
+	RegisterExecutor("scriptless", func(opts ExecutorOptions) (TaskExecutor, error) {
+		return &scriptlessExecutor{}, nil
+	})
+	wf := NewWorkflow("TestSupportCheckerWf", 4)
+	err := wf.SetExecutor("scriptless", nil)
+	assert.Nil(t, err)
+	echo := wf.NewProc("echo", "echo hej > {o:out}")
+	echo.SetPathStatic("out", "/tmp/supportchecker.txt")
+	wf.ConnectLast(echo.Out("out"))
+	assert.Nil(t, wf.Validate())
+
+	echo.Script = true
+	assert.EqualError(t, wf.Validate(), "TestSupportCheckerWf: Script not supported", "The executor of the workflow should check the processes")
+	echo.Executor = &fakeExecutor{}
+	assert.Nil(t, wf.Validate(), "The executor of the process should override the one of the workflow")
+
+	echo.BatchExecutor = NewBatchExecutor(2, time.Second)
+	assert.EqualError(t, wf.Validate(), "TestSupportCheckerWf: Process echo: Can not be executed with a BatchExecutor, since it uses: Script")
+	echo.CustomExecute = func(t *SciTask) {}
+	assert.Nil(t, wf.Validate(), "Processes with CustomExecute should not be checked")
+}
+
+func TestProcessExecutor(t *testing.T) {
+	initTestLogs()
+
+	executor := &fakeExecutor{content: "process executor\n"}
+	wf := NewWorkflow("TestProcessExecutorWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathPattern("out", "/tmp/procexecutor_{p:msg}.txt")
+	echo.ParamPort("msg").ConnectStr("a", "b")
+	echo.Executor = executor
+	finalExisted := map[string]bool{}
+	echo.OnTaskComplete = func(task *SciTask, err error) {
+		finalExisted[task.Param("msg")] = task.OutTargets["out"].Exists()
+	}
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, 2, len(executor.commands), "Executor of the process should be invoked once for each task")
+	for _, msg := range []string{"a", "b"} {
+		path := "/tmp/procexecutor_" + msg + ".txt"
+		assert.True(t, finalExisted[msg], "Outputs should be moved in place after the executor has written them")
+		assert.Equal(t, "process executor\n", string(NewInformationPacket(path).Read()))
+		cleanFiles(path, path+".audit.json")
+	}
+}
+
+func TestSlurmExecutor(t *testing.T) {
+	initTestLogs()
+
+	// A fake sbatch, recording its arguments, and running the wrapped command
+	// like a job would
+	sbatch := "/tmp/slurm_fake_sbatch.sh"
+	argsPath := "/tmp/slurm_sbatch_args.txt"
+	script := "#!/bin/bash\n" +
+		"printf '%s\\n' \"$@\" > " + argsPath + "\n" +
+		"echo '4711;cluster'\n" +
+		"for arg in \"$@\"; do case \"$arg\" in --wrap=*) eval \"${arg#--wrap=}\";; esac; done\n"
+	err := ioutil.WriteFile(sbatch, []byte(script), 0755)
+	assert.Nil(t, err)
+
+	wf := NewWorkflow("TestSlurmExecutorWf", 4)
+	err = wf.SetExecutor("slurm", ExecutorOptions{"sbatch": sbatch, "args": "--partition=core --time=1:00:00"})
+	assert.Nil(t, err, "The slurm executor should always be registered")
+	echo := wf.NewProc("echo", "echo slurm > {o:out}")
+	echo.SetPathStatic("out", "/tmp/slurm_out.txt")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, "slurm\n", string(NewInformationPacket("/tmp/slurm_out.txt").Read()), "Output should be produced by the wrapped command")
+	args := string(NewInformationPacket(argsPath).Read())
+	for _, arg := range []string{"--parsable", "--wait", "--job-name=echo", "--partition=core", "--time=1:00:00", "--wrap="} {
+		assert.Contains(t, args, arg, "sbatch should be called with "+arg)
+	}
+	cleanFiles(argsPath, "/tmp/slurm_out.txt", "/tmp/slurm_out.txt.audit.json")
+
+	// Processes with ExecModeSLURM use the SLURM executor of the workflow
+	wf = NewWorkflow("TestSlurmExecutorExecModeWf", 4)
+	err = wf.SetExecutor("slurm", ExecutorOptions{"sbatch": sbatch, "args": "--account=myproject"})
+	assert.Nil(t, err)
+	echo = wf.NewProc("echo", "echo slurm > {o:out}")
+	echo.SetPathStatic("out", "/tmp/slurm_out.txt")
+	echo.ExecMode = ExecModeSLURM
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+	assert.Contains(t, string(NewInformationPacket(argsPath).Read()), "--account=myproject", "ExecModeSLURM should use the configured executor")
+	cleanFiles(sbatch, argsPath, "/tmp/slurm_out.txt", "/tmp/slurm_out.txt.audit.json")
+}
+
+func TestSlurmExecutor_UnsupportedFeatures(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestSlurmExecutorUnsupportedWf", 4)
+	echo := wf.NewProc("echo", "echo slurm > {o:out}")
+	echo.SetPathStatic("out", "/tmp/slurm_unsupported.txt")
+	echo.ExecMode = ExecModeSLURM
+	echo.Script = true
+	echo.StderrMode = OutputModeDiscard
+	wf.ConnectLast(echo.Out("out"))
+	assert.EqualError(t, wf.Validate(), "TestSlurmExecutorUnsupportedWf: Process echo: Can not be executed with SLURM, since it uses: Script, other StdoutMode and StderrMode than OutputModeCapture")
+
+	echo.Script = false
+	echo.StderrMode = OutputModeCapture
+	assert.Nil(t, wf.Validate())
+}
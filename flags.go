@@ -0,0 +1,112 @@
+package scipipe
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	str "strings"
+)
+
+// ----------------------------------------------------------------------------
+// Command-line flags for parameters
+// ----------------------------------------------------------------------------
+
+// flagBinding is a command-line flag bound to a parameter port
+type flagBinding struct {
+	name  string
+	value *string
+	port  *ParamPort
+}
+
+// BindFlags registers a string flag in fs (or in flag.CommandLine, if fs is
+// nil) for each parameter port, among all the processes in the workflow, that
+// is not connected yet, so that small workflow programs can be given their
+// parameters on the command line. The flags are named as the parameters,
+// such as -ref for {p:ref}, except when more than one process has a parameter
+// with the same name (or the name is already taken by another flag), in which
+// case they are namespaced by the process name, such as -align.threads.
+//
+// The flags have to be parsed (such as with flag.Parse) before the workflow is
+// run, when the parameter ports are connected to the values of the flags. Run
+// returns an error if a flag bound to a parameter port is not given. Note that
+// processes have to be added to the workflow before BindFlags is called.
+//
+// An error is returned, and no flags are registered, if the namespaced name
+// of a parameter is also already taken by another flag.
+func (wf *Workflow) BindFlags(fs *flag.FlagSet) error {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	procNames := []string{}
+	for name := range wf.procs {
+		procNames = append(procNames, name)
+	}
+	sort.Strings(procNames)
+
+	type unboundParam struct {
+		procName  string
+		paramName string
+		port      *ParamPort
+	}
+	params := []unboundParam{}
+	procsWithParam := map[string]int{}
+	for _, procName := range procNames {
+		proc, ok := wf.procs[procName].(interface{ GetParamPorts() map[string]*ParamPort })
+		if !ok {
+			continue
+		}
+		paramNames := []string{}
+		for paramName, port := range proc.GetParamPorts() {
+			if !port.IsConnected() {
+				paramNames = append(paramNames, paramName)
+			}
+		}
+		sort.Strings(paramNames)
+		for _, paramName := range paramNames {
+			params = append(params, unboundParam{procName, paramName, proc.GetParamPorts()[paramName]})
+			procsWithParam[paramName]++
+		}
+	}
+
+	names := []string{}
+	for _, param := range params {
+		name := param.paramName
+		if procsWithParam[name] > 1 || fs.Lookup(name) != nil {
+			name = param.procName + "." + param.paramName
+			if fs.Lookup(name) != nil {
+				return fmt.Errorf("%s: Can not bind parameter %s of process %s to a flag, since the flag -%s is already defined", wf.name, param.paramName, param.procName, name)
+			}
+		}
+		names = append(names, name)
+	}
+	for i, param := range params {
+		usage := fmt.Sprintf("Value of parameter %s of process %s", param.paramName, param.procName)
+		wf.flagBindings = append(wf.flagBindings, &flagBinding{
+			name:  names[i],
+			value: fs.String(names[i], "", usage),
+			port:  param.port,
+		})
+	}
+	return nil
+}
+
+// connectFlags connects the parameter ports bound to flags with BindFlags to
+// the values of the flags, and returns an error if any of the flags was not
+// given
+func (wf *Workflow) connectFlags() error {
+	missing := []string{}
+	for _, fb := range wf.flagBindings {
+		if fb.port.IsConnected() {
+			continue
+		}
+		if *fb.value == "" {
+			missing = append(missing, "-"+fb.name)
+			continue
+		}
+		fb.port.ConnectStr(*fb.value)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: Missing values for parameters, given with the flags: %s", wf.name, str.Join(missing, ", "))
+	}
+	return nil
+}
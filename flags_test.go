@@ -0,0 +1,57 @@
+package scipipe
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindFlags(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestBindFlagsWf", 4)
+	greet := wf.NewProc("greet", "echo {p:greeting} {p:times} > {o:out}")
+	greet.SetPathStatic("out", "/tmp/bindflags_greet.txt")
+	repeat := wf.NewProc("repeat", "for i in $(seq {p:times}); do cat {i:in}; done > {o:out}")
+	repeat.SetPathStatic("out", "/tmp/bindflags_repeat.txt")
+	repeat.In("in").Connect(greet.Out("out"))
+	wf.ConnectLast(repeat.Out("out"))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	assert.Nil(t, wf.BindFlags(fs))
+	assert.NotNil(t, fs.Lookup("greeting"), "Unique param should get a plain flag")
+	assert.Nil(t, fs.Lookup("times"), "Param in more than one process should not get a plain flag")
+	assert.NotNil(t, fs.Lookup("greet.times"), "Param in more than one process should get a namespaced flag")
+	assert.NotNil(t, fs.Lookup("repeat.times"), "Param in more than one process should get a namespaced flag")
+
+	err := fs.Parse([]string{"-greeting", "hello", "-greet.times", "1", "-repeat.times", "2"})
+	assert.Nil(t, err)
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/bindflags_repeat.txt")
+	assert.Equal(t, "hello 1\nhello 1\n", string(ip.Read()), "Params not connected from flags")
+	cleanFiles("/tmp/bindflags_greet.txt", "/tmp/bindflags_repeat.txt", "/tmp/bindflags_greet.txt.audit.json", "/tmp/bindflags_repeat.txt.audit.json")
+
+	wf = NewWorkflow("TestBindFlagsMissingWf", 4)
+	greet = wf.NewProc("greet", "echo {p:greeting} > {o:out}")
+	greet.SetPathStatic("out", "/tmp/bindflags_missing.txt")
+	wf.ConnectLast(greet.Out("out"))
+	assert.Nil(t, wf.BindFlags(flag.NewFlagSet("test", flag.ContinueOnError)))
+	assert.EqualError(t, wf.Run(), "TestBindFlagsMissingWf: Missing values for parameters, given with the flags: -greeting")
+}
+
+func TestBindFlags_NamespacedNameTaken(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestBindFlagsTakenWf", 4)
+	greet := wf.NewProc("greet", "echo {p:greeting} > {o:out}")
+	greet.SetPathStatic("out", "/tmp/bindflags_taken.txt")
+	wf.ConnectLast(greet.Out("out"))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("greeting", "", "")
+	fs.String("greet.greeting", "", "")
+	err := wf.BindFlags(fs)
+	assert.EqualError(t, err, "TestBindFlagsTakenWf: Can not bind parameter greeting of process greet to a flag, since the flag -greet.greeting is already defined")
+}
@@ -0,0 +1,183 @@
+package scipipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	str "strings"
+	"sync"
+)
+
+// ================== File format detection ==================
+
+// FileFormat is the detected format (content type) of a file, such as
+// returned by InformationPacket.DetectFormat
+type FileFormat string
+
+const (
+	FileFormatUnknown FileFormat = "unknown"
+	FileFormatGzip    FileFormat = "gzip"
+	FileFormatBAM     FileFormat = "bam"
+	FileFormatVCF     FileFormat = "vcf"
+	FileFormatFASTA   FileFormat = "fasta"
+	FileFormatFASTQ   FileFormat = "fastq"
+)
+
+// formatPrefixSize is the number of bytes read from the start of a file, to
+// detect its format
+const formatPrefixSize = 512
+
+// FormatDetector detects the format of a file, from its path and prefix (the
+// first bytes of its content, possibly fewer than the whole file), and returns
+// FileFormatUnknown if it does not recognize it
+type FormatDetector func(path string, prefix []byte) FileFormat
+
+var (
+	customFormatDetectors   []FormatDetector
+	customFormatDetectorsMx sync.Mutex
+)
+
+// builtinFormatDetectors are tried in order, after any custom detectors. The
+// detection by extension comes last, so that magic bytes and content take
+// precedence.
+var builtinFormatDetectors = []FormatDetector{
+	detectFormatGzipOrBAM,
+	detectFormatVCF,
+	detectFormatFASTQ,
+	detectFormatFASTA,
+	detectFormatByExtension,
+}
+
+// RegisterFormatDetector adds a custom detector, used by DetectFormat and
+// DetectInnerFormat. Custom detectors are tried before the built-in ones, in
+// the order they were registered, and the first format found is used.
+func RegisterFormatDetector(detector FormatDetector) {
+	customFormatDetectorsMx.Lock()
+	defer customFormatDetectorsMx.Unlock()
+	customFormatDetectors = append(customFormatDetectors, detector)
+}
+
+// DetectFormat detects the format of the file of the IP, from the magic bytes
+// or content at the start of the file, or else from the extension of its path.
+// Gzip-compressed files are detected as FileFormatGzip, except for BAM files,
+// which are always compressed. Use DetectInnerFormat to detect the format of
+// the compressed content.
+func (ip *InformationPacket) DetectFormat() FileFormat {
+	return detectFormat(ip.GetPath(), ip.readPrefix(false))
+}
+
+// DetectInnerFormat detects the format of the decompressed content of the
+// file of the IP, if it is gzip-compressed (such as FileFormatFASTQ for a
+// file "reads.fq.gz"), and otherwise works like DetectFormat
+func (ip *InformationPacket) DetectInnerFormat() FileFormat {
+	format := ip.DetectFormat()
+	if format != FileFormatGzip {
+		return format
+	}
+	return detectFormat(str.TrimSuffix(ip.GetPath(), filepath.Ext(ip.GetPath())), ip.readPrefix(true))
+}
+
+// readPrefix reads up to formatPrefixSize bytes from the start of the file of
+// the IP, decompressing it first if decompress is true. Read errors, such as
+// from a truncated gzip file, just make the prefix shorter.
+func (ip *InformationPacket) readPrefix(decompress bool) []byte {
+	f := ip.Open()
+	defer f.Close()
+	var r io.Reader = f
+	if decompress {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return []byte{}
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	prefix := make([]byte, formatPrefixSize)
+	n, _ := io.ReadFull(r, prefix)
+	return prefix[:n]
+}
+
+// detectFormat tries the custom, and then the built-in, format detectors on
+// path and prefix, and returns the first format found
+func detectFormat(path string, prefix []byte) FileFormat {
+	customFormatDetectorsMx.Lock()
+	detectors := append(append([]FormatDetector{}, customFormatDetectors...), builtinFormatDetectors...)
+	customFormatDetectorsMx.Unlock()
+	for _, detect := range detectors {
+		if format := detect(path, prefix); format != FileFormatUnknown && format != "" {
+			return format
+		}
+	}
+	return FileFormatUnknown
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	bamMagic  = []byte("BAM\x01")
+)
+
+// detectFormatGzipOrBAM detects gzip files, and BAM files, which are gzip
+// (BGZF) files with content starting with the BAM magic bytes
+func detectFormatGzipOrBAM(path string, prefix []byte) FileFormat {
+	if bytes.HasPrefix(prefix, bamMagic) {
+		return FileFormatBAM
+	}
+	if !bytes.HasPrefix(prefix, gzipMagic) {
+		return FileFormatUnknown
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(prefix))
+	if err == nil {
+		inner := make([]byte, len(bamMagic))
+		if n, _ := io.ReadFull(gzr, inner); bytes.Equal(inner[:n], bamMagic) {
+			return FileFormatBAM
+		}
+	}
+	return FileFormatGzip
+}
+
+func detectFormatVCF(path string, prefix []byte) FileFormat {
+	if bytes.HasPrefix(prefix, []byte("##fileformat=VCF")) {
+		return FileFormatVCF
+	}
+	return FileFormatUnknown
+}
+
+// detectFormatFASTQ detects FASTQ files by their first record, where the
+// header line starts with "@" and the separator line (the third one) starts
+// with "+"
+func detectFormatFASTQ(path string, prefix []byte) FileFormat {
+	lines := bytes.SplitN(prefix, []byte("\n"), 4)
+	if len(lines) >= 3 && bytes.HasPrefix(lines[0], []byte("@")) && bytes.HasPrefix(lines[2], []byte("+")) {
+		return FileFormatFASTQ
+	}
+	return FileFormatUnknown
+}
+
+func detectFormatFASTA(path string, prefix []byte) FileFormat {
+	if bytes.HasPrefix(prefix, []byte(">")) {
+		return FileFormatFASTA
+	}
+	return FileFormatUnknown
+}
+
+// formatExtensions are the file extensions used to detect the format of files
+// whose content is not recognized, such as empty ones
+var formatExtensions = map[string]FileFormat{
+	".gz":    FileFormatGzip,
+	".bam":   FileFormatBAM,
+	".vcf":   FileFormatVCF,
+	".fa":    FileFormatFASTA,
+	".fasta": FileFormatFASTA,
+	".fna":   FileFormatFASTA,
+	".faa":   FileFormatFASTA,
+	".fq":    FileFormatFASTQ,
+	".fastq": FileFormatFASTQ,
+}
+
+func detectFormatByExtension(path string, prefix []byte) FileFormat {
+	if format, ok := formatExtensions[str.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+	return FileFormatUnknown
+}
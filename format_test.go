@@ -0,0 +1,66 @@
+package scipipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gzipBytes returns content compressed with gzip
+func gzipBytes(content []byte) []byte {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	gzw.Write(content)
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	initTestLogs()
+
+	fastq := []byte("@read1\nACGT\n+\nIIII\n")
+	for _, tc := range []struct {
+		path        string
+		content     []byte
+		format      FileFormat
+		innerFormat FileFormat
+		detectedBy  string
+	}{
+		{"/tmp/detect_format.fa", []byte(">seq1\nACGT\n"), FileFormatFASTA, FileFormatFASTA, "content"},
+		{"/tmp/detect_format.txt", fastq, FileFormatFASTQ, FileFormatFASTQ, "content"},
+		{"/tmp/detect_format.vcf", []byte("##fileformat=VCFv4.2\n#CHROM\tPOS\n"), FileFormatVCF, FileFormatVCF, "content"},
+		{"/tmp/detect_format.dat", gzipBytes([]byte("BAM\x01\x00\x00")), FileFormatBAM, FileFormatBAM, "magic bytes"},
+		{"/tmp/detect_format.fq.gz", gzipBytes(fastq), FileFormatGzip, FileFormatFASTQ, "magic bytes"},
+		{"/tmp/detect_format.fasta", []byte{}, FileFormatFASTA, FileFormatFASTA, "extension"},
+		{"/tmp/detect_format.bin", []byte("something else\n"), FileFormatUnknown, FileFormatUnknown, "nothing"},
+	} {
+		err := ioutil.WriteFile(tc.path, tc.content, 0644)
+		assert.Nil(t, err)
+		ip := NewInformationPacket(tc.path)
+		assert.Equal(t, tc.format, ip.DetectFormat(), "Wrong format detected by %s for %s", tc.detectedBy, tc.path)
+		assert.Equal(t, tc.innerFormat, ip.DetectInnerFormat(), "Wrong inner format detected for %s", tc.path)
+		cleanFiles(tc.path)
+	}
+}
+
+func TestRegisterFormatDetector(t *testing.T) {
+	initTestLogs()
+
+	RegisterFormatDetector(func(path string, prefix []byte) FileFormat {
+		if bytes.HasPrefix(prefix, []byte("##gff-version")) {
+			return FileFormat("gff")
+		}
+		return FileFormatUnknown
+	})
+
+	path := "/tmp/detect_format_custom.gff.gz"
+	err := ioutil.WriteFile(path, gzipBytes([]byte("##gff-version 3\n")), 0644)
+	assert.Nil(t, err)
+	ip := NewInformationPacket(path)
+	assert.Equal(t, FileFormatGzip, ip.DetectFormat())
+	assert.Equal(t, FileFormat("gff"), ip.DetectInnerFormat(), "Custom detector not used for the inner format")
+	cleanFiles(path)
+}
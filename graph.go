@@ -0,0 +1,294 @@
+package scipipe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ----------------------------------------------------------------------------
+// Workflow graph
+// ----------------------------------------------------------------------------
+
+// WorkflowGraph is the static structure of a workflow: its processes, and the
+// connections between their ports, as set up before running it. It can be
+// used for visualizing or analyzing the workflow, such as with WriteDOT.
+type WorkflowGraph struct {
+	Name string
+	// Nodes are the processes of the workflow, by name, including the sink,
+	// if anything is connected to it
+	Nodes map[string]Process
+	// Edges are the connections between the ports of the processes, sorted
+	Edges []*GraphEdge
+	// UnconnectedPorts are the ports of the processes that are not connected
+	// to any other port at all, sorted
+	UnconnectedPorts []*GraphPort
+}
+
+// GraphPort is a port of a process in the graph
+type GraphPort struct {
+	Proc string
+	Port string
+	// In is true for in-ports, and false for out-ports, or ports whose
+	// direction is not known (see Graph)
+	In bool
+	// Param is true for parameter ports, and false for file ports
+	Param bool
+}
+
+// GraphEdge is a connection from an out-port of one process to an in-port of
+// another one
+type GraphEdge struct {
+	From     string
+	FromPort string
+	To       string
+	ToPort   string
+	// Param is true for connections between parameter ports, and false for
+	// connections between file ports
+	Param bool
+}
+
+// portDirection tells whether a port is an in-port or an out-port, if known
+type portDirection int
+
+const (
+	portDirectionUnknown portDirection = iota
+	portDirectionIn
+	portDirectionOut
+)
+
+// graphPort is a port of a process in the workflow
+type graphPort struct {
+	procName  string
+	portName  string
+	direction portDirection
+}
+
+// Graph returns the graph of the processes in the workflow, and the
+// connections between their ports.
+//
+// The ports of SciProcesses are found by their names, while for other
+// processes, the exported fields of type *FilePort, []*FilePort,
+// map[string]*FilePort or *ParamPort are used, named as the fields (such as
+// "In", or "In[0]" for slices). Processes can also list their ports with
+// GetInPorts, GetOutPorts or GetParamPorts methods, like SciProcess does.
+//
+// Since connections are symmetric, the direction of connections between ports
+// of processes other than SciProcesses can not always be known for sure. They
+// are then assumed to follow the convention of calling Connect on the in-port,
+// with the out-port as argument, as in inPort.Connect(outPort). Connections
+// to ports not belonging to any process in the workflow are not included.
+func (wf *Workflow) Graph() *WorkflowGraph {
+	g := &WorkflowGraph{
+		Name:  wf.name,
+		Nodes: map[string]Process{},
+		Edges: []*GraphEdge{},
+	}
+	for name, proc := range wf.procs {
+		g.Nodes[name] = proc
+	}
+	if wf.sink.IsConnected() {
+		g.Nodes[wf.sink.Name()] = wf.sink
+	}
+
+	ports := map[interface{}]graphPort{}
+	for name, proc := range g.Nodes {
+		for port, gp := range processPorts(proc) {
+			gp.procName = name
+			ports[port] = gp
+		}
+	}
+	for port, gp := range ports {
+		switch pt := port.(type) {
+		case *FilePort:
+			for _, remotePort := range pt.remotePorts {
+				if remote, ok := ports[remotePort]; ok {
+					g.addEdge(gp, remote, false)
+				}
+			}
+			if !pt.IsConnected() {
+				g.addUnconnectedPort(gp, false)
+			}
+		case *ParamPort:
+			for _, remotePort := range pt.remotePorts {
+				if remote, ok := ports[remotePort]; ok {
+					g.addEdge(gp, remote, true)
+				}
+			}
+			if !pt.IsConnected() {
+				g.addUnconnectedPort(gp, true)
+			}
+		}
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		return g.Edges[i].String() < g.Edges[j].String()
+	})
+	sort.Slice(g.UnconnectedPorts, func(i, j int) bool {
+		return g.UnconnectedPorts[i].String() < g.UnconnectedPorts[j].String()
+	})
+	return g
+}
+
+// DotGraph returns the graph of the workflow in the DOT format of Graphviz
+// (see Graph and WorkflowGraph.WriteDOT)
+func (wf *Workflow) DotGraph() string {
+	dot := &bytes.Buffer{}
+	wf.Graph().WriteDOT(dot) // Writing to a buffer does not fail
+	return dot.String()
+}
+
+// WriteDotFile writes the graph of the workflow in the DOT format of Graphviz
+// to the file at path, which can be rendered with Graphviz, such as with "dot
+// -Tpng -o workflow.png <path>"
+func (wf *Workflow) WriteDotFile(path string) error {
+	return ioutil.WriteFile(path, []byte(wf.DotGraph()), 0644)
+}
+
+// addEdge adds an edge for a connection made by calling Connect on the port
+// local, with the port remote as argument
+func (g *WorkflowGraph) addEdge(local graphPort, remote graphPort, param bool) {
+	from, to := remote, local
+	if local.direction == portDirectionOut || remote.direction == portDirectionIn {
+		from, to = local, remote
+	}
+	g.Edges = append(g.Edges, &GraphEdge{
+		From:     from.procName,
+		FromPort: from.portName,
+		To:       to.procName,
+		ToPort:   to.portName,
+		Param:    param,
+	})
+}
+
+func (g *WorkflowGraph) addUnconnectedPort(gp graphPort, param bool) {
+	g.UnconnectedPorts = append(g.UnconnectedPorts, &GraphPort{
+		Proc:  gp.procName,
+		Port:  gp.portName,
+		In:    gp.direction == portDirectionIn,
+		Param: param,
+	})
+}
+
+// processPorts returns the ports of proc (see Graph for how they are found),
+// by the port (a *FilePort or *ParamPort)
+func processPorts(proc Process) map[interface{}]graphPort {
+	ports := map[interface{}]graphPort{}
+	if p, ok := proc.(interface{ GetInPorts() map[string]*FilePort }); ok {
+		for name, port := range p.GetInPorts() {
+			ports[port] = graphPort{portName: name, direction: portDirectionIn}
+		}
+	}
+	if p, ok := proc.(interface{ GetOutPorts() map[string]*FilePort }); ok {
+		for name, port := range p.GetOutPorts() {
+			ports[port] = graphPort{portName: name, direction: portDirectionOut}
+		}
+	}
+	if p, ok := proc.(interface{ GetParamPorts() map[string]*ParamPort }); ok {
+		for name, port := range p.GetParamPorts() {
+			ports[port] = graphPort{portName: name, direction: portDirectionIn}
+		}
+	}
+	if sink, ok := proc.(*Sink); ok {
+		ports[sink.inPort] = graphPort{portName: "in", direction: portDirectionIn}
+	}
+
+	addPort := func(port interface{}, name string) {
+		if _, ok := ports[port]; !ok {
+			ports[port] = graphPort{portName: name}
+		}
+	}
+	val := reflect.ValueOf(proc)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return ports
+	}
+	val = val.Elem()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		if field.PkgPath != "" { // Unexported
+			continue
+		}
+		switch fv := val.Field(i).Interface().(type) {
+		case *FilePort:
+			if fv != nil {
+				addPort(fv, field.Name)
+			}
+		case *ParamPort:
+			if fv != nil {
+				addPort(fv, field.Name)
+			}
+		case []*FilePort:
+			for j, port := range fv {
+				addPort(port, field.Name+"["+strconv.Itoa(j)+"]")
+			}
+		case map[string]*FilePort:
+			for key, port := range fv {
+				addPort(port, field.Name+"["+key+"]")
+			}
+		}
+	}
+	return ports
+}
+
+func (e *GraphEdge) String() string {
+	return e.From + "." + e.FromPort + " -> " + e.To + "." + e.ToPort
+}
+
+func (p *GraphPort) String() string {
+	return p.Proc + "." + p.Port
+}
+
+// WriteDOT writes the graph in the DOT format of Graphviz to w, with the
+// processes as nodes, labelled with their names, and for SciProcesses, their
+// command patterns, and the connections as edges labelled with the names of
+// the ports. Connections between parameter ports are drawn dashed.
+// Unconnected ports are drawn in red, as edges to or from point nodes of
+// their own.
+func (g *WorkflowGraph) WriteDOT(w io.Writer) error {
+	nodeNames := []string{}
+	for name := range g.Nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+
+	if _, err := fmt.Fprintf(w, "digraph %s {\n", strconv.Quote(g.Name)); err != nil {
+		return err
+	}
+	for _, name := range nodeNames {
+		label := name
+		if p, ok := g.Nodes[name].(*SciProcess); ok {
+			label += "\n" + p.CommandPattern
+		}
+		if _, err := fmt.Fprintf(w, "  %s [label=%s];\n", strconv.Quote(name), strconv.Quote(label)); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		attrs := "label=" + strconv.Quote(e.FromPort+" -> "+e.ToPort)
+		if e.Param {
+			attrs += ", style=dashed"
+		}
+		if _, err := fmt.Fprintf(w, "  %s -> %s [%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), attrs); err != nil {
+			return err
+		}
+	}
+	for _, p := range g.UnconnectedPorts {
+		danglingNode := strconv.Quote(p.String() + " (unconnected)")
+		from, to := strconv.Quote(p.Proc), danglingNode
+		if p.In {
+			from, to = danglingNode, strconv.Quote(p.Proc)
+		}
+		attrs := "label=" + strconv.Quote(p.Port) + ", color=red, fontcolor=red"
+		if p.Param {
+			attrs += ", style=dashed"
+		}
+		if _, err := fmt.Fprintf(w, "  %s [shape=point, color=red];\n  %s -> %s [%s];\n", danglingNode, from, to, attrs); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
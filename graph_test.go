@@ -0,0 +1,88 @@
+package scipipe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestGraphWf", 4)
+	foo := wf.NewProc("foo", "echo {p:msg} > {o:out}")
+	foo.SetPathStatic("out", "/tmp/graph_foo.txt")
+	f2b := wf.NewProc("f2b", "sed 's/foo/bar/' {i:in} > {o:out}")
+	f2b.SetPathExtend("in", "out", ".bar")
+	// Connect from the out-port, to make sure the direction is still right
+	foo.Out("out").Connect(f2b.In("in"))
+	// Ports not belonging to any process in the workflow are not included
+	msgs := NewParamPort()
+	foo.ParamPort("msg").Connect(msgs)
+	wf.ConnectLast(f2b.Out("out"))
+
+	g := wf.Graph()
+	assert.Equal(t, []string{"TestGraphWf_default_sink", "f2b", "foo"}, sortedNodeNames(g))
+	assert.Equal(t, []*GraphEdge{
+		{From: "f2b", FromPort: "out", To: "TestGraphWf_default_sink", ToPort: "in"},
+		{From: "foo", FromPort: "out", To: "f2b", ToPort: "in"},
+	}, g.Edges, "Wrong edges in graph")
+
+	dot := &bytes.Buffer{}
+	assert.Nil(t, g.WriteDOT(dot))
+	assert.Contains(t, dot.String(), `"foo" -> "f2b" [label="out -> in"];`)
+}
+
+func TestDotGraph(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestDotGraphWf", 4)
+	foo := wf.NewProc("foo", "echo {p:msg} > {o:out}")
+	foo.ParamPort("msg").ConnectStr("foo")
+	f2b := wf.NewProc("f2b", "sed 's/foo/{p:bar}/' {i:in} > {o:out}")
+	f2b.In("in").Connect(foo.Out("out"))
+	baz := wf.NewProc("baz", "cat {i:in} > {o:out} 2> {o:log}")
+	baz.In("in").Connect(f2b.Out("out"))
+	wf.ConnectLast(baz.Out("out"))
+
+	dot := wf.DotGraph()
+	for _, line := range []string{
+		`  "foo" [label="foo\necho {p:msg} > {o:out}"];`,
+		`  "f2b" [label="f2b\nsed 's/foo/{p:bar}/' {i:in} > {o:out}"];`,
+		`  "foo" -> "f2b" [label="out -> in"];`,
+		`  "f2b" -> "baz" [label="out -> in"];`,
+		`  "baz" -> "TestDotGraphWf_default_sink" [label="out -> in"];`,
+		`  "f2b.bar (unconnected)" -> "f2b" [label="bar", color=red, fontcolor=red, style=dashed];`,
+		`  "baz" -> "baz.log (unconnected)" [label="log", color=red, fontcolor=red];`,
+	} {
+		assert.Contains(t, strings.Split(dot, "\n"), line)
+	}
+
+	// Check the overall structure, in lack of a DOT parser
+	lines := strings.Split(strings.TrimSuffix(dot, "\n"), "\n")
+	assert.Equal(t, `digraph "TestDotGraphWf" {`, lines[0])
+	assert.Equal(t, "}", lines[len(lines)-1])
+	for _, line := range lines[1 : len(lines)-1] {
+		assert.True(t, strings.HasPrefix(line, "  \"") && strings.HasSuffix(line, "];"), "Malformed line: %s", line)
+		assert.Equal(t, 0, strings.Count(line, "\"")%2, "Unbalanced quotes in line: %s", line)
+	}
+
+	assert.Nil(t, wf.WriteDotFile("/tmp/dotgraph.dot"))
+	dat, err := ioutil.ReadFile("/tmp/dotgraph.dot")
+	assert.Nil(t, err)
+	assert.Equal(t, dot, string(dat))
+	cleanFiles("/tmp/dotgraph.dot")
+}
+
+func sortedNodeNames(g *WorkflowGraph) []string {
+	names := []string{}
+	for name := range g.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
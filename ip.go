@@ -1,12 +1,21 @@
 package scipipe
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,6 +30,14 @@ type InformationPacket struct {
 	lock      *sync.Mutex
 	auditInfo *AuditInfo
 	SubStream *FilePort
+	tempToken string
+	// noAtomize makes the file be written directly to its final path, instead
+	// of to a temporary path, from which it is atomized
+	noAtomize bool
+	// audits is the store of the audit info of the packet, which is the one
+	// of the workflow for packets created with Workflow.NewInformationPacket.
+	// If not set, defaultAuditStore is used.
+	audits auditStore
 }
 
 // Create new InformationPacket "object"
@@ -29,6 +46,7 @@ func NewInformationPacket(path string) *InformationPacket {
 	ip.path = path
 	ip.lock = new(sync.Mutex)
 	ip.SubStream = NewFilePort()
+	ip.tempToken = strconv.Itoa(os.Getpid()) + "-" + randSeqLC(8)
 	//Don't init buffer if not needed?
 	//buf := make([]byte, 0, 128)
 	//ip.buffer = bytes.NewBuffer(buf)
@@ -40,11 +58,78 @@ func (ip *InformationPacket) GetPath() string {
 	return ip.path
 }
 
+// SetPath changes the (final) path of the InformationPacket to newPath, without
+// touching any files on disk. Keys and audit info are preserved, by first
+// reading them from the audit file of the old path, if not already done. The
+// temporary, FIFO and audit file paths are derived from the new path
+// afterwards. Use Rename to also move the file and its audit file on disk.
+func (ip *InformationPacket) SetPath(newPath string) {
+	ip.GetAuditInfo() // Make sure audit info is loaded from the old audit file
+	ip.lock.Lock()
+	ip.path = newPath
+	ip.lock.Unlock()
+}
+
+// Rename moves the file of the InformationPacket, and its audit file if it
+// exists, to newPath (and newPath + ".audit.json"), and updates the path of
+// the InformationPacket accordingly, preserving keys and audit info. With
+// AuditModeCentral, the audit info is instead added for newPath in the
+// central audit log.
+func (ip *InformationPacket) Rename(newPath string) {
+	oldPath := ip.GetPath()
+	ip.SetPath(newPath)
+	err := os.Rename(oldPath, newPath)
+	Check(err, "Could not rename file: "+oldPath+" -> "+newPath)
+	err = ip.auditStore().rename(oldPath, newPath)
+	Check(err, "Could not rename audit file of: "+oldPath+" -> "+newPath)
+}
+
 // Get the temporary path of the physical file
 func (ip *InformationPacket) GetTempPath() string {
+	if ip.noAtomize {
+		return ip.path
+	}
+	if TempPaths == TempPathUnique {
+		return ip.path + "." + ip.tempToken + ".tmp"
+	}
 	return ip.path + ".tmp"
 }
 
+// Check if any temporary file exists for the final path of the
+// InformationPacket, including ones of other InformationPackets with the same
+// path, when TempPathUnique is used
+func (ip *InformationPacket) AnyTempFileExists() bool {
+	return ip.existingTempPath() != ""
+}
+
+// existingTempPath returns the path of an existing temporary file for the
+// final path of the InformationPacket (see AnyTempFileExists), or an empty
+// string if there is none
+func (ip *InformationPacket) existingTempPath() string {
+	if ip.noAtomize {
+		return ""
+	}
+	if TempPaths != TempPathUnique {
+		if ip.TempFileExists() {
+			return ip.GetTempPath()
+		}
+		return ""
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(ip.path))
+	if err != nil {
+		return ""
+	}
+	// Only match the unique temp paths of this exact path, and not for
+	// example those of paths extending it (see SetPathExtend)
+	tempNameRegex := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(ip.path)) + `\.[0-9]+-[a-z0-9]{8}\.tmp$`)
+	for _, entry := range entries {
+		if tempNameRegex.MatchString(entry.Name()) && !entry.IsDir() {
+			return filepath.Join(filepath.Dir(ip.path), entry.Name())
+		}
+	}
+	return ""
+}
+
 // Get the path to use when a FIFO file is used instead of a normal file
 func (ip *InformationPacket) GetFifoPath() string {
 	return ip.path + ".fifo"
@@ -57,6 +142,33 @@ func (ip *InformationPacket) GetSize() int64 {
 	return fi.Size()
 }
 
+// Get the modification time of an existing file
+func (ip *InformationPacket) GetModTime() time.Time {
+	fi, err := os.Stat(ip.path)
+	CheckErr(err)
+	return fi.ModTime()
+}
+
+// Get the SHA-256 checksum of an existing file, as a hex string
+func (ip *InformationPacket) GetSHA256() string {
+	f := ip.Open()
+	defer f.Close()
+	hash := sha256.New()
+	_, err := io.Copy(hash, f)
+	Check(err, "Could not read file for checksum: "+ip.GetPath())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get the MD5 checksum of an existing file, as a hex string
+func (ip *InformationPacket) GetMD5() string {
+	f := ip.Open()
+	defer f.Close()
+	hash := md5.New()
+	_, err := io.Copy(hash, f)
+	Check(err, "Could not read file for checksum: "+ip.GetPath())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 // Open the file and return a file handle (*os.File)
 func (ip *InformationPacket) Open() *os.File {
 	f, err := os.Open(ip.GetPath())
@@ -85,9 +197,10 @@ func (ip *InformationPacket) Read() []byte {
 	return dat
 }
 
-// Read the whole content of the file and return as a byte array ([]byte)
+// Read the (JSON encoded) audit info of the file and return as a byte array
+// ([]byte), decompressed if stored compressed
 func (ip *InformationPacket) ReadAuditFile() []byte {
-	dat, err := ioutil.ReadFile(ip.GetAuditFilePath())
+	dat, err := ip.auditStore().read(ip.GetPath())
 	Check(err, "Could not open file for reading: "+ip.GetAuditFilePath())
 	return dat
 }
@@ -96,6 +209,9 @@ func (ip *InformationPacket) ReadAuditFile() []byte {
 func (ip *InformationPacket) WriteTempFile(dat []byte) {
 	err := ioutil.WriteFile(ip.GetTempPath(), dat, 0644)
 	Check(err, "Could not write to temp file: "+ip.GetTempPath())
+	if DurableWrites {
+		syncPath(ip.GetTempPath())
+	}
 }
 
 const (
@@ -104,34 +220,53 @@ const (
 
 // Change from the temporary file name to the final file name
 func (ip *InformationPacket) Atomize() {
+	if ip.noAtomize {
+		Debug.Println("InformationPacket: Not atomizing, since written directly to final path:", ip.GetPath())
+		return
+	}
 	Debug.Println("InformationPacket: Atomizing", ip.GetTempPath(), "->", ip.GetPath())
 	doneAtomizing := false
 	for !doneAtomizing {
 		if ip.TempFileExists() {
 			ip.lock.Lock()
+			if DurableWrites {
+				syncPath(ip.GetTempPath())
+			}
 			err := os.Rename(ip.GetTempPath(), ip.path)
 			Check(err, "Could not rename file: "+ip.GetTempPath())
+			if DurableWrites {
+				syncPath(filepath.Dir(ip.path))
+			}
 			ip.lock.Unlock()
 			doneAtomizing = true
 			Debug.Println("InformationPacket: Done atomizing", ip.GetTempPath(), "->", ip.GetPath())
 		} else {
 			Debug.Printf("Sleeping for %d seconds before atomizing ...\n", sleepDurationSec)
-			time.Sleep(time.Duration(sleepDurationSec) * time.Second)
+			clk.Sleep(time.Duration(sleepDurationSec) * time.Second)
 		}
 	}
 }
 
+// syncPath fsyncs the file or directory at path, to make sure that its
+// content, or for a directory, its entries, are durably written to disk
+func syncPath(path string) {
+	f, err := os.Open(path)
+	Check(err, "Could not open for syncing: "+path)
+	defer f.Close()
+	err = f.Sync()
+	Check(err, "Could not sync: "+path)
+}
+
 // Create FIFO file for the InformationPacket
 func (ip *InformationPacket) CreateFifo() {
 	ip.lock.Lock()
-	cmd := "mkfifo " + ip.GetFifoPath()
-	Debug.Println("Now creating FIFO with command:", cmd)
+	Debug.Println("Now creating FIFO:", ip.GetFifoPath())
 
 	if _, err := os.Stat(ip.GetFifoPath()); err == nil {
 		Warning.Println("FIFO already exists, so not creating a new one:", ip.GetFifoPath())
 	} else {
-		_, err := exec.Command("bash", "-c", cmd).Output()
-		Check(err, "Could not execute command: "+cmd)
+		err := syscall.Mkfifo(ip.GetFifoPath(), 0666)
+		Check(err, "Could not create FIFO: "+ip.GetFifoPath())
 	}
 
 	ip.lock.Unlock()
@@ -141,9 +276,9 @@ func (ip *InformationPacket) CreateFifo() {
 func (ip *InformationPacket) RemoveFifo() {
 	// FIXME: Shouldn't we check first whether the fifo exists?
 	ip.lock.Lock()
-	output, err := exec.Command("bash", "-c", "rm "+ip.GetFifoPath()).Output()
+	err := os.Remove(ip.GetFifoPath())
 	Check(err, "Could not delete fifo file: "+ip.GetFifoPath())
-	Debug.Println("Removed FIFO output: ", output)
+	Debug.Println("Removed FIFO:", ip.GetFifoPath())
 	ip.lock.Unlock()
 }
 
@@ -188,6 +323,25 @@ func (ip *InformationPacket) GetParam(key string) string {
 	return val
 }
 
+// AddParam adds the parameter k, with value v, to the packet, to be used by
+// processes with ParamsFromInputs set, for their parameter place-holders
+func (ip *InformationPacket) AddParam(k string, v string) {
+	ai := ip.GetAuditInfo()
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
+	if ai.Params[k] != "" && ai.Params[k] != v {
+		Error.Fatalf("Can not add value %s to existing param %s with different value %s\n", v, k, ai.Params[k])
+	}
+	ai.Params[k] = v
+}
+
+// AddParams adds all the parameters in params to the packet, as with AddParam
+func (ip *InformationPacket) AddParams(params map[string]string) {
+	for k, v := range params {
+		ip.AddParam(k, v)
+	}
+}
+
 func (ip *InformationPacket) GetKey(k string) string {
 	v, ok := ip.GetAuditInfo().Keys[k]
 	if !ok {
@@ -202,6 +356,8 @@ func (ip *InformationPacket) GetKeys() map[string]string {
 
 func (ip *InformationPacket) AddKey(k string, v string) {
 	ai := ip.GetAuditInfo()
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
 	if ai.Keys[k] != "" && ai.Keys[k] != v {
 		Error.Fatalf("Can not add value %s to existing key %s with different value %s\n", v, k, ai.Keys[k])
 	}
@@ -214,21 +370,142 @@ func (ip *InformationPacket) AddKeys(keys map[string]string) {
 	}
 }
 
+// withKeys returns a copy of ip, with its own copy of the audit info, in which
+// the keys in keys are set, overwriting any existing values. It is used for
+// tagging packets on in-ports, since the same packet is sent on all the
+// connections of an out-port, and the tags of an earlier in-port on the path
+// of the packet are copied on to the outputs of its task.
+func (ip *InformationPacket) withKeys(keys map[string]string) *InformationPacket {
+	ai := ip.GetAuditInfo()
+	ip.lock.Lock()
+	newAI := *ai
+	newAI.Params = mergeParamMaps(ai.Params, nil)
+	newAI.Keys = mergeParamMaps(ai.Keys, keys)
+	newIP := *ip
+	ip.lock.Unlock()
+	newIP.lock = new(sync.Mutex)
+	newIP.auditInfo = &newAI
+	return &newIP
+}
+
 func (ip *InformationPacket) UnMarshalJson(v interface{}) {
 	d := ip.Read()
 	err := json.Unmarshal(d, v)
 	Check(err, "Could not unmarshal content of file: "+ip.GetPath())
 }
 
+// Marshal v to JSON and write it to the file (first to its temp path, and then
+// atomize)
+func (ip *InformationPacket) WriteJSON(v interface{}) {
+	dat, err := json.Marshal(v)
+	Check(err, "Could not marshal value to JSON, for file: "+ip.GetPath())
+	ip.WriteTempFile(dat)
+	ip.Atomize()
+}
+
+// Write all values received on the channel ch (of any element type) to the
+// file as newline-delimited JSON, one value per line, until ch is closed. The
+// values are written to the temp path of the file, which is atomized when done.
+func (ip *InformationPacket) WriteJSONLines(ch interface{}) {
+	if reflect.ValueOf(ch).Kind() != reflect.Chan {
+		Error.Fatalf("WriteJSONLines needs a channel, but got: %T\n", ch)
+	}
+	ip.WriteRecords(JSONLinesCodec{}, ch)
+}
+
+// Read the newline-delimited JSON content of the file, one value per line,
+// into the slice pointed to by v. The file is decoded in a streaming fashion,
+// without first reading it all into memory.
+func (ip *InformationPacket) ReadJSONLines(v interface{}) {
+	ptrVal := reflect.ValueOf(v)
+	if ptrVal.Kind() != reflect.Ptr || ptrVal.Elem().Kind() != reflect.Slice {
+		Error.Fatalf("ReadJSONLines needs a pointer to a slice, but got: %T\n", v)
+	}
+	ip.ReadRecords(JSONLinesCodec{}, v)
+}
+
+// WriteRecords writes the records in records, which can be either a slice, or
+// a channel (which is then read until closed), to the file, encoded with
+// codec. The records are written to the temp path of the file, which is
+// atomized when done.
+func (ip *InformationPacket) WriteRecords(codec Codec, records interface{}) {
+	recsVal := reflect.ValueOf(records)
+	if recsVal.Kind() != reflect.Chan && recsVal.Kind() != reflect.Slice {
+		Error.Fatalf("WriteRecords needs a slice or a channel, but got: %T\n", records)
+	}
+	f := ip.OpenWriteTemp()
+	w := bufio.NewWriter(f)
+	enc := codec.NewEncoder(w)
+	encode := func(v reflect.Value) {
+		err := enc.Encode(v.Interface())
+		Check(err, "Could not write record to temp file: "+ip.GetTempPath())
+	}
+	if recsVal.Kind() == reflect.Chan {
+		for {
+			v, ok := recsVal.Recv()
+			if !ok {
+				break
+			}
+			encode(v)
+		}
+	} else {
+		for i := 0; i < recsVal.Len(); i++ {
+			encode(recsVal.Index(i))
+		}
+	}
+	err := w.Flush()
+	Check(err, "Could not write to temp file: "+ip.GetTempPath())
+	err = f.Close()
+	Check(err, "Could not close temp file: "+ip.GetTempPath())
+	ip.Atomize()
+}
+
+// ReadRecords reads the records in the file, decoded with codec, into records,
+// which can be either a pointer to a slice, to which the records are
+// appended, or a channel, on which the records are sent, and which is closed
+// when all records are read. The file is decoded in a streaming fashion,
+// without first reading it all into memory.
+func (ip *InformationPacket) ReadRecords(codec Codec, records interface{}) {
+	recsVal := reflect.ValueOf(records)
+	var elemType reflect.Type
+	switch {
+	case recsVal.Kind() == reflect.Chan:
+		elemType = recsVal.Type().Elem()
+		defer recsVal.Close()
+	case recsVal.Kind() == reflect.Ptr && recsVal.Elem().Kind() == reflect.Slice:
+		elemType = recsVal.Elem().Type().Elem()
+	default:
+		Error.Fatalf("ReadRecords needs a pointer to a slice, or a channel, but got: %T\n", records)
+	}
+	f := ip.Open()
+	defer f.Close()
+	dec := codec.NewDecoder(bufio.NewReader(f))
+	for {
+		elem := reflect.New(elemType)
+		err := dec.Decode(elem.Interface())
+		if err == io.EOF {
+			break
+		}
+		Check(err, "Could not decode record in file: "+ip.GetPath())
+		if recsVal.Kind() == reflect.Chan {
+			recsVal.Send(elem.Elem())
+		} else {
+			recsVal.Elem().Set(reflect.Append(recsVal.Elem(), elem.Elem()))
+		}
+	}
+}
+
 func (ip *InformationPacket) GetAuditInfo() *AuditInfo {
 	defer ip.lock.Unlock()
 	ip.lock.Lock()
 	if ip.auditInfo == nil {
 		ip.auditInfo = NewAuditInfo()
-		auditFileData, err := ioutil.ReadFile(ip.GetAuditFilePath())
+		auditFileData, err := ip.auditStore().read(ip.path)
 		if err == nil {
 			unmarshalErr := json.Unmarshal(auditFileData, ip.auditInfo)
-			Check(unmarshalErr, "Could not unmarshal audit log file content: "+ip.GetAuditFilePath())
+			Check(unmarshalErr, "Could not unmarshal audit log file content: "+ip.auditStore().filePath(ip.path))
+		} else if !os.IsNotExist(err) {
+			Warning.Printf("Could not read audit info of %s: %s\n", ip.path, err)
 		}
 	}
 	return ip.auditInfo
@@ -240,15 +517,26 @@ func (ip *InformationPacket) SetAuditInfo(ai *AuditInfo) {
 	ip.lock.Unlock()
 }
 
+// auditStore returns the store of the audit info of the packet
+func (ip *InformationPacket) auditStore() auditStore {
+	if ip.audits != nil {
+		return ip.audits
+	}
+	return defaultAuditStore
+}
+
+// GetAuditFilePath returns the path of the file containing the audit info of
+// the file, which depends on the audit mode of the workflow (see AuditMode).
+// With AuditModeCentral, this is the central audit log of the workflow.
 func (ip *InformationPacket) GetAuditFilePath() string {
-	return ip.GetPath() + ".audit.json"
+	return ip.auditStore().filePath(ip.GetPath())
 }
 
 func (ip *InformationPacket) WriteAuditLogToFile() {
 	auditInfo := ip.GetAuditInfo()
 	auditInfoJson, jsonErr := json.MarshalIndent(auditInfo, "", "    ")
 	Check(jsonErr, "Could not marshall JSON")
-	writeErr := ioutil.WriteFile(ip.GetAuditFilePath(), auditInfoJson, 0644)
+	writeErr := ip.auditStore().write(ip.GetPath(), auditInfoJson)
 	Check(writeErr, "Could not write audit file: "+ip.GetPath())
 }
 
@@ -256,9 +544,12 @@ func (ip *InformationPacket) WriteAuditLogToFile() {
 
 // IPGen is initialized by a set of strings with file paths, and from that will
 // return instantiated (generated) InformationPacket on its Out-port, when run.
+// To send the file paths matching a glob pattern instead, see
+// components.FileGlobber.
 type IPGen struct {
 	Process
 	name      string
+	workflow  *Workflow
 	Out       *FilePort
 	FilePaths []string
 }
@@ -267,6 +558,7 @@ type IPGen struct {
 func NewIPGen(workflow *Workflow, name string, filePaths ...string) (fq *IPGen) {
 	fq = &IPGen{
 		name:      name,
+		workflow:  workflow,
 		Out:       NewFilePort(),
 		FilePaths: filePaths,
 	}
@@ -278,7 +570,7 @@ func NewIPGen(workflow *Workflow, name string, filePaths ...string) (fq *IPGen)
 func (ipg *IPGen) Run() {
 	defer ipg.Out.Close()
 	for _, fp := range ipg.FilePaths {
-		ipg.Out.Send(NewInformationPacket(fp))
+		ipg.Out.Send(ipg.workflow.NewInformationPacket(fp))
 	}
 }
 
@@ -1,8 +1,10 @@
 package scipipe
 
 import (
-	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -16,6 +18,134 @@ func TestInformationPacketPaths(t *testing.T) {
 	assertPathsEqual(t, ip.GetFifoPath(), TESTPATH+".fifo")
 }
 
+type testRecord struct {
+	Name  string
+	Count int
+}
+
+func TestWriteJSON(t *testing.T) {
+	initTestLogs()
+
+	ip := NewInformationPacket("/tmp/writejson.json")
+	ip.WriteJSON(&testRecord{Name: "foo", Count: 3})
+
+	rec := &testRecord{}
+	ip.UnMarshalJson(rec)
+	assert.Equal(t, testRecord{Name: "foo", Count: 3}, *rec, "Struct did not round-trip via JSON")
+	cleanFiles(ip.GetPath())
+}
+
+func TestWriteReadJSONLines(t *testing.T) {
+	initTestLogs()
+
+	ip := NewInformationPacket("/tmp/writejsonlines.jsonl")
+	records := []testRecord{{Name: "foo", Count: 1}, {Name: "bar", Count: 2}, {Name: "baz", Count: 3}}
+	ch := make(chan testRecord)
+	go func() {
+		defer close(ch)
+		for _, rec := range records {
+			ch <- rec
+		}
+	}()
+	ip.WriteJSONLines(ch)
+
+	assert.Equal(t, "{\"Name\":\"foo\",\"Count\":1}\n{\"Name\":\"bar\",\"Count\":2}\n{\"Name\":\"baz\",\"Count\":3}\n", string(ip.Read()), "Wrong content in JSON lines file")
+
+	readRecords := []testRecord{}
+	ip.ReadJSONLines(&readRecords)
+	assert.Equal(t, records, readRecords, "Structs did not round-trip via JSON lines")
+	cleanFiles(ip.GetPath())
+}
+
+func TestDurableWrites(t *testing.T) {
+	initTestLogs()
+
+	DurableWrites = true
+	defer func() { DurableWrites = false }()
+
+	ip := NewInformationPacket("/tmp/durablewrites.txt")
+	ip.WriteTempFile([]byte("durable\n"))
+	ip.Atomize()
+
+	assert.Equal(t, "durable\n", string(ip.Read()), "Wrong content in durably written file")
+	assert.False(t, ip.TempFileExists(), "Temp file should not exist after atomizing")
+	cleanFiles(ip.GetPath())
+}
+
+func TestSetPath(t *testing.T) {
+	ip := NewInformationPacket("/tmp/setpath_old.txt")
+	ip.AddKey("sample", "s1")
+	ip.SetPath("/tmp/setpath_new.txt")
+
+	assertPathsEqual(t, ip.GetPath(), "/tmp/setpath_new.txt")
+	assertPathsEqual(t, ip.GetTempPath(), "/tmp/setpath_new.txt.tmp")
+	assertPathsEqual(t, ip.GetAuditFilePath(), "/tmp/setpath_new.txt.audit.json")
+	assert.Equal(t, "s1", ip.GetKey("sample"), "Key not preserved when setting path")
+}
+
+func TestRename(t *testing.T) {
+	initTestLogs()
+
+	ip := NewInformationPacket("/tmp/rename_old.txt")
+	ip.WriteTempFile([]byte("hej\n"))
+	ip.Atomize()
+	ip.AddKey("sample", "s1")
+	ip.WriteAuditLogToFile()
+
+	ip.Rename("/tmp/rename_new.txt")
+
+	assert.Equal(t, "hej\n", string(ip.Read()), "Wrong content in renamed file")
+	assert.False(t, NewInformationPacket("/tmp/rename_old.txt").Exists(), "Old file should not exist after renaming")
+	assert.Equal(t, "s1", NewInformationPacket("/tmp/rename_new.txt").GetKey("sample"), "Key not preserved in renamed audit file")
+	cleanFiles("/tmp/rename_old.txt", "/tmp/rename_new.txt")
+}
+
+func TestTempPathUnique(t *testing.T) {
+	initTestLogs()
+
+	TempPaths = TempPathUnique
+	defer func() { TempPaths = TempPathSuffix }()
+
+	ip1 := NewInformationPacket("/tmp/temppathunique.txt")
+	ip2 := NewInformationPacket("/tmp/temppathunique.txt")
+	assert.NotEqual(t, ip1.GetTempPath(), ip2.GetTempPath(), "Temp paths should be unique")
+	assert.Regexp(t, `^/tmp/temppathunique\.txt\.[0-9]+-[a-z0-9]+\.tmp$`, ip1.GetTempPath())
+
+	assert.False(t, ip2.AnyTempFileExists())
+	extended := NewInformationPacket("/tmp/temppathunique.txt.bar.txt")
+	extended.WriteTempFile([]byte("extended\n"))
+	assert.False(t, ip2.AnyTempFileExists(), "Temp file of packet with extended path should not be detected")
+	extended.Atomize()
+	ip1.WriteTempFile([]byte("hej\n"))
+	assert.True(t, ip2.AnyTempFileExists(), "Temp file of other packet with same path should be detected")
+	assert.Equal(t, ip1.GetTempPath(), ip2.existingTempPath(), "Path of the existing temp file should be returned")
+
+	ip1.Atomize()
+	assert.Equal(t, "hej\n", string(ip1.Read()), "Wrong content after atomizing")
+	assert.False(t, ip2.AnyTempFileExists())
+	cleanFiles(ip1.GetPath(), extended.GetPath())
+}
+
 func assertPathsEqual(t *testing.T, path1 string, path2 string) {
 	assert.Equal(t, path1, path2, "Wrong path returned! (Was", path1, "but should be", path2, ")")
 }
+
+func TestCreateRemoveFifo(t *testing.T) {
+	initTestLogs()
+	// With an empty PATH, no shell or mkfifo/rm programs can be found, so the
+	// FIFO has to be created and removed without them
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", path)
+
+	ip := NewInformationPacket("/tmp/fifo_test.txt")
+	ip.CreateFifo()
+	fi, err := os.Stat(ip.GetFifoPath())
+	assert.Nil(t, err, "FIFO not created")
+	if err == nil {
+		assert.True(t, fi.Mode()&os.ModeNamedPipe != 0, "Created file is not a FIFO")
+	}
+	ip.RemoveFifo()
+	_, err = os.Stat(ip.GetFifoPath())
+	assert.True(t, os.IsNotExist(err), "FIFO not removed")
+}
@@ -1,10 +1,13 @@
 package scipipe
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	str "strings"
+	"sync"
 )
 
 var (
@@ -114,3 +117,84 @@ func InitLogError() {
 		os.Stderr,
 	)
 }
+
+// ----------------------------------------------------------------------------
+// Log throttling
+// ----------------------------------------------------------------------------
+
+// logThrottle limits the number of repetitive per-task log messages, when set
+// with SetLogThrottle
+type logThrottle struct {
+	first  int
+	every  int
+	counts map[string]int
+	mx     sync.Mutex
+}
+
+var (
+	taskLogThrottle   *logThrottle
+	taskLogThrottleMx sync.Mutex
+)
+
+// SetLogThrottle throttles the repetitive DEBUG and INFO messages logged for
+// each task, so that each kind of message is only logged in full for the
+// first tasks, after which only every every-th message is logged, together
+// with the number of similar messages suppressed since the last one. With
+// every set to zero or less, all messages after the first ones are suppressed.
+// Messages are of the same kind if they are logged with the same level and
+// format string, such as the "Output file already exists" messages of any
+// process. AUDIT, WARNING and ERROR messages are never throttled.
+func SetLogThrottle(first int, every int) {
+	taskLogThrottleMx.Lock()
+	defer taskLogThrottleMx.Unlock()
+	taskLogThrottle = &logThrottle{
+		first:  first,
+		every:  every,
+		counts: map[string]int{},
+	}
+}
+
+// DisableLogThrottle turns off the throttling of log messages set with
+// SetLogThrottle, so that all messages are logged again
+func DisableLogThrottle() {
+	taskLogThrottleMx.Lock()
+	defer taskLogThrottleMx.Unlock()
+	taskLogThrottle = nil
+}
+
+// allow counts a message of the kind key, and returns whether it should be
+// logged, and how many messages of the kind were suppressed before it
+func (lt *logThrottle) allow(key string) (bool, int) {
+	lt.mx.Lock()
+	defer lt.mx.Unlock()
+	lt.counts[key]++
+	n := lt.counts[key] - lt.first
+	if n <= 0 {
+		return true, 0
+	}
+	if lt.every <= 0 || n%lt.every != 0 {
+		return false, 0
+	}
+	return true, lt.every - 1
+}
+
+// taskLogf logs a per-task message with logger, like logger.Printf, unless it
+// is suppressed by the throttling set with SetLogThrottle
+func taskLogf(logger *log.Logger, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	taskLogThrottleMx.Lock()
+	lt := taskLogThrottle
+	taskLogThrottleMx.Unlock()
+	if lt != nil {
+		ok, suppressed := lt.allow(logger.Prefix() + format)
+		if !ok {
+			return
+		}
+		if suppressed > 0 {
+			msg = fmt.Sprintf("%s (%d similar messages suppressed)", str.TrimSuffix(msg, "\n"), suppressed)
+		}
+	}
+	// Call depth 2 makes the file and line shown by the logger be the ones of
+	// the caller of taskLogf
+	logger.Output(2, msg)
+}
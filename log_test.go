@@ -0,0 +1,49 @@
+package scipipe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogThrottle(t *testing.T) {
+	buf := &bytes.Buffer{}
+	InitLog(ioutil.Discard, ioutil.Discard, buf, ioutil.Discard, os.Stdout, os.Stderr)
+	defer initTestLogs()
+
+	SetLogThrottle(2, 3)
+	defer DisableLogThrottle()
+	for i := 1; i <= 10; i++ {
+		taskLogf(Info, "Task:%-12s Output file already exists, so skipping: %s\n", "task", "file.txt")
+		taskLogf(Info, "Task:%-12s Another kind of message\n", "task")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	existsLines := []string{}
+	for _, line := range lines {
+		if strings.Contains(line, "already exists") {
+			existsLines = append(existsLines, line)
+		}
+	}
+	assert.Equal(t, 8, len(lines), "Messages should be throttled after the first ones, per kind of message")
+	assert.Equal(t, 4, len(existsLines), "Only the first 2, and then every 3rd message, should be logged")
+	assert.False(t, strings.Contains(existsLines[1], "suppressed"), "Messages before the threshold should be logged in full")
+	assert.True(t, strings.HasSuffix(existsLines[2], "file.txt (2 similar messages suppressed)"), "Summary of suppressed messages missing in: %s", existsLines[2])
+
+	buf.Reset()
+	SetLogThrottle(1, 0)
+	for i := 1; i <= 5; i++ {
+		taskLogf(Info, "Task:%-12s Output file already exists, so skipping: %s\n", "task", "file.txt")
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "already exists"), "All messages after the first ones should be suppressed, when every is 0")
+
+	buf.Reset()
+	DisableLogThrottle()
+	for i := 1; i <= 5; i++ {
+		taskLogf(Info, "Task:%-12s Output file already exists, so skipping: %s\n", "task", "file.txt")
+	}
+	assert.Equal(t, 5, strings.Count(buf.String(), "already exists"), "No messages should be suppressed, when throttling is disabled")
+}
@@ -0,0 +1,102 @@
+package scipipe
+
+import (
+	"fmt"
+)
+
+// ================== ParamSet ==================
+
+// ParamSet is a list of combinations of parameter values, keyed by parameter
+// name, such as for a parameter sweep. It is created with Params, and combined
+// with other parameter sets using Cross or Zip, after which it can be
+// connected to all the parameter ports of a process at once, with
+// SciProcess.ConnectParams.
+type ParamSet struct {
+	names        []string
+	combinations []map[string]string
+}
+
+// Params returns a ParamSet for the parameter name, with one combination for
+// each of values. The values are converted to strings with fmt.Sprint, so
+// that, for example, numbers can be used directly.
+func Params(name string, values ...interface{}) *ParamSet {
+	ps := &ParamSet{names: []string{name}}
+	for _, v := range values {
+		ps.combinations = append(ps.combinations, map[string]string{name: fmt.Sprint(v)})
+	}
+	return ps
+}
+
+// Cross returns the cross product of the parameter sets ps and other, that is,
+// every combination of ps combined with every combination of other
+func (ps *ParamSet) Cross(other *ParamSet) *ParamSet {
+	result := &ParamSet{names: ps.combinedNames(other)}
+	for _, c1 := range ps.combinations {
+		for _, c2 := range other.combinations {
+			result.combinations = append(result.combinations, mergeParamMaps(c1, c2))
+		}
+	}
+	return result
+}
+
+// Zip returns the parameter sets ps and other combined pair-wise, that is, the
+// first combination of ps with the first one of other, and so on. Both sets
+// need to have the same number of combinations.
+func (ps *ParamSet) Zip(other *ParamSet) *ParamSet {
+	if len(ps.combinations) != len(other.combinations) {
+		Error.Fatalf("Can not zip parameter sets of different lengths (%d and %d), for params %v and %v\n", len(ps.combinations), len(other.combinations), ps.names, other.names)
+	}
+	result := &ParamSet{names: ps.combinedNames(other)}
+	for i := range ps.combinations {
+		result.combinations = append(result.combinations, mergeParamMaps(ps.combinations[i], other.combinations[i]))
+	}
+	return result
+}
+
+// Names returns the names of the parameters in the set
+func (ps *ParamSet) Names() []string {
+	return append([]string{}, ps.names...)
+}
+
+// Combinations returns all combinations of parameter values in the set, in
+// order
+func (ps *ParamSet) Combinations() []map[string]string {
+	return ps.combinations
+}
+
+func (ps *ParamSet) combinedNames(other *ParamSet) []string {
+	names := append([]string{}, ps.names...)
+	for _, name := range other.names {
+		for _, existing := range ps.names {
+			if name == existing {
+				Error.Fatalf("Can not combine parameter sets both containing the param: %s\n", name)
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func mergeParamMaps(m1 map[string]string, m2 map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range m1 {
+		merged[k] = v
+	}
+	for k, v := range m2 {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ConnectParams connects all the parameters in the parameter set ps to the
+// parameter ports with the same names, so that one task is created for each
+// combination in the set
+func (p *SciProcess) ConnectParams(ps *ParamSet) {
+	for _, name := range ps.names {
+		values := []string{}
+		for _, c := range ps.combinations {
+			values = append(values, c[name])
+		}
+		p.ParamPort(name).ConnectStr(values...)
+	}
+}
@@ -0,0 +1,45 @@
+package scipipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamsCross(t *testing.T) {
+	ps := Params("threads", 1, 2).Cross(Params("mode", "a", "b"))
+	assert.Equal(t, []string{"threads", "mode"}, ps.Names())
+	assert.Equal(t, []map[string]string{
+		{"threads": "1", "mode": "a"},
+		{"threads": "1", "mode": "b"},
+		{"threads": "2", "mode": "a"},
+		{"threads": "2", "mode": "b"},
+	}, ps.Combinations(), "Wrong cross product of params")
+}
+
+func TestParamsZip(t *testing.T) {
+	ps := Params("sample", "s1", "s2").Zip(Params("ref", "hg19", "hg38"))
+	assert.Equal(t, []map[string]string{
+		{"sample": "s1", "ref": "hg19"},
+		{"sample": "s2", "ref": "hg38"},
+	}, ps.Combinations(), "Wrong zipped params")
+}
+
+func TestConnectParams(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestConnectParamsWf", 4)
+	echo := wf.NewProc("echo", "echo {p:threads} {p:mode} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string {
+		return "/tmp/connectparams_" + t.Param("threads") + "_" + t.Param("mode") + ".txt"
+	})
+	echo.ConnectParams(Params("threads", 1, 2).Cross(Params("mode", "a", "b")))
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	for _, f := range []string{"1_a", "1_b", "2_a", "2_b"} {
+		ip := NewInformationPacket("/tmp/connectparams_" + f + ".txt")
+		assert.True(t, ip.Exists(), "File missing: "+ip.GetPath())
+		cleanFiles(ip.GetPath())
+	}
+}
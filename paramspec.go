@@ -0,0 +1,108 @@
+package scipipe
+
+import (
+	"fmt"
+	"math"
+	re "regexp"
+	"strconv"
+)
+
+// ================== ParamConstraint ==================
+
+// ParamConstraint is a constraint on the values of a parameter, that can be
+// declared for the parameter ports of a SciProcess, via its ParamSpec field.
+// Incoming parameter values are then validated before any tasks are created
+// from them.
+type ParamConstraint interface {
+	// Validate returns an error describing the violation, if value does not
+	// satisfy the constraint, or otherwise nil
+	Validate(value string) error
+}
+
+// IntConstraint requires values to be integers between Min and Max, inclusive
+type IntConstraint struct {
+	Min int64
+	Max int64
+}
+
+// NewIntConstraint returns a constraint requiring integer values between min
+// and max, inclusive. Use math.MinInt64 or math.MaxInt64 for no lower or upper
+// bound.
+func NewIntConstraint(min int64, max int64) *IntConstraint {
+	return &IntConstraint{Min: min, Max: max}
+}
+
+func (c *IntConstraint) Validate(value string) error {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("'%s' is not an integer", value)
+	}
+	if i < c.Min || i > c.Max {
+		return fmt.Errorf("%d is not in the range [%d, %d]", i, c.Min, c.Max)
+	}
+	return nil
+}
+
+// FloatConstraint requires values to be numbers between Min and Max,
+// inclusive. NaN is never valid.
+type FloatConstraint struct {
+	Min float64
+	Max float64
+}
+
+// NewFloatConstraint returns a constraint requiring numeric values between min
+// and max, inclusive. Use math.Inf(-1) or math.Inf(1) for no lower or upper
+// bound.
+func NewFloatConstraint(min float64, max float64) *FloatConstraint {
+	return &FloatConstraint{Min: min, Max: max}
+}
+
+func (c *FloatConstraint) Validate(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		return fmt.Errorf("'%s' is not a number", value)
+	}
+	if f < c.Min || f > c.Max {
+		return fmt.Errorf("%g is not in the range [%g, %g]", f, c.Min, c.Max)
+	}
+	return nil
+}
+
+// EnumConstraint requires values to be one of Values
+type EnumConstraint struct {
+	Values []string
+}
+
+// NewEnumConstraint returns a constraint requiring values to be one of values
+func NewEnumConstraint(values ...string) *EnumConstraint {
+	return &EnumConstraint{Values: values}
+}
+
+func (c *EnumConstraint) Validate(value string) error {
+	for _, v := range c.Values {
+		if value == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not one of %v", value, c.Values)
+}
+
+// RegexConstraint requires values to fully match a regular expression
+type RegexConstraint struct {
+	Regex *re.Regexp
+}
+
+// NewRegexConstraint returns a constraint requiring values to fully match the
+// regular expression pattern
+func NewRegexConstraint(pattern string) *RegexConstraint {
+	regex, err := re.Compile("^(?:" + pattern + ")$")
+	Check(err, "Could not compile regex for param constraint: "+pattern)
+	return &RegexConstraint{Regex: regex}
+}
+
+func (c *RegexConstraint) Validate(value string) error {
+	if !c.Regex.MatchString(value) {
+		return fmt.Errorf("'%s' does not match the pattern %s", value, c.Regex)
+	}
+	return nil
+}
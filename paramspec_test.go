@@ -0,0 +1,64 @@
+package scipipe
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamConstraints(t *testing.T) {
+	threads := NewIntConstraint(1, math.MaxInt64)
+	assert.Nil(t, threads.Validate("8"))
+	assert.NotNil(t, threads.Validate("0"), "Out of range value should be invalid")
+	assert.NotNil(t, threads.Validate("eight"), "Non-integer should be invalid")
+
+	fraction := NewFloatConstraint(0, 1)
+	assert.Nil(t, fraction.Validate("0.5"))
+	assert.NotNil(t, fraction.Validate("1.5"), "Out of range value should be invalid")
+	assert.NotNil(t, fraction.Validate("NaN"), "NaN should be invalid")
+
+	mode := NewEnumConstraint("fast", "accurate")
+	assert.Nil(t, mode.Validate("fast"))
+	assert.NotNil(t, mode.Validate("fsat"), "Value not in enum should be invalid")
+
+	sample := NewRegexConstraint("[A-Z]+[0-9]+")
+	assert.Nil(t, sample.Validate("ABC123"))
+	assert.NotNil(t, sample.Validate("ABC123x"), "Value only partially matching should be invalid")
+}
+
+func TestValidateParam(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestValidateParamWf", 4)
+	p := wf.NewProc("align", "aligner --threads {p:threads} {p:mode} > {o:out}")
+	p.ParamSpec = map[string]ParamConstraint{
+		"threads": NewIntConstraint(1, 64),
+	}
+
+	assert.Nil(t, p.validateParam("threads", "4"))
+	assert.NotNil(t, p.validateParam("threads", "128"), "Out of range value should be invalid")
+	assert.Nil(t, p.validateParam("mode", "anything"), "Param without constraint should be valid")
+}
+
+func TestValidate_ParamSpecForMissingPort(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestValidateParamSpecWf", 4)
+	p := wf.NewProc("align", "aligner --threads {p:threads} > {o:out}")
+	p.SetPathStatic("out", "/tmp/paramspec_out.txt")
+	p.ParamPort("threads").ConnectStr("4")
+	wf.ConnectLast(p.Out("out"))
+	p.ParamSpec = map[string]ParamConstraint{
+		"thread": NewIntConstraint(1, 64),
+	}
+
+	err := wf.Validate()
+	assert.NotNil(t, err, "ParamSpec for a missing param port should not validate")
+	assert.Contains(t, err.Error(), "thread", "Error should name the missing param port")
+
+	p.ParamSpec = map[string]ParamConstraint{
+		"threads": NewIntConstraint(1, 64),
+	}
+	assert.Nil(t, wf.Validate())
+}
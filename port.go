@@ -1,31 +1,63 @@
 package scipipe
 
 import (
+	"fmt"
 	"os"
+	"reflect"
+	"strconv"
+	"sync"
 )
 
+// Port is the interface implemented by all kinds of ports (FilePort and
+// ParamPort), allowing generic wiring code. Ports can only be connected to
+// ports of the same kind, and Connect returns an error otherwise.
 type Port interface {
-	Connect(Port)
+	Connect(Port) error
 	IsConnected() bool
 	SetConnectedStatus(bool)
 }
 
-func Connect(port1 *FilePort, port2 *FilePort) {
-	port1.Connect(port2)
+var (
+	_ Port = (*FilePort)(nil)
+	_ Port = (*ParamPort)(nil)
+)
+
+// Connect connects the two ports port1 and port2, which have to be of the
+// same kind
+func Connect(port1 Port, port2 Port) error {
+	return port1.Connect(port2)
 }
 
 // FilePort
 type FilePort struct {
-	Port
 	InChan    chan *InformationPacket
 	inChans   []chan *InformationPacket
 	outChans  []chan *InformationPacket
 	connected bool
+	mergeOnce sync.Once
+	startOnce sync.Once
+	warnOnce  sync.Once
+	// TagSource makes the merging of inputs tag each packet with the key
+	// "merge.source", containing the index (in order of connection) of the
+	// connected out-port it came from
+	TagSource bool
+	// TagSeq makes the merging of inputs tag each packet with the key
+	// "merge.seq", containing a sequence number, increasing by one for each
+	// packet received on the port.
+	//
+	// Tagging is done on a copy of the packet, so that other receivers of the
+	// same packet are not affected, and replaces any values of the keys set
+	// by tagging in-ports further up the path of the packet.
+	TagSeq bool
+	codec  Codec
+	// remotePorts are the ports this port was connected to, with Connect
+	// called on this port, for Workflow.Graph
+	remotePorts []*FilePort
 }
 
 func NewFilePort() *FilePort {
 	fp := &FilePort{
-		InChan:    make(chan *InformationPacket, BUFSIZE), // This one will contain merged inputs from inChans
+		InChan:    make(chan *InformationPacket, FilePortBufferSize), // This one will contain merged inputs from inChans
 		inChans:   []chan *InformationPacket{},
 		outChans:  []chan *InformationPacket{},
 		connected: false,
@@ -33,35 +65,94 @@ func NewFilePort() *FilePort {
 	return fp
 }
 
-func (localPort *FilePort) Connect(remotePort *FilePort) {
+// Connect connects the port to the other port, which has to be a FilePort,
+// or else an error is returned
+func (localPort *FilePort) Connect(other Port) error {
+	remotePort, ok := other.(*FilePort)
+	if !ok {
+		return fmt.Errorf("Can not connect FilePort to port of type %T", other)
+	}
+	localPort.connectCodecs(remotePort)
+
 	// If localPort is an in-port
-	inBoundChan := make(chan *InformationPacket, BUFSIZE)
+	inBoundChan := make(chan *InformationPacket, FilePortBufferSize)
 	localPort.AddInChan(inBoundChan)
 	remotePort.AddOutChan(inBoundChan)
 
 	// If localPort is an out-port
-	outBoundChan := make(chan *InformationPacket, BUFSIZE)
+	outBoundChan := make(chan *InformationPacket, FilePortBufferSize)
 	localPort.AddOutChan(outBoundChan)
 	remotePort.AddInChan(outBoundChan)
 
+	localPort.remotePorts = append(localPort.remotePorts, remotePort)
 	localPort.SetConnectedStatus(true)
 	remotePort.SetConnectedStatus(true)
+	return nil
 }
 
-// RunMerge merges (multiple) inputs on pt.inChans into pt.InChan. This has to
-// start running when the owning process runs, in order to merge in-ports
+// connectCodecs makes sure the ports use the same codec, if any of them has
+// one set
+func (localPort *FilePort) connectCodecs(remotePort *FilePort) {
+	switch {
+	case localPort.codec == nil:
+		localPort.codec = remotePort.codec
+	case remotePort.codec == nil:
+		remotePort.codec = localPort.codec
+	case !sameCodec(localPort.codec, remotePort.codec):
+		Error.Fatalf("Can not connect ports with different codecs (%T and %T)\n", localPort.codec, remotePort.codec)
+	}
+}
+
+// RunMergeInputs merges (multiple) inputs on pt.inChans into pt.InChan, and
+// closes pt.InChan when all of the inChans are closed. SciProcess starts it
+// automatically for its in-ports when it runs, as does Recv, the first time it
+// is called. It is safe to call it more than once, in which case only the
+// first call does the merging, while the others return immediately.
 func (pt *FilePort) RunMergeInputs() {
+	pt.mergeOnce.Do(pt.mergeInputs)
+}
+
+// startMergeInputs starts merging inputs in a separate go-routine, unless
+// it is already started
+func (pt *FilePort) startMergeInputs() {
+	pt.startOnce.Do(func() {
+		go pt.RunMergeInputs()
+	})
+}
+
+func (pt *FilePort) mergeInputs() {
 	defer close(pt.InChan)
-	for len(pt.inChans) > 0 {
-		for i, ich := range pt.inChans {
-			ip, ok := <-ich
-			if !ok {
-				// Delete in-channel at position i
-				pt.inChans = append(pt.inChans[:i], pt.inChans[i+1:]...)
-				break
-			}
-			pt.InChan <- ip
+	// All in-channels are selected on at once, so that packets are forwarded
+	// as soon as any of them has one, instead of waiting for each in turn.
+	// Closed channels are removed from the cases, so the original index of
+	// each channel is kept track of, for tagging the source.
+	cases := []reflect.SelectCase{}
+	sources := []int{}
+	for i, ich := range pt.inChans {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ich)})
+		sources = append(sources, i)
+	}
+	var seq int64
+	for len(cases) > 0 {
+		i, val, ok := reflect.Select(cases)
+		if !ok {
+			cases = append(cases[:i], cases[i+1:]...)
+			sources = append(sources[:i], sources[i+1:]...)
+			continue
+		}
+		ip := val.Interface().(*InformationPacket)
+		tags := map[string]string{}
+		if pt.TagSource {
+			tags["merge.source"] = strconv.Itoa(sources[i])
+		}
+		if pt.TagSeq {
+			tags["merge.seq"] = strconv.FormatInt(seq, 10)
 		}
+		if len(tags) > 0 {
+			ip = ip.withKeys(tags)
+		}
+		seq++
+		pt.InChan <- ip
 	}
 }
 
@@ -81,14 +172,83 @@ func (pt *FilePort) IsConnected() bool {
 	return pt.connected
 }
 
+// Codec returns the codec set for the port, with SetPortCodec, or the default
+// JSONLinesCodec if none is set
+func (pt *FilePort) Codec() Codec {
+	if pt.codec == nil {
+		return JSONLinesCodec{}
+	}
+	return pt.codec
+}
+
+// Send sends ip on all the connections of the port. If the port has no
+// connections, the packet is lost, which is reported with a warning (once per
+// port), or as an error, with FailOnUnconnectedSend set.
 func (pt *FilePort) Send(ip *InformationPacket) {
+	if len(pt.outChans) == 0 {
+		if FailOnUnconnectedSend {
+			Error.Printf("Sent packet on port without connections, so it is lost: %s\n", ip.GetPath())
+			osExit(1)
+		}
+		pt.warnOnce.Do(func() {
+			Warning.Printf("Sent packet on port without connections, so it (and any further packets on the port) is lost. Did you forget to connect an out-port? Packet: %s\n", ip.GetPath())
+		})
+	}
 	for i, outChan := range pt.outChans {
 		Debug.Printf("Sending on outchan %d in port\n", i)
 		outChan <- ip
 	}
 }
 
+// Subscribe returns a channel on which all the information packets sent on
+// the (out-)port can be received, for consuming the outputs of a workflow
+// directly in Go, without writing a process for it. The channel is closed
+// when the port is closed.
+//
+// The subscription is a separate connection of the port, so it does not take
+// any packets from the other connections, such as to the sink (with
+// ConnectLast), and the packets are buffered without bound, so that the
+// channel can be read both during and after Run. Note that Run only waits for
+// the processes connected to the sink (or driver), so the port should
+// normally also be connected with ConnectLast. Subscribe has to be called
+// before the workflow is run.
+func (pt *FilePort) Subscribe() <-chan *InformationPacket {
+	inBoundChan := make(chan *InformationPacket, FilePortBufferSize)
+	pt.AddOutChan(inBoundChan)
+	pt.SetConnectedStatus(true)
+
+	subscription := make(chan *InformationPacket)
+	go func() {
+		defer close(subscription)
+		queue := []*InformationPacket{}
+		for inBoundChan != nil || len(queue) > 0 {
+			// Only try to send when there is something queued, since
+			// sending on a nil channel blocks forever
+			var outChan chan *InformationPacket
+			var next *InformationPacket
+			if len(queue) > 0 {
+				outChan = subscription
+				next = queue[0]
+			}
+			select {
+			case ip, ok := <-inBoundChan:
+				if !ok {
+					inBoundChan = nil
+					continue
+				}
+				queue = append(queue, ip)
+			case outChan <- next:
+				queue = queue[1:]
+			}
+		}
+	}()
+	return subscription
+}
+
+// Recv receives the next information packet on the in-port, merged from all
+// of its connected out-ports. It returns nil when all of them are closed.
 func (pt *FilePort) Recv() *InformationPacket {
+	pt.startMergeInputs()
 	return <-pt.InChan
 }
 
@@ -103,13 +263,22 @@ func (pt *FilePort) Close() {
 type ParamPort struct {
 	Chan      chan string
 	connected bool
+	// remotePorts are the ports this port was connected to, with Connect
+	// called on this port, for Workflow.Graph
+	remotePorts []*ParamPort
 }
 
 func NewParamPort() *ParamPort {
 	return &ParamPort{}
 }
 
-func (pp *ParamPort) Connect(otherParamPort *ParamPort) {
+// Connect connects the port to the other port, which has to be a ParamPort,
+// or else an error is returned
+func (pp *ParamPort) Connect(other Port) error {
+	otherParamPort, ok := other.(*ParamPort)
+	if !ok {
+		return fmt.Errorf("Can not connect ParamPort to port of type %T", other)
+	}
 	if pp.Chan != nil && otherParamPort.Chan != nil {
 		Error.Println("Both paramports already have initialized channels, so can't choose which to use!")
 		os.Exit(1)
@@ -125,8 +294,10 @@ func (pp *ParamPort) Connect(otherParamPort *ParamPort) {
 		pp.Chan = ch
 		otherParamPort.Chan = ch
 	}
+	pp.remotePorts = append(pp.remotePorts, otherParamPort)
 	pp.SetConnectedStatus(true)
 	otherParamPort.SetConnectedStatus(true)
+	return nil
 }
 
 func (pp *ParamPort) ConnectStr(strings ...string) {
@@ -1,8 +1,14 @@
 package scipipe
 
 import (
+	"bytes"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMultiInPort(t *testing.T) {
@@ -34,3 +40,270 @@ func TestMultiInPort(t *testing.T) {
 
 	cleanFiles(append(resultFiles, "/tmp/hello.txt", "/tmp/tjena.txt")...)
 }
+
+func TestRecvMergesInputsAutomatically(t *testing.T) {
+	initTestLogs()
+
+	inPort := NewFilePort()
+	outPort1 := NewFilePort()
+	outPort2 := NewFilePort()
+	inPort.Connect(outPort1)
+	inPort.Connect(outPort2)
+
+	go func() {
+		defer outPort1.Close()
+		outPort1.Send(NewInformationPacket("/tmp/recvmerge_1.txt"))
+	}()
+	go func() {
+		defer outPort2.Close()
+		outPort2.Send(NewInformationPacket("/tmp/recvmerge_2.txt"))
+	}()
+
+	// Note that RunMergeInputs is never called manually here
+	paths := map[string]bool{}
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		paths[ip.GetPath()] = true
+	}
+	if !paths["/tmp/recvmerge_1.txt"] || !paths["/tmp/recvmerge_2.txt"] {
+		t.Errorf("Did not receive inputs from both out-ports, got: %v", paths)
+	}
+}
+
+func TestMergeDoesNotWaitForSlowInputs(t *testing.T) {
+	initTestLogs()
+
+	inPort := NewFilePort()
+	slowPort := NewFilePort()
+	fastPort := NewFilePort()
+	inPort.Connect(slowPort)
+	inPort.Connect(fastPort)
+
+	go func() {
+		defer slowPort.Close()
+		time.Sleep(500 * time.Millisecond)
+		slowPort.Send(NewInformationPacket("/tmp/mergeslow_slow.txt"))
+	}()
+	go func() {
+		defer fastPort.Close()
+		fastPort.Send(NewInformationPacket("/tmp/mergeslow_fast1.txt"))
+		fastPort.Send(NewInformationPacket("/tmp/mergeslow_fast2.txt"))
+	}()
+	inPort.startMergeInputs()
+
+	for _, path := range []string{"/tmp/mergeslow_fast1.txt", "/tmp/mergeslow_fast2.txt"} {
+		select {
+		case ip := <-inPort.InChan:
+			assert.Equal(t, path, ip.GetPath())
+		case <-time.After(250 * time.Millisecond):
+			t.Fatal("Packet from the fast out-port was not forwarded before the slow out-port sent anything")
+		}
+	}
+	ip, ok := <-inPort.InChan
+	assert.True(t, ok, "InChan should not be closed before all out-ports are closed")
+	assert.Equal(t, "/tmp/mergeslow_slow.txt", ip.GetPath())
+	_, ok = <-inPort.InChan
+	assert.False(t, ok, "InChan should be closed when all out-ports are closed")
+}
+
+func TestMergeTagSourceAndSeq(t *testing.T) {
+	initTestLogs()
+
+	inPort := NewFilePort()
+	inPort.TagSource = true
+	inPort.TagSeq = true
+	outPort1 := NewFilePort()
+	outPort2 := NewFilePort()
+	inPort.Connect(outPort1)
+	inPort.Connect(outPort2)
+
+	go func() {
+		defer outPort1.Close()
+		outPort1.Send(NewInformationPacket("/tmp/mergetag_1a.txt"))
+		outPort1.Send(NewInformationPacket("/tmp/mergetag_1b.txt"))
+	}()
+	go func() {
+		defer outPort2.Close()
+		outPort2.Send(NewInformationPacket("/tmp/mergetag_2a.txt"))
+	}()
+
+	expectedSources := map[string]string{
+		"/tmp/mergetag_1a.txt": "0",
+		"/tmp/mergetag_1b.txt": "0",
+		"/tmp/mergetag_2a.txt": "1",
+	}
+	seq := 0
+	for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+		if ip.GetKey("merge.source") != expectedSources[ip.GetPath()] {
+			t.Errorf("Wrong source tag for %s: %s", ip.GetPath(), ip.GetKey("merge.source"))
+		}
+		if ip.GetKey("merge.seq") != strconv.Itoa(seq) {
+			t.Errorf("Wrong sequence tag for %s: %s (expected %d)", ip.GetPath(), ip.GetKey("merge.seq"), seq)
+		}
+		seq++
+	}
+	if seq != 3 {
+		t.Errorf("Expected 3 packets, got %d", seq)
+	}
+}
+
+func TestMergeTagSource_SharedPacket(t *testing.T) {
+	initTestLogs()
+
+	outPort := NewFilePort()
+	otherOutPort := NewFilePort()
+	inPort1 := NewFilePort()
+	inPort1.TagSource = true
+	inPort1.Connect(outPort)
+	inPort2 := NewFilePort()
+	inPort2.TagSource = true
+	inPort2.Connect(otherOutPort)
+	inPort2.Connect(outPort)
+
+	ip := NewInformationPacket("/tmp/mergetag_shared.txt")
+	go func() {
+		defer outPort.Close()
+		defer otherOutPort.Close()
+		outPort.Send(ip)
+	}()
+
+	ip1 := inPort1.Recv()
+	ip2 := inPort2.Recv()
+	assert.Equal(t, "0", ip1.GetKey("merge.source"))
+	assert.Equal(t, "1", ip2.GetKey("merge.source"))
+	_, tagged := ip.GetKeys()["merge.source"]
+	assert.False(t, tagged, "The sent packet should not be tagged itself")
+}
+
+func TestMergeTagSource_TwoStages(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("test_mergetag_twostages_wf", 4)
+	foo := wf.NewProc("foo", "echo foo > {o:out}")
+	foo.SetPathStatic("out", "/tmp/mergetag_foo.txt")
+	bar := wf.NewProc("bar", "echo bar > {o:out}")
+	bar.SetPathStatic("out", "/tmp/mergetag_bar.txt")
+
+	first := wf.NewProc("first", "cat {i:in} > {o:out}")
+	first.SetPathExtend("in", "out", ".first.txt")
+	first.In("in").TagSource = true
+	first.In("in").Connect(foo.Out("out"))
+	first.In("in").Connect(bar.Out("out"))
+
+	second := wf.NewProc("second", "cat {i:in} > {o:out}")
+	second.SetPathExtend("in", "out", ".second.txt")
+	second.In("in").TagSource = true
+	second.In("in").Connect(first.Out("out"))
+
+	wf.ConnectLast(second.Out("out"))
+	wf.Run()
+
+	for _, path := range []string{"/tmp/mergetag_foo.txt.first.txt.second.txt", "/tmp/mergetag_bar.txt.first.txt.second.txt"} {
+		ip := NewInformationPacket(path)
+		assert.Equal(t, "0", ip.GetKey("merge.source"), "Tag of the second stage should replace that of the first one, for "+path)
+	}
+
+	cleanFiles("/tmp/mergetag_foo.txt", "/tmp/mergetag_bar.txt")
+	cleanFiles("/tmp/mergetag_foo.txt.first.txt", "/tmp/mergetag_bar.txt.first.txt")
+	cleanFiles("/tmp/mergetag_foo.txt.first.txt.second.txt", "/tmp/mergetag_bar.txt.first.txt.second.txt")
+}
+
+func TestConnectThroughPortInterface(t *testing.T) {
+	initTestLogs()
+
+	outFilePort, inFilePort := NewFilePort(), NewFilePort()
+	outParamPort, inParamPort := NewParamPort(), NewParamPort()
+	pairs := [][2]Port{{inFilePort, outFilePort}, {inParamPort, outParamPort}}
+	for _, pair := range pairs {
+		err := Connect(pair[0], pair[1])
+		assert.Nil(t, err, "Connecting ports of the same kind should not fail")
+		assert.True(t, pair[0].IsConnected() && pair[1].IsConnected(), "Ports should be connected")
+	}
+
+	go func() {
+		outFilePort.Send(NewInformationPacket("foo.txt"))
+		outFilePort.Close()
+		outParamPort.Send("bar")
+		outParamPort.Close()
+	}()
+	assert.Equal(t, "foo.txt", inFilePort.Recv().GetPath(), "Packet not received through ports connected via interface")
+	assert.Equal(t, "bar", inParamPort.Recv(), "Param not received through ports connected via interface")
+
+	err := NewFilePort().Connect(NewParamPort())
+	assert.NotNil(t, err, "Connecting a FilePort to a ParamPort should fail")
+	err = NewParamPort().Connect(NewFilePort())
+	assert.NotNil(t, err, "Connecting a ParamPort to a FilePort should fail")
+}
+
+// BenchmarkFilePortBufferSize sends b.N packets through a connection, and
+// reports the max number of packets in flight (created but not yet consumed),
+// which is bounded by the buffer sizes, independent of the number of packets
+func BenchmarkFilePortBufferSize(b *testing.B) {
+	initTestLogs()
+	defer func(bufSize int) { FilePortBufferSize = bufSize }(FilePortBufferSize)
+
+	for _, bufSize := range []int{0, BUFSIZE} {
+		b.Run("BufferSize"+strconv.Itoa(bufSize), func(b *testing.B) {
+			FilePortBufferSize = bufSize
+			outPort := NewFilePort()
+			inPort := NewFilePort()
+			inPort.Connect(outPort)
+
+			var inFlight, maxInFlight int64
+			go func() {
+				defer outPort.Close()
+				for i := 0; i < b.N; i++ {
+					if n := atomic.AddInt64(&inFlight, 1); n > maxInFlight {
+						maxInFlight = n
+					}
+					outPort.Send(NewInformationPacket("/tmp/bufsize_" + strconv.Itoa(i) + ".txt"))
+				}
+			}()
+			for ip := inPort.Recv(); ip != nil; ip = inPort.Recv() {
+				atomic.AddInt64(&inFlight, -1)
+			}
+			b.ReportMetric(float64(maxInFlight), "max-inflight")
+		})
+	}
+}
+
+func TestSendOnUnconnectedPort(t *testing.T) {
+	initTestLogs()
+
+	logBuf := &bytes.Buffer{}
+	Warning.SetOutput(logBuf)
+	defer Warning.SetOutput(os.Stdout)
+
+	outPort := NewFilePort()
+	outPort.Send(NewInformationPacket("/tmp/unconnected_1.txt"))
+	outPort.Send(NewInformationPacket("/tmp/unconnected_2.txt"))
+	assert.Contains(t, logBuf.String(), "without connections", "Sending on unconnected port should log a warning")
+	assert.Equal(t, 1, bytes.Count(logBuf.Bytes(), []byte("WARNING")), "Warning should only be logged once per port")
+
+	FailOnUnconnectedSend = true
+	defer func() { FailOnUnconnectedSend = false }()
+	exitCodes := []int{}
+	osExit = func(code int) { exitCodes = append(exitCodes, code) }
+	defer func() { osExit = os.Exit }()
+	NewFilePort().Send(NewInformationPacket("/tmp/unconnected_3.txt"))
+	assert.Equal(t, []int{1}, exitCodes, "Sending on unconnected port should exit, with FailOnUnconnectedSend")
+}
+
+func TestSubscribe(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestSubscribeWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/subscribe_" + t.Param("msg") + ".txt" })
+	echo.ParamPort("msg").ConnectStr("a", "b", "c")
+	subscription := echo.Out("out").Subscribe()
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	paths := []string{}
+	for ip := range subscription {
+		paths = append(paths, ip.GetPath())
+	}
+	expected := []string{"/tmp/subscribe_a.txt", "/tmp/subscribe_b.txt", "/tmp/subscribe_c.txt"}
+	assert.Equal(t, expected, paths, "Wrong packets received on subscription")
+	cleanFiles(expected...)
+}
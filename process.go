@@ -2,8 +2,14 @@ package scipipe
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	str "strings"
+	"sync"
+	"time"
 )
 
 // ExecMode specifies which execution mode should be used for a SciProcess and
@@ -14,10 +20,30 @@ const (
 	// ExecModeLocal indicates that commands on the local computer
 	ExecModeLocal ExecMode = iota
 	// ExecModeSLURM indicates that commands should be executed on a HPC cluster
-	// via a SLURM resource manager
+	// via a SLURM resource manager, with the SlurmExecutor of the workflow, if
+	// selected with Workflow.SetExecutor, or else one with default settings,
+	// unless an Executor is set
 	ExecModeSLURM ExecMode = iota
 )
 
+// OutputMode specifies what is done with the stdout or stderr output of the
+// commands of a SciProcess
+type OutputMode int
+
+const (
+	// OutputModeCapture captures the output, which is only shown if the
+	// command fails (as part of the CommandError)
+	OutputModeCapture OutputMode = iota
+	// OutputModeMerge merges the output into stdout, keeping the order in
+	// which the lines were written. It can only be used for stderr.
+	OutputModeMerge OutputMode = iota
+	// OutputModeTee captures the output, and also writes it to the terminal
+	// as it is produced, as well as to the log file of the task, if set
+	OutputModeTee OutputMode = iota
+	// OutputModeDiscard suppresses the output entirely
+	OutputModeDiscard OutputMode = iota
+)
+
 // ================== Process ==================
 
 // Base interface for all processes
@@ -27,6 +53,9 @@ type Process interface {
 	Run()
 }
 
+// ShellProcess is the interface of processes executing shell commands with
+// in- and out-ports. The path formatting methods return the process, for
+// chaining.
 type ShellProcess interface {
 	Process
 
@@ -36,12 +65,14 @@ type ShellProcess interface {
 	Out(string) *FilePort
 	GetOutPorts() map[string]*FilePort
 
-	SetPathStatic(outPortName string, path string)
-	SetPathExtend(inPortName string, outPortName string, extension string)
-	SetPathReplace(inPortName string, outPortName string, old string, new string)
-	SetPathCustom(outPortName string, pathFmtFunc func(task *SciTask) (path string))
+	SetPathStatic(outPortName string, path string) *SciProcess
+	SetPathExtend(inPortName string, outPortName string, extension string) *SciProcess
+	SetPathReplace(inPortName string, outPortName string, old string, new string) *SciProcess
+	SetPathCustom(outPortName string, pathFmtFunc func(task *SciTask) (path string)) *SciProcess
 }
 
+var _ ShellProcess = (*SciProcess)(nil)
+
 // ================== SciProcess ==================
 
 type SciProcess struct {
@@ -55,10 +86,186 @@ type SciProcess struct {
 	outPorts         map[string]*FilePort
 	OutPortsDoStream map[string]bool
 	PathFormatters   map[string]func(*SciTask) string
+	outPortsRemote   map[string]string
+	outPortsTemp     map[string]bool
+	outPortsNoAtom   map[string]bool
 	paramPorts       map[string]*ParamPort
 	CustomExecute    func(*SciTask)
 	workflow         *Workflow
 	CoresPerTask     int
+	// RerunIfInputsNewer makes tasks re-execute even though their outputs
+	// exist, if any of the outputs is older than the newest of the inputs
+	RerunIfInputsNewer bool
+	// RerunIfChanged makes tasks re-execute even though their outputs exist,
+	// if the command (such as its parameters) or the inputs have changed
+	// since the outputs were created. This is detected by comparing a hash of
+	// the task ID (which covers the command pattern, parameters and paths),
+	// the values of the globals used, the prepend string, the conda
+	// environment, modules, shell and script setting, and the sizes and
+	// modification times of the inputs, with the one recorded in the audit
+	// info of the outputs, so outputs without audit info are always
+	// re-created. The run ID and scratch paths are not included, since they
+	// differ between runs.
+	RerunIfChanged bool
+	// BatchExecutor, if set, collects the commands of multiple tasks and
+	// executes them as one batch, instead of one by one. It can not be
+	// combined with Script, Sandbox, FailOnStderrPattern, or other stdout
+	// and stderr modes than OutputModeCapture, which is checked when the
+	// workflow is validated.
+	BatchExecutor *BatchExecutor
+	// Executor, if set, executes the commands of the tasks, such as a
+	// SlurmExecutor submitting them to a cluster, overriding the executor of
+	// the workflow (see Workflow.SetExecutor) and the ExecMode. A
+	// BatchExecutor or CustomExecute function takes precedence over it. If it
+	// implements SupportChecker, the process is checked with it when the
+	// workflow is validated.
+	Executor TaskExecutor
+	// RunIf, if set, is evaluated for each task before it is scheduled, and
+	// if it returns false, the task is skipped. Skipped tasks are not
+	// executed. Instead, for each out-port, the input of the in-port it is
+	// mapped to in RunIfPassThrough is sent on it, in the place of the output
+	// of the task, so that downstream processes with multiple in-ports stay
+	// in sync. For processes with in-ports, each out-port has to be mapped,
+	// which is checked when the process runs. Processes without in-ports
+	// have nothing to pass through, so nothing is sent for their skipped
+	// tasks, and downstream processes just receive fewer packets.
+	RunIf func(*SciTask) bool
+	// RunIfPassThrough maps out-port names to the names of the in-ports whose
+	// inputs are sent on them for tasks skipped with RunIf
+	RunIfPassThrough map[string]string
+	// OnTaskComplete, if set, is called synchronously after each executed
+	// task has finished, with the error of the task, or nil if it succeeded.
+	// Panics in the callback are recovered and logged.
+	OnTaskComplete func(t *SciTask, err error)
+	// MaxLaunchesPerSecond, if larger than zero, limits the rate at which
+	// tasks are launched, to at most this many per second. This is applied
+	// before, and in addition to, the max concurrent tasks of the workflow,
+	// so when both are set, a launched task might still have to wait for a
+	// free slot, making the actual rate of command starts lower.
+	MaxLaunchesPerSecond float64
+	// MaxConcurrentTasks, if larger than zero, limits the number of tasks of
+	// the process that are executed at the same time, in addition to the max
+	// concurrent tasks of the workflow, so that a process receiving a large
+	// number of inputs does not start executing all of them at once. Tasks
+	// with streaming (FIFO) inputs or outputs are not limited, since the
+	// tasks they stream to or from would otherwise block.
+	MaxConcurrentTasks int
+	// CondaEnv, if set, makes the commands of the process execute inside the
+	// conda environment with this name, via "conda run"
+	CondaEnv string
+	// Modules, if set, are loaded with "module load" (for environment modules
+	// such as Lmod) before the commands of the process are executed
+	Modules []string
+	// CommandAlternatives are command patterns to fall back to, in order, if
+	// the command of a task fails (returns a non-zero exit code). All of them
+	// must contain the same place-holders as CommandPattern. Alternatives are
+	// only tried for commands executed locally and not in batches, and can not
+	// be combined with sub-stream (reduce) place-holders.
+	CommandAlternatives []string
+	// TaskDirFormatter, if set, returns a directory unique to each task (such
+	// as one based on a "sample" parameter), which all the output paths of the
+	// task are resolved relative to. This avoids collisions of output file
+	// names between tasks, without elaborate path formatting. The directory
+	// is created when the task is executed, and the temporary and audit files
+	// of the outputs end up in it as well. Output paths have to be relative
+	// when it is set: an absolute output path makes the process panic when
+	// creating the task, instead of being moved into the task directory.
+	TaskDirFormatter func(*SciTask) string
+	// ParamSpec holds constraints on the values of parameter ports, by port
+	// name. Incoming parameter values violating them make the workflow fail,
+	// before any task is created from them. Constraints for ports that the
+	// process does not have make the validation of the workflow fail.
+	ParamSpec map[string]ParamConstraint
+	// RequireNonEmptyOutputs makes tasks fail if any of their (non-streaming)
+	// outputs is empty after the command has finished, even though the
+	// command exited successfully, so that empty outputs of silently failing
+	// tools are not promoted to their final paths. If CommandAlternatives are
+	// set, the next alternative is tried instead. To only require this for
+	// some out-ports, use RequireNonEmptyOutput.
+	RequireNonEmptyOutputs bool
+	// Sandbox makes the commands of the process execute in a sandbox: a
+	// fresh directory mounted over the working directory, in a private mount
+	// namespace, exposing only the declared inputs of each task (read-only).
+	// Declared outputs are collected back from the sandbox to the working
+	// directory after the command has finished, while any other files written
+	// there are reported as undeclared, and removed. Paths outside of the
+	// working directory are not sandboxed. This is only supported on Linux,
+	// with unprivileged user namespaces enabled, and not together with a
+	// BatchExecutor.
+	Sandbox bool
+	// StdoutMode and StderrMode specify what is done with the stdout and
+	// stderr of the commands. By default both are captured, and only shown if
+	// the command fails. Other modes can not be used for commands executed in
+	// batches.
+	StdoutMode OutputMode
+	StderrMode OutputMode
+	// TeeLogPathFormatter, if set, returns the path of a log file per task,
+	// to which the output of streams in OutputModeTee is written (appended),
+	// in addition to the terminal
+	TeeLogPathFormatter func(*SciTask) string
+	// MaxTasks, if larger than zero, limits the number of tasks created by
+	// the process, such as to quickly try out a workflow on the first few
+	// inputs only, like "head". When the limit is reached, the out-ports are
+	// closed, so that downstream processes stop too, while any remaining
+	// inputs are received and discarded, so that upstream processes do not
+	// block.
+	MaxTasks int
+	// Script makes the command pattern be executed as a (multi-line) bash
+	// script: after the place-holders are replaced, the command is written to
+	// a script file in the TempDir of the workflow, which is executed with
+	// "bash <scriptfile>", instead of passing the command to "bash -c" (see
+	// also Shell). This is convenient for longer inline scripts, with heredocs
+	// and such. The rendered script is recorded as the command in the audit
+	// info. Commands executed in batches are inlined in the batch script, as
+	// usual.
+	Script bool
+	// Shell, if set, is the shell (the program and its arguments) that the
+	// commands of the tasks are executed with, such as []string{"sh", "-c"},
+	// overriding the package-level ShellCommand. With Script, the script file
+	// is executed with the program of the shell.
+	Shell []string
+	// OutputFileMode, if set, is the file mode (permissions) that the
+	// (non-streaming) outputs of the tasks, and their audit files, are
+	// changed to when the outputs are moved to their final paths, overriding
+	// the mode they were created with (such as 0644 for WriteTempFile, before
+	// the umask)
+	OutputFileMode os.FileMode
+	// OutputGroup, if set, is the group (name or numeric ID) that the
+	// ownership of the (non-streaming) outputs of the tasks, and their audit
+	// files, is changed to, when they are moved to their final paths. If
+	// changing the group is not permitted, a warning is logged.
+	OutputGroup string
+	// Aggregate makes the process wait until all of its in-ports are closed,
+	// and then create a single task for all the packets received, such as for
+	// a summary report over all samples (like "multiqc"). The in-ports have
+	// to be referenced with list place-holders in the command, as
+	// {i:name:r}, or {i:name:r:sep} with a separator other than space, which
+	// are replaced with the paths of all the packets received on them. Each
+	// parameter port has to get a single value, or the same value every
+	// time. If no packets at all are received, no task is created.
+	Aggregate bool
+	// ParamsFromInputs makes parameter place-holders ({p:name}) whose
+	// parameter ports are not connected take their values from the params
+	// carried by the packets received on the in-ports (see
+	// InformationPacket.AddParam), so that a file and its params stay
+	// correlated, instead of arriving separately on in-ports and parameter
+	// ports. Connected parameter ports take precedence over the params of the
+	// packets, and the packets have to agree on the values of the params they
+	// share. This includes the params of the tasks that produced the packets,
+	// which are recorded in their audit info. It can not be combined with
+	// Aggregate.
+	ParamsFromInputs bool
+	// FailOnStderrPattern, if set, makes tasks fail if the stderr of their
+	// command matches it, even though the command exited successfully, for
+	// tools that report errors without a non-zero exit code. The outputs are
+	// then not atomized, and if CommandAlternatives are set, the next
+	// alternative is tried instead. It can not be combined with a StderrMode
+	// of OutputModeDiscard, and is not applied to commands executed in
+	// batches.
+	FailOnStderrPattern *regexp.Regexp
+	nonEmptyOutPorts    map[string]bool
+	metaOutPorts        map[string]bool
+	devicePools         []*DevicePool
 }
 
 func NewSciProcess(workflow *Workflow, name string, command string) *SciProcess {
@@ -69,6 +276,11 @@ func NewSciProcess(workflow *Workflow, name string, command string) *SciProcess
 		outPorts:         make(map[string]*FilePort),
 		OutPortsDoStream: make(map[string]bool),
 		PathFormatters:   make(map[string]func(*SciTask) string),
+		outPortsRemote:   make(map[string]string),
+		outPortsTemp:     make(map[string]bool),
+		outPortsNoAtom:   make(map[string]bool),
+		nonEmptyOutPorts: make(map[string]bool),
+		metaOutPorts:     make(map[string]bool),
 		paramPorts:       make(map[string]*ParamPort),
 		Spawn:            true,
 		workflow:         workflow,
@@ -118,6 +330,21 @@ func (p *SciProcess) In(portName string) *FilePort {
 	return nil
 }
 
+// AddInPort adds a new in-port with the name portName to the process, in the
+// same way as is done for {i:portName} place-holders in the command pattern.
+// This can be used when building commands programmatically. Note that if the
+// command pattern does not contain a place-holder for the port, nothing is
+// replaced in the command, but the path of the received file is still
+// available via the task, such as in a CustomExecute function. Adding a port
+// with the name of an existing in-port panics, since connections already made
+// to it would be lost.
+func (p *SciProcess) AddInPort(portName string) *FilePort {
+	checkPortName(portName)
+	p.checkNewPort("in-port", portName, p.inPorts[portName] != nil)
+	p.inPorts[portName] = NewFilePort()
+	return p.inPorts[portName]
+}
+
 func (p *SciProcess) SetInPort(portName string, port *FilePort) {
 	p.inPorts[portName] = port
 }
@@ -140,6 +367,130 @@ func (p *SciProcess) Out(portName string) *FilePort {
 	return nil
 }
 
+// AddOutPort adds a new out-port with the name portName to the process, in
+// the same way as is done for {o:portName} (or {os:portName}, with doStream
+// set) place-holders in the command pattern. As for other out-ports, a path
+// formatter has to be set for it. As for AddInPort, adding a port with the
+// name of an existing out-port panics.
+func (p *SciProcess) AddOutPort(portName string, doStream bool) *FilePort {
+	checkPortName(portName)
+	p.checkNewPort("out-port", portName, p.outPorts[portName] != nil)
+	p.outPorts[portName] = NewFilePort()
+	if doStream {
+		p.OutPortsDoStream[portName] = true
+	}
+	return p.outPorts[portName]
+}
+
+// SetOutPortRemote makes the outputs of the out-port portName be uploaded to
+// the remote location remotePrefix (such as "s3://bucket/prefix/"), with the
+// same relative path under it as locally (such as
+// "s3://bucket/prefix/sample1/out.txt" for "sample1/out.txt"), or, for
+// absolute paths, the full path (such as "s3://bucket/prefix/data/out.txt"
+// for "/data/out.txt"). The upload is done before the output is atomized
+// locally, so outputs failing to upload never get their final local paths.
+// The packets sent on the out-port get the remote URLs as paths, so that
+// downstream processes have to fetch them from the remote location. Note that
+// outputs that already exist locally are not uploaded again.
+func (p *SciProcess) SetOutPortRemote(portName string, remotePrefix string) *SciProcess {
+	if _, err := StorageForURL(remotePrefix); err != nil {
+		Error.Fatalf("Process %s: Invalid remote location for out-port %s: %s\n", p.name, portName, err)
+	}
+	p.outPortsRemote[portName] = remotePrefix
+	return p
+}
+
+// MarkOutputTemp marks the outputs of the out-port portName as intermediate
+// files, which are deleted as soon as all the tasks consuming them downstream
+// have finished. Outputs consumed by other kinds of processes than SciProcess
+// (including the sink) are deleted at the end of the workflow run. If the run
+// fails, remaining outputs are kept, for debugging, unless ForceTempCleanup
+// is set on the workflow. Note that the tasks producing deleted outputs are
+// executed again in every re-run of the workflow, since their outputs are
+// missing, even if all the outputs downstream of them exist.
+func (p *SciProcess) MarkOutputTemp(portName string) *SciProcess {
+	if _, ok := p.outPorts[portName]; !ok {
+		Error.Fatalf("Process %s: Can not mark non-existing out-port %s as temporary\n", p.name, portName)
+	}
+	if p.OutPortsDoStream[portName] {
+		Error.Fatalf("Process %s: Can not mark streaming out-port %s as temporary\n", p.name, portName)
+	}
+	p.outPortsTemp[portName] = true
+	return p
+}
+
+// SetOutPortNoAtomize makes the command write the outputs of the out-port
+// portName directly to their final paths, which {o:portName} is then replaced
+// with, instead of to temporary paths that are renamed to the final paths
+// once the command has finished. This is needed for tools that have to write
+// to the exact final path, such as ones creating index files named after
+// their outputs, and saves a rename per output.
+//
+// The tradeoff is that outputs are no longer crash safe: if the workflow is
+// killed while the command is running, the partially written output is left
+// at its final path, and is taken for a finished output in the next run, so
+// it has to be removed manually. Outputs of commands that fail are still
+// removed.
+func (p *SciProcess) SetOutPortNoAtomize(portName string) *SciProcess {
+	if _, ok := p.outPorts[portName]; !ok {
+		Error.Fatalf("Process %s: Can not disable atomization for non-existing out-port %s\n", p.name, portName)
+	}
+	if p.OutPortsDoStream[portName] {
+		Error.Fatalf("Process %s: Can not disable atomization for streaming out-port %s, which is never atomized\n", p.name, portName)
+	}
+	p.outPortsNoAtom[portName] = true
+	return p
+}
+
+// CollectMetaFrom makes the keys in a meta file written by the command, next
+// to the output of the out-port portName, be added to the keys in the audit
+// info of the output, such as for metrics reported by the tool (like the
+// number of mapped reads). The meta file is named as the path that the output
+// is written to, plus ".meta.json", so that it is written by the command to
+// "{o:portName}.meta.json". It should contain a JSON object, whose string
+// values are used as they are, and other values as JSON, such as "42" for
+// the number 42. The meta file is removed after it has been read.
+//
+// If the meta file is missing or malformed, a warning is logged, and no keys
+// are added. Keys that the output already has (from the inputs of the task)
+// are not changed, but logged as warnings, if the meta file has a different
+// value for them.
+func (p *SciProcess) CollectMetaFrom(portName string) *SciProcess {
+	if _, ok := p.outPorts[portName]; !ok {
+		Error.Fatalf("Process %s: Can not collect meta file for non-existing out-port %s\n", p.name, portName)
+	}
+	if p.OutPortsDoStream[portName] {
+		Error.Fatalf("Process %s: Can not collect meta file for streaming out-port %s\n", p.name, portName)
+	}
+	p.metaOutPorts[portName] = true
+	return p
+}
+
+// UseDevicePool makes each task of the process acquire a device from pool,
+// which it has exclusive use of while executing, given to the command in the
+// environment variable of the pool (see DevicePool). A process can use
+// several pools, with different environment variables.
+func (p *SciProcess) UseDevicePool(pool *DevicePool) *SciProcess {
+	for _, usedPool := range p.devicePools {
+		if usedPool.EnvVar == pool.EnvVar {
+			Error.Fatalf("Process %s: Already uses a device pool for %s\n", p.name, pool.EnvVar)
+		}
+	}
+	p.devicePools = append(p.devicePools, pool)
+	return p
+}
+
+// RequireNonEmptyOutput makes tasks fail if the output of the out-port
+// portName is empty after the command has finished, in the same way as
+// RequireNonEmptyOutputs does for all out-ports
+func (p *SciProcess) RequireNonEmptyOutput(portName string) *SciProcess {
+	if _, ok := p.outPorts[portName]; !ok {
+		Error.Fatalf("Process %s: Can not require non-empty output for non-existing out-port %s\n", p.name, portName)
+	}
+	p.nonEmptyOutPorts[portName] = true
+	return p
+}
+
 func (p *SciProcess) SetOutPort(portName string, port *FilePort) {
 	p.outPorts[portName] = port
 }
@@ -170,6 +521,17 @@ func (p *SciProcess) GetParamPorts() map[string]*ParamPort {
 	return p.paramPorts
 }
 
+// AddParamPort adds a new parameter port with the name paramPortName to the
+// process, in the same way as is done for {p:paramPortName} place-holders in
+// the command pattern. As for AddInPort, adding a port with the name of an
+// existing parameter port panics.
+func (p *SciProcess) AddParamPort(paramPortName string) *ParamPort {
+	checkPortName(paramPortName)
+	p.checkNewPort("parameter port", paramPortName, p.paramPorts[paramPortName] != nil)
+	p.paramPorts[paramPortName] = NewParamPort()
+	return p.paramPorts[paramPortName]
+}
+
 func (p *SciProcess) SetParamPort(paramPortName string, paramPort *ParamPort) {
 	p.paramPorts[paramPortName] = paramPort
 }
@@ -178,33 +540,86 @@ func (p *SciProcess) SetParamPort(paramPortName string, paramPort *ParamPort) {
 // Path formatting stuff
 // ------------------------------------------------
 
+// The path formatting methods below return the process itself, so that
+// calls can be chained, such as:
+// wf.NewProc("foo", "echo foo > {o:out}").SetPathStatic("out", "foo.txt")
+
 // SetPathStatic creates an (output) path formatter returning a static string file name
-func (p *SciProcess) SetPathStatic(outPortName string, path string) {
+func (p *SciProcess) SetPathStatic(outPortName string, path string) *SciProcess {
 	p.PathFormatters[outPortName] = func(t *SciTask) string {
 		return path
 	}
+	return p
 }
 
 // SetPathExtend creates an (output) path formatter that extends the path of
 // an input InformationPacket
-func (p *SciProcess) SetPathExtend(inPortName string, outPortName string, extension string) {
+func (p *SciProcess) SetPathExtend(inPortName string, outPortName string, extension string) *SciProcess {
 	p.PathFormatters[outPortName] = func(t *SciTask) string {
 		return t.InPath(inPortName) + extension
 	}
+	return p
 }
 
 // SetPathReplace creates an (output) path formatter that uses an input's path
 // but replaces parts of it.
-func (p *SciProcess) SetPathReplace(inPortName string, outPortName string, old string, new string) {
+func (p *SciProcess) SetPathReplace(inPortName string, outPortName string, old string, new string) *SciProcess {
 	p.PathFormatters[outPortName] = func(t *SciTask) string {
 		return str.Replace(t.InPath(inPortName), old, new, -1)
 	}
+	return p
 }
 
 // SetPathCustom takes a function which produces a file path based on data
 // available in *SciTask, such as concrete file paths and parameter values,
-func (p *SciProcess) SetPathCustom(outPortName string, pathFmtFunc func(task *SciTask) (path string)) {
+// available with t.InPath(inPortName) and t.Param(paramName) respectively.
+// This is the general form of path formatters, which the other SetPath
+// methods are shorthands for.
+func (p *SciProcess) SetPathCustom(outPortName string, pathFmtFunc func(task *SciTask) (path string)) *SciProcess {
 	p.PathFormatters[outPortName] = pathFmtFunc
+	return p
+}
+
+// pathPatternRegex matches the place-holders in path patterns, for
+// SetPathPattern
+var pathPatternRegex = regexp.MustCompile(`{(i|p):(` + portNamePattern + `)(\|(base|stem|dir))?}`)
+
+// SetPathPattern creates an (output) path formatter from a pattern, with
+// place-holders for parameter values ({p:paramName}) and the paths of inputs
+// ({i:inPortName}), which can combine several in-ports and parameters, such
+// as "out/{p:sample}/{i:reads|stem}.bam". The paths of inputs can be modified
+// with "|base" for the file name only, "|stem" for the file name without
+// its (last) extension, and "|dir" for the directory only. All referenced
+// ports have to exist on the process.
+func (p *SciProcess) SetPathPattern(outPortName string, pattern string) *SciProcess {
+	for _, m := range pathPatternRegex.FindAllStringSubmatch(pattern, -1) {
+		if m[1] == "i" && p.inPorts[m[2]] == nil {
+			Error.Fatalf("Process %s: No such in-port ('%s'), in path pattern for out-port %s: %s\n", p.name, m[2], outPortName, pattern)
+		}
+		if m[1] == "p" && p.paramPorts[m[2]] == nil {
+			Error.Fatalf("Process %s: No such param-port ('%s'), in path pattern for out-port %s: %s\n", p.name, m[2], outPortName, pattern)
+		}
+	}
+	p.PathFormatters[outPortName] = func(t *SciTask) string {
+		return pathPatternRegex.ReplaceAllStringFunc(pattern, func(placeHolder string) string {
+			m := pathPatternRegex.FindStringSubmatch(placeHolder)
+			if m[1] == "p" {
+				return t.Param(m[2])
+			}
+			path := t.InPath(m[2])
+			switch m[4] {
+			case "base":
+				return filepath.Base(path)
+			case "stem":
+				base := filepath.Base(path)
+				return str.TrimSuffix(base, filepath.Ext(base))
+			case "dir":
+				return filepath.Dir(path)
+			}
+			return path
+		})
+	}
+	return p
 }
 
 // ------- Helper methods for initialization -------
@@ -274,11 +689,17 @@ func expandCommandParamsAndPaths(cmd string, params map[string]string, inPaths m
 // Set up in- and out-ports based on the shell command pattern used to create the
 // SciProcess. Ports are set up in this way:
 // `{i:PORTNAME}` specifies an in-port
+// `{is:PORTNAME}` specifies an in-port that is expected to stream via a FIFO file
 // `{o:PORTNAME}` specifies an out-port
 // `{os:PORTNAME}` specifies an out-port that streams via a FIFO file
 // `{p:PORTNAME}` a "parameter-port", which means a port where parameters can be "streamed"
+// Non-streaming in-ports can be referenced multiple times in the command, while
+// streaming ones can only be referenced once, since a FIFO can only be read once.
 func (p *SciProcess) initPortsFromCmdPattern(cmd string, params map[string]string) {
 
+	checkPlaceHolders(cmd)
+	checkStreamingInputRefs(cmd, nil)
+
 	// Find in/out port names and Params and set up in struct fields
 	r := getShellCommandPlaceHolderRegex()
 	ms := r.FindAllStringSubmatch(cmd, -1)
@@ -293,26 +714,152 @@ func (p *SciProcess) initPortsFromCmdPattern(cmd string, params map[string]strin
 		typ := m[1]
 		name := m[2]
 		if typ == "o" || typ == "os" {
-			p.outPorts[name] = NewFilePort()
-			if typ == "os" {
-				p.OutPortsDoStream[name] = true
+			if p.outPorts[name] == nil {
+				p.AddOutPort(name, typ == "os")
 			}
-		} else if typ == "i" {
+		} else if typ == "i" || typ == "is" {
 			// Set up a channel on the inports, even though this is
 			// often replaced by another processes output port channel.
 			// It might be nice to have it init'ed with a channel
 			// anyways, for use cases when we want to send InformationPacket
-			// on the inport manually.
-			p.inPorts[name] = NewFilePort()
+			// on the inport manually. Ports referenced more than once
+			// are only added the first time.
+			if p.inPorts[name] == nil {
+				p.AddInPort(name)
+			}
 		} else if typ == "p" {
-			if params == nil || params[name] == "" {
-				p.paramPorts[name] = NewParamPort()
+			if (params == nil || params[name] == "") && p.paramPorts[name] == nil {
+				p.AddParamPort(name)
 			}
 		}
 	}
 }
 
 // ------- Sanity checks -------
+
+// checkNewPort makes sure that a port of the kind portKind, named portName, to
+// be added to the process, does not already exist
+func (p *SciProcess) checkNewPort(portKind string, portName string, exists bool) {
+	if exists {
+		msg := "Process " + p.name + ": Can not add " + portKind + " '" + portName + "', since one with the same name already exists, whose connections would be lost"
+		Check(errors.New(msg), msg)
+	}
+}
+
+// checkSandbox makes sure that sandboxes are supported, if the process uses
+// them
+func (p *SciProcess) checkSandbox() {
+	if !p.Sandbox {
+		return
+	}
+	if err := checkSandboxSupported(); err != nil {
+		Error.Fatalf("Process %s: %s\n", p.name, err)
+	}
+}
+
+// executor returns the executor that the commands of the tasks of the process
+// are executed with, if not in batches or with CustomExecute, or nil if they
+// are executed locally by default
+func (p *SciProcess) executor() TaskExecutor {
+	if p.Executor != nil {
+		return p.Executor
+	}
+	if p.ExecMode == ExecModeSLURM {
+		return p.workflow.slurmExecutor()
+	}
+	return p.workflow.executor
+}
+
+// checkExecutor returns an error if the process uses features that the
+// executor of its tasks does not support (see SupportChecker)
+func (p *SciProcess) checkExecutor() error {
+	if p.CustomExecute != nil {
+		return nil
+	}
+	executor := p.executor()
+	if p.BatchExecutor != nil && p.ExecMode == ExecModeLocal {
+		executor = p.BatchExecutor
+	}
+	if checker, ok := executor.(SupportChecker); ok {
+		return checker.Supports(p)
+	}
+	return nil
+}
+
+// checkAggregate makes sure that all in-ports are referenced with list
+// place-holders in the command, if the process aggregates its inputs
+func (p *SciProcess) checkAggregate() {
+	if !p.Aggregate {
+		return
+	}
+	if p.ParamsFromInputs {
+		Error.Fatalf("Process %s: Aggregate can not be combined with ParamsFromInputs\n", p.name)
+	}
+	for _, m := range getShellCommandPlaceHolderRegex().FindAllStringSubmatch(p.CommandPattern, -1) {
+		if (m[1] == "i" || m[1] == "is") && m[3] == "" {
+			Error.Fatalf("Process %s: With Aggregate set, in-ports have to be referenced with list place-holders, such as {i:%s:r}, but found: %s\n", p.name, m[2], m[0])
+		}
+	}
+}
+
+// checkRunIf makes sure that, if the process has RunIf set, and has in-ports,
+// all out-ports are mapped to existing in-ports in RunIfPassThrough
+func (p *SciProcess) checkRunIf() {
+	if p.RunIf == nil || len(p.inPorts) == 0 {
+		return
+	}
+	for oname := range p.outPorts {
+		iname, ok := p.RunIfPassThrough[oname]
+		if !ok {
+			Error.Fatalf("Process %s: With RunIf set, out-port %s has to be mapped to the in-port whose input is sent on it for skipped tasks, in RunIfPassThrough\n", p.name, oname)
+		}
+		if _, ok := p.inPorts[iname]; !ok {
+			Error.Fatalf("Process %s: RunIfPassThrough maps out-port %s to missing in-port %s\n", p.name, oname, iname)
+		}
+	}
+}
+
+// checkOutputGroup makes sure that the OutputGroup of the process, if set,
+// exists
+func (p *SciProcess) checkOutputGroup() {
+	if p.OutputGroup == "" {
+		return
+	}
+	if _, err := lookupGID(p.OutputGroup); err != nil {
+		Error.Fatalf("Process %s: %s\n", p.name, err)
+	}
+}
+
+// checkCommandAlternatives makes sure that all command alternatives contain
+// the same place-holders as the main command pattern, so that they are
+// compatible with the ports of the process
+func (p *SciProcess) checkCommandAlternatives() {
+	if len(p.CommandAlternatives) == 0 {
+		return
+	}
+	placeHolders := func(cmd string) map[string]bool {
+		phs := map[string]bool{}
+		for _, m := range getShellCommandPlaceHolderRegex().FindAllStringSubmatch(cmd, -1) {
+			if m[3] != "" {
+				Error.Fatalf("%s: Sub-stream place-holders (%s) can not be used together with command alternatives\n", p.name, m[0])
+			}
+			phs[m[1]+":"+m[2]] = true
+		}
+		return phs
+	}
+	expected := placeHolders(p.CommandPattern)
+	for _, altCmdPat := range p.CommandAlternatives {
+		actual := placeHolders(altCmdPat)
+		if len(actual) != len(expected) {
+			Error.Fatalf("%s: Command alternative '%s' does not have the same place-holders as the command pattern '%s'\n", p.name, altCmdPat, p.CommandPattern)
+		}
+		for ph := range expected {
+			if !actual[ph] {
+				Error.Fatalf("%s: Command alternative '%s' does not have the same place-holders as the command pattern '%s'\n", p.name, altCmdPat, p.CommandPattern)
+			}
+		}
+	}
+}
 func (proc *SciProcess) IsConnected() (isConnected bool) {
 	isConnected = true
 	for portName, port := range proc.inPorts {
@@ -328,7 +875,7 @@ func (proc *SciProcess) IsConnected() (isConnected bool) {
 		}
 	}
 	for portName, port := range proc.paramPorts {
-		if !port.IsConnected() {
+		if !port.IsConnected() && !proc.ParamsFromInputs {
 			Error.Printf("ParamPort %s of process %s is not connected - check your workflow code!\n", portName, proc.name)
 			isConnected = false
 		}
@@ -349,12 +896,29 @@ func (p *SciProcess) Run() {
 		Error.Fatalf("%s: CoresPerTask (%d) can't be greater than maxConcurrentTasks of workflow (%d)\n", p.Name(), p.CoresPerTask, cap(p.workflow.concurrentTasks))
 	}
 
+	p.checkCommandAlternatives()
+	p.checkSandbox()
+	p.checkOutputGroup()
+	p.checkAggregate()
+	p.checkRunIf()
+	if p.StdoutMode == OutputModeMerge {
+		Error.Fatalf("Process %s: StdoutMode can not be OutputModeMerge, which is only for stderr\n", p.name)
+	}
+	if p.FailOnStderrPattern != nil && p.StderrMode == OutputModeDiscard {
+		Error.Fatalf("Process %s: FailOnStderrPattern can not be used when stderr is discarded (StderrMode is OutputModeDiscard)\n", p.name)
+	}
+
 	defer p.closeOutPorts()
 
 	for _, inPort := range p.GetInPorts() {
-		go inPort.RunMergeInputs()
+		inPort.startMergeInputs()
 	}
 
+	var lastLaunch time.Time
+	var taskSlots chan struct{}
+	if p.MaxConcurrentTasks > 0 {
+		taskSlots = make(chan struct{}, p.MaxConcurrentTasks)
+	}
 	tasks := []*SciTask{}
 	Debug.Printf("Process %s: Starting to create and schedule tasks\n", p.name)
 	for t := range p.createTasks() {
@@ -362,14 +926,17 @@ func (p *SciProcess) Run() {
 		// Collect created tasks, for the second round
 		// where tasks are waited for to finish, before
 		// sending their outputs.
-		Debug.Printf("Process %s: Instantiated task [%s] ...", p.name, t.Command)
+		taskLogf(Debug, "Process %s: Instantiated task [%s] ...", p.name, t.Command)
 		tasks = append(tasks, t)
+		if t.skipped {
+			continue
+		}
 
 		anyPreviousFifosExists := t.anyFifosExist()
 
 		if p.ExecMode == ExecModeLocal {
-			if !anyPreviousFifosExists {
-				Debug.Printf("Process %s: No FIFOs existed, so creating, for task [%s] ...", p.name, t.Command)
+			if !anyPreviousFifosExists && !p.workflow.DryRun {
+				taskLogf(Debug, "Process %s: No FIFOs existed, so creating, for task [%s] ...", p.name, t.Command)
 				t.createFifos()
 			}
 
@@ -382,7 +949,7 @@ func (p *SciProcess) Run() {
 		}
 
 		if anyPreviousFifosExists {
-			Debug.Printf("Process %s: Previous FIFOs existed, so not executing task [%s] ...\n", p.name, t.Command)
+			taskLogf(Debug, "Process %s: Previous FIFOs existed, so not executing task [%s] ...\n", p.name, t.Command)
 			// Since t.Execute() is not run, that normally sends the Done signal, we
 			// have to send it manually here:
 			go func() {
@@ -390,23 +957,42 @@ func (p *SciProcess) Run() {
 				t.Done <- 1
 			}()
 		} else {
-			Debug.Printf("Process %s: Go-Executing task in separate go-routine: [%s] ...\n", p.name, t.Command)
+			if taskSlots != nil && !t.streams() {
+				taskLogf(Debug, "Process %s: Waiting for a free task slot: [%s] ...\n", p.name, t.Command)
+				taskSlots <- struct{}{} // Will block if MaxConcurrentTasks is reached
+				t.processSlots = taskSlots
+			}
+			if p.MaxLaunchesPerSecond > 0 {
+				lastLaunch = p.waitForLaunch(lastLaunch)
+			}
+			taskLogf(Debug, "Process %s: Go-Executing task in separate go-routine: [%s] ...\n", p.name, t.Command)
 			// Run the task
 			go t.Execute()
-			Debug.Printf("Process %s: Done go-executing task in go-routine: [%s] ...\n", p.name, t.Command)
+			taskLogf(Debug, "Process %s: Done go-executing task in go-routine: [%s] ...\n", p.name, t.Command)
 		}
 	}
 
 	Debug.Printf("Process %s: Starting to loop over %d tasks to send out targets ...\n", p.name, len(tasks))
 	for _, t := range tasks {
-		Debug.Printf("Process %s: Waiting for Done from task: [%s]\n", p.name, t.Command)
+		if t.skipped {
+			p.sendPassThrough(t)
+			continue
+		}
+		taskLogf(Debug, "Process %s: Waiting for Done from task: [%s]\n", p.name, t.Command)
 		<-t.Done
-		Debug.Printf("Process %s: Received Done from task: [%s]\n", p.name, t.Command)
+		taskLogf(Debug, "Process %s: Received Done from task: [%s]\n", p.name, t.Command)
+		if t.cancelled || t.failed {
+			taskLogf(Debug, "Process %s: Task was cancelled or failed, so not sending its targets [%s]\n", p.name, t.Command)
+			continue
+		}
 		for oname, oip := range t.OutTargets {
 			if !oip.doStream {
-				Debug.Printf("Process %s: Sending target on outport %s, for task [%s] ...\n", p.name, oname, t.Command)
+				taskLogf(Debug, "Process %s: Sending target on outport %s, for task [%s] ...\n", p.name, oname, t.Command)
+				if p.outPortsTemp[oname] && !p.workflow.DryRun {
+					p.workflow.registerTempOutput(oip, len(p.Out(oname).outChans))
+				}
 				p.Out(oname).Send(oip)
-				Debug.Printf("Process %s: Done sending target on outport %s, for task [%s] ...\n", p.name, oname, t.Command)
+				taskLogf(Debug, "Process %s: Done sending target on outport %s, for task [%s] ...\n", p.name, oname, t.Command)
 			}
 		}
 	}
@@ -414,18 +1000,41 @@ func (p *SciProcess) Run() {
 
 // -------- Helper methods for the Run method ---------
 
+// sendPassThrough sends, for the task t skipped with RunIf, the inputs of the
+// in-ports mapped to the out-ports in RunIfPassThrough, on the out-ports
+func (p *SciProcess) sendPassThrough(t *SciTask) {
+	for oname, iname := range p.RunIfPassThrough {
+		ip := t.InTargets[iname]
+		taskLogf(Debug, "Process %s: Sending input %s on outport %s, for skipped task [%s] ...\n", p.name, ip.GetPath(), oname, t.Command)
+		p.workflow.retainTempOutput(ip, len(p.Out(oname).outChans))
+		p.Out(oname).Send(ip)
+	}
+	t.releaseInTargets()
+}
+
+// waitForLaunch waits until enough time has passed since lastLaunch, to not
+// exceed MaxLaunchesPerSecond, and returns the time of the new launch
+func (p *SciProcess) waitForLaunch(lastLaunch time.Time) time.Time {
+	interval := time.Duration(float64(time.Second) / p.MaxLaunchesPerSecond)
+	if wait := interval - clk.Since(lastLaunch); wait > 0 {
+		Debug.Printf("Process %s: Waiting %s before launching next task, to respect MaxLaunchesPerSecond\n", p.name, wait)
+		clk.Sleep(wait)
+	}
+	return clk.Now()
+}
+
 func (p *SciProcess) receiveInputs() (inTargets map[string]*InformationPacket, inPortsOpen bool) {
 	inPortsOpen = true
 	inTargets = make(map[string]*InformationPacket)
 	// Read input targets on in-ports and set up path mappings
 	for inpName, inPort := range p.inPorts {
-		Debug.Printf("Process %s: Receieving on inPort %s ...", p.name, inpName)
+		taskLogf(Debug, "Process %s: Receieving on inPort %s ...", p.name, inpName)
 		inTarget, open := <-inPort.InChan
 		if !open {
 			inPortsOpen = false
 			continue
 		}
-		Debug.Printf("Process %s: Got inTarget %s ...", p.name, inTarget.GetPath())
+		taskLogf(Debug, "Process %s: Got inTarget %s ...", p.name, inTarget.GetPath())
 		inTargets[inpName] = inTarget
 	}
 	return
@@ -434,27 +1043,194 @@ func (p *SciProcess) receiveInputs() (inTargets map[string]*InformationPacket, i
 func (p *SciProcess) receiveParams() (params map[string]string, paramPortsOpen bool) {
 	paramPortsOpen = true
 	params = make(map[string]string)
+	numConnected := 0
 	// Read input targets on in-ports and set up path mappings
 	for pname, pport := range p.paramPorts {
+		if p.ParamsFromInputs && !pport.IsConnected() {
+			// Taken from the params of the inputs, in paramsFromInputs
+			continue
+		}
+		numConnected++
 		pval, open := <-pport.Chan
 		if !open {
 			paramPortsOpen = false
 			continue
 		}
 		Debug.Println("Receiving param:", pname, "with value", pval)
+		if err := p.validateParam(pname, pval); err != nil {
+			Error.Fatalf("Process %s: %s\n", p.name, err)
+		}
 		params[pname] = pval
 	}
+	if p.ParamsFromInputs && numConnected == 0 {
+		paramPortsOpen = false
+	}
 	return
 }
 
+// paramsFromInputs adds the values of the parameters whose ports are not
+// connected, from the params of the packets in inTargets, when
+// ParamsFromInputs is set
+func (p *SciProcess) paramsFromInputs(inTargets map[string]*InformationPacket, params map[string]string) {
+	inPortNames := []string{}
+	for inPortName := range inTargets {
+		inPortNames = append(inPortNames, inPortName)
+	}
+	sort.Strings(inPortNames)
+	for pname, pport := range p.paramPorts {
+		if pport.IsConnected() {
+			continue
+		}
+		fromPort := ""
+		for _, inPortName := range inPortNames {
+			pval, ok := inTargets[inPortName].GetAuditInfo().Params[pname]
+			if !ok {
+				continue
+			}
+			if prev, ok := params[pname]; ok && prev != pval {
+				Error.Fatalf("Process %s: Param '%s' has different values in the packets on in-ports %s (%s) and %s (%s)\n", p.name, pname, fromPort, prev, inPortName, pval)
+			}
+			if err := p.validateParam(pname, pval); err != nil {
+				Error.Fatalf("Process %s: %s\n", p.name, err)
+			}
+			params[pname] = pval
+			fromPort = inPortName
+		}
+		if fromPort == "" {
+			Error.Fatalf("Process %s: Param '%s' is neither connected, nor found in the params of the packets on any of the in-ports: %s\n", p.name, pname, str.Join(inPortNames, ", "))
+		}
+	}
+}
+
+// drainInputs receives and discards everything left on the in-ports and
+// parameter ports, until they are closed, so that upstream processes are not
+// blocked from finishing, when the workflow is stopped
+func (p *SciProcess) drainInputs() {
+	wg := sync.WaitGroup{}
+	for _, inPort := range p.inPorts {
+		wg.Add(1)
+		go func(inPort *FilePort) {
+			defer wg.Done()
+			for range inPort.InChan {
+			}
+		}(inPort)
+	}
+	for _, pport := range p.paramPorts {
+		if !pport.IsConnected() {
+			continue
+		}
+		wg.Add(1)
+		go func(pport *ParamPort) {
+			defer wg.Done()
+			for range pport.Chan {
+			}
+		}(pport)
+	}
+	wg.Wait()
+}
+
+// receiveAggregatedInputs receives all packets on the in-ports, and all
+// values on the parameter ports, until they are closed. It returns, for each
+// in-port, a packet with the received packets on its sub-stream, to be
+// expanded by list place-holders, along with the parameters, and the total
+// number of packets received.
+func (p *SciProcess) receiveAggregatedInputs() (inTargets map[string]*InformationPacket, params map[string]string, numInputs int) {
+	inTargets = make(map[string]*InformationPacket)
+	params = make(map[string]string)
+	mx := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	// Receive on all ports concurrently, so that upstream processes sending
+	// on more than one of them do not block
+	for inpName, inPort := range p.inPorts {
+		wg.Add(1)
+		go func(inpName string, inPort *FilePort) {
+			defer wg.Done()
+			ips := []*InformationPacket{}
+			for ip := range inPort.InChan {
+				ips = append(ips, ip)
+			}
+			subStreamIP := NewInformationPacket("")
+			subStreamIP.SubStream.InChan = make(chan *InformationPacket, len(ips))
+			for _, ip := range ips {
+				subStreamIP.SubStream.InChan <- ip
+			}
+			close(subStreamIP.SubStream.InChan)
+			mx.Lock()
+			inTargets[inpName] = subStreamIP
+			numInputs += len(ips)
+			mx.Unlock()
+		}(inpName, inPort)
+	}
+	for pname, pport := range p.paramPorts {
+		wg.Add(1)
+		go func(pname string, pport *ParamPort) {
+			defer wg.Done()
+			for pval := range pport.Chan {
+				if err := p.validateParam(pname, pval); err != nil {
+					Error.Fatalf("Process %s: %s\n", p.name, err)
+				}
+				mx.Lock()
+				if prev, ok := params[pname]; ok && prev != pval {
+					Error.Fatalf("Process %s: Param '%s' got different values (%s and %s), but can only have one, with Aggregate set\n", p.name, pname, prev, pval)
+				}
+				params[pname] = pval
+				mx.Unlock()
+			}
+		}(pname, pport)
+	}
+	wg.Wait()
+	return
+}
+
+// checkParamSpec makes sure that all the parameters with constraints in
+// ParamSpec have parameter ports on the process, so that misspelled names are
+// not silently ignored
+func (p *SciProcess) checkParamSpec() error {
+	missing := []string{}
+	for pname := range p.ParamSpec {
+		if _, ok := p.paramPorts[pname]; !ok {
+			missing = append(missing, pname)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Process %s: ParamSpec has constraints for missing param ports: %s", p.name, str.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateParam validates the value of the parameter pname against the
+// constraint in ParamSpec, if any
+func (p *SciProcess) validateParam(pname string, pval string) error {
+	if constraint, ok := p.ParamSpec[pname]; ok {
+		if err := constraint.Validate(pval); err != nil {
+			return fmt.Errorf("Invalid value for param '%s': %s", pname, err)
+		}
+	}
+	return nil
+}
+
 func (p *SciProcess) createTasks() (ch chan *SciTask) {
 	ch = make(chan *SciTask)
 	go func() {
 		defer close(ch)
+		numTasks := 0
 		for {
-			inTargets, inPortsOpen := p.receiveInputs()
+			var inTargets map[string]*InformationPacket
+			var params map[string]string
+			inPortsOpen, paramPortsOpen := true, true
+			if p.Aggregate {
+				var numInputs int
+				inTargets, params, numInputs = p.receiveAggregatedInputs()
+				if numInputs == 0 && len(p.inPorts) > 0 {
+					Info.Printf("Process %s: No inputs received to aggregate, so not creating any task\n", p.name)
+					break
+				}
+			} else {
+				inTargets, inPortsOpen = p.receiveInputs()
+				params, paramPortsOpen = p.receiveParams()
+			}
 			Debug.Printf("Process.createTasks:%s Got inTargets: %v", p.name, inTargets)
-			params, paramPortsOpen := p.receiveParams()
 			Debug.Printf("Process.createTasks:%s Got params: %s", p.name, params)
 			if !inPortsOpen && !paramPortsOpen {
 				Debug.Printf("Process.createTasks:%s Breaking: Both inPorts and paramPorts closed", p.name)
@@ -468,21 +1244,112 @@ func (p *SciProcess) createTasks() (ch chan *SciTask) {
 				Debug.Printf("Process.createTasks:%s Breaking: No params, and inPorts closed", p.name)
 				break
 			}
-			t := NewSciTask(p.workflow, p.name, p.CommandPattern, inTargets, p.PathFormatters, p.OutPortsDoStream, params, p.Prepend, p.ExecMode, p.CoresPerTask)
+			if p.workflow.isStopped() {
+				Debug.Printf("Process.createTasks:%s Breaking: Workflow stopped, so draining remaining inputs", p.name)
+				p.drainInputs()
+				break
+			}
+			if p.ParamsFromInputs {
+				p.paramsFromInputs(inTargets, params)
+			}
+			pathFormatters := p.PathFormatters
+			if p.TaskDirFormatter != nil {
+				pathFormatters = p.taskDirPathFormatters()
+			}
+			t := newSciTask(p.workflow, p.name, p.CommandPattern, inTargets, pathFormatters, p.OutPortsDoStream, p.outPortsNoAtom, params, p.Prepend, p.ExecMode, p.CoresPerTask)
 			if p.CustomExecute != nil {
 				t.CustomExecute = p.CustomExecute
 			}
+			t.RerunIfInputsNewer = p.RerunIfInputsNewer
+			t.RerunIfChanged = p.RerunIfChanged
+			t.BatchExecutor = p.BatchExecutor
+			t.Executor = p.Executor
+			t.OnTaskComplete = p.OnTaskComplete
+			t.CondaEnv = p.CondaEnv
+			t.Modules = p.Modules
+			t.remoteOutPrefixes = p.outPortsRemote
+			t.RequireNonEmptyOutputs = p.RequireNonEmptyOutputs
+			t.Sandbox = p.Sandbox
+			t.Script = p.Script
+			t.Shell = p.Shell
+			t.OutputFileMode = p.OutputFileMode
+			t.OutputGroup = p.OutputGroup
+			t.FailOnStderrPattern = p.FailOnStderrPattern
+			t.StdoutMode = p.StdoutMode
+			t.StderrMode = p.StderrMode
+			if p.TeeLogPathFormatter != nil {
+				t.TeeLogPath = p.TeeLogPathFormatter(t)
+			}
+			t.nonEmptyOutPorts = p.nonEmptyOutPorts
+			t.metaOutPorts = p.metaOutPorts
+			t.devicePools = p.devicePools
+			for _, altCmdPat := range p.CommandAlternatives {
+				t.CommandAlternatives = append(t.CommandAlternatives, t.formatTaskCommand(altCmdPat))
+			}
+			if p.RunIf == nil || p.RunIf(t) {
+				numTasks++
+			} else {
+				Info.Printf("Process %s: Skipping task, since RunIf returned false: [%s]\n", p.name, t.Command)
+				t.skipped = true
+			}
 			ch <- t
+			if p.MaxTasks > 0 && numTasks >= p.MaxTasks {
+				Info.Printf("Process %s: Reached MaxTasks (%d), so not creating more tasks\n", p.name, p.MaxTasks)
+				p.discardRemainingInputs()
+				break
+			}
 			if len(p.inPorts) == 0 && len(p.paramPorts) == 0 {
 				Debug.Printf("Process.createTasks:%s Breaking: No inports nor params", p.name)
 				break
 			}
+			if p.Aggregate {
+				Debug.Printf("Process.createTasks:%s Breaking: Created the aggregating task", p.name)
+				break
+			}
 		}
 		Debug.Printf("Process.createTasks:%s Did break", p.name)
 	}()
 	return ch
 }
 
+// discardRemainingInputs receives and discards any remaining inputs and
+// parameters, in the background, so that upstream processes do not block
+func (p *SciProcess) discardRemainingInputs() {
+	for _, inPort := range p.inPorts {
+		go func(inPort *FilePort) {
+			for ip := range inPort.InChan {
+				p.workflow.releaseTempOutput(ip)
+			}
+		}(inPort)
+	}
+	for _, paramPort := range p.paramPorts {
+		go func(paramPort *ParamPort) {
+			for range paramPort.Chan {
+			}
+		}(paramPort)
+	}
+}
+
+// taskDirPathFormatters returns the path formatters of the process, wrapped so
+// that the paths are resolved relative to the task directory returned by
+// TaskDirFormatter. Absolute paths can not be resolved relative to the task
+// directory, so they make it panic.
+func (p *SciProcess) taskDirPathFormatters() map[string]func(*SciTask) string {
+	pathFormatters := make(map[string]func(*SciTask) string)
+	for oname, ofun := range p.PathFormatters {
+		oname, ofun := oname, ofun
+		pathFormatters[oname] = func(t *SciTask) string {
+			path := ofun(t)
+			if filepath.IsAbs(path) {
+				msg := fmt.Sprintf("Process %s: The path %s of out-port %s is absolute, but paths have to be relative to the task directory when TaskDirFormatter is set", p.name, path, oname)
+				Check(errors.New(msg), msg)
+			}
+			return filepath.Join(p.TaskDirFormatter(t), path)
+		}
+	}
+	return pathFormatters
+}
+
 func (p *SciProcess) closeOutPorts() {
 	for oname, oport := range p.outPorts {
 		Debug.Printf("Process %s: Closing port(s) %s ...\n", p.name, oname)
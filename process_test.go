@@ -3,6 +3,8 @@ package scipipe
 import (
 	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNewProc(t *testing.T) {
@@ -78,3 +80,80 @@ func TestSetPathReplace(t *testing.T) {
 		t.Error(`p.PathFormatters["bar"]() != "foo.bar.txt"`)
 	}
 }
+
+func TestSetPathPattern(t *testing.T) {
+	wf := NewWorkflow("test_wf", 16)
+	p := NewProc(wf, "align", "align {i:reads} {i:ref} {p:sample} > {o:bam}")
+	p.SetPathPattern("bam", "{i:reads|dir}/{p:sample}/{i:reads|stem}.{i:ref|base}.bam")
+
+	mockTask := NewSciTask(wf, "align_task", "", map[string]*InformationPacket{
+		"reads": NewInformationPacket("data/reads.fq"),
+		"ref":   NewInformationPacket("refs/hg38.fa"),
+	}, nil, nil, map[string]string{"sample": "s1"}, "", p.ExecMode, 1)
+
+	assert.Equal(t, "data/s1/reads.hg38.fa.bam", p.PathFormatters["bam"](mockTask))
+}
+
+func TestNewProc_DottedAndHyphenatedPortNames(t *testing.T) {
+	wf := NewWorkflow("test_wf", 16)
+	p := NewProc(wf, "sort", "samtools sort {i:align.bam} > {o:sorted-bam}")
+	p.SetPathExtend("align.bam", "sorted-bam", ".sorted.bam")
+
+	assert.NotNil(t, p.In("align.bam"))
+	assert.NotNil(t, p.Out("sorted-bam"))
+
+	task := NewSciTask(wf, "sort_task", p.CommandPattern, map[string]*InformationPacket{"align.bam": NewInformationPacket("foo.bam")}, p.PathFormatters, nil, nil, "", p.ExecMode, 1)
+	assert.Equal(t, "samtools sort foo.bam > foo.bam.sorted.bam.tmp", task.Command)
+}
+
+func TestAddPortsProgrammatically(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestAddPortsProgrammaticallyWf", 4)
+	p := wf.NewProc("cat", "cat {i:in} > {o:out}")
+	p.AddInPort("extra")
+	p.AddOutPort("log", false)
+	p.AddOutPort("stream", true)
+	p.AddParamPort("threads")
+
+	assert.NotNil(t, p.In("extra"))
+	assert.NotNil(t, p.Out("log"))
+	assert.False(t, p.OutPortsDoStream["log"], "Out-port should not be streaming")
+	assert.True(t, p.OutPortsDoStream["stream"], "Out-port should be streaming")
+	assert.NotNil(t, p.ParamPort("threads"))
+
+	p.SetPathExtend("in", "out", ".out")
+	p.SetPathStatic("log", "cat.log")
+	task := NewSciTask(wf, "cat_task", p.CommandPattern, map[string]*InformationPacket{"in": NewInformationPacket("foo.txt"), "extra": NewInformationPacket("extra.txt")}, map[string]func(*SciTask) string{"out": p.PathFormatters["out"], "log": p.PathFormatters["log"]}, nil, nil, "", p.ExecMode, 1)
+	assert.Equal(t, "cat foo.txt > foo.txt.out.tmp", task.Command, "Command should be formatted also with ports lacking place-holders")
+	assert.Equal(t, "extra.txt", task.InPath("extra"))
+}
+
+func TestAddPortsProgrammatically_ExistingName(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestAddPortsExistingNameWf", 4)
+	p := wf.NewProc("cat", "cat {i:in} > {o:out} # {p:opts}")
+	inPort := p.In("in")
+	assert.Panics(t, func() { p.AddInPort("in") }, "Adding an existing in-port should panic")
+	assert.Panics(t, func() { p.AddOutPort("out", false) }, "Adding an existing out-port should panic")
+	assert.Panics(t, func() { p.AddParamPort("opts") }, "Adding an existing param port should panic")
+	assert.True(t, inPort == p.In("in"), "The existing in-port should be kept")
+}
+
+func TestNewProc_InPortReferencedTwice(t *testing.T) {
+	wf := NewWorkflow("test_wf", 16)
+	p := NewProc(wf, "paste", "paste {i:in} {i:in} > {o:out}")
+	p.SetPathExtend("in", "out", ".pasted.txt")
+
+	task := NewSciTask(wf, "paste_task", p.CommandPattern, map[string]*InformationPacket{"in": NewInformationPacket("foo.txt")}, p.PathFormatters, nil, nil, "", p.ExecMode, 1)
+	assert.Equal(t, "paste foo.txt foo.txt > foo.txt.pasted.txt.tmp", task.Command)
+
+	assert.Panics(t, func() { NewProc(wf, "paste_stream", "paste {is:in} {is:in} > {o:out}") }, "Streaming in-port referenced twice should panic")
+
+	streamIP := NewInformationPacket("foo.txt")
+	streamIP.doStream = true
+	assert.Panics(t, func() {
+		NewSciTask(wf, "paste_task", p.CommandPattern, map[string]*InformationPacket{"in": streamIP}, p.PathFormatters, nil, nil, "", p.ExecMode, 1)
+	}, "Stream received on in-port referenced twice should panic")
+}
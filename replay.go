@@ -0,0 +1,265 @@
+package scipipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	str "strings"
+)
+
+// ----------------------------------------------------------------------------
+// Replay
+// ----------------------------------------------------------------------------
+
+// replayTask is a task reconstructed from the audit info of its outputs
+type replayTask struct {
+	id        string
+	auditInfo *AuditInfo
+	outPaths  []string
+	inPaths   []string
+}
+
+// ReplayFromAudit re-executes the commands of an earlier run of a workflow,
+// as recorded in the audit files (".audit.json" or ".audit.json.gz") and
+// central audit logs (".audit.jsonl", see AuditModeCentral) found in dir and
+// its sub-directories, without the workflow itself.
+//
+// Each task is reconstructed from the audit info of its outputs, including
+// tasks of intermediate outputs that have since been removed, whose audit info
+// is still available in the Upstream field of the audit info of later outputs.
+// The tasks are executed one at a time, in the order of their dependencies,
+// with the exact commands that were recorded, in the same conda environments
+// and with the same environment modules, if any. Since the commands contain
+// the paths of the inputs and outputs, the replay has to be done from the
+// same working directory as the original run.
+//
+// Like for a normal run, tasks whose outputs all exist already are skipped,
+// so outputs to re-create have to be removed first. The outputs are written
+// to the temporary paths found in the commands, and moved in place when the
+// command has finished. Inputs not produced by any task have to exist.
+// Streaming outputs can not be replayed.
+func ReplayFromAudit(dir string) error {
+	tasks := map[string]*replayTask{}
+	producers := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if str.HasSuffix(path, ".audit.jsonl") {
+			return addReplayTasksFromLog(tasks, producers, path)
+		}
+		var store *fileAuditStore
+		switch {
+		case str.HasSuffix(path, ".audit.json"):
+			store = &fileAuditStore{suffix: ".audit.json"}
+		case str.HasSuffix(path, ".audit.json.gz"):
+			store = &fileAuditStore{suffix: ".audit.json.gz", gzip: true}
+		default:
+			return nil
+		}
+		outPath := str.TrimSuffix(path, store.suffix)
+		data, err := store.read(outPath)
+		if err != nil {
+			return err
+		}
+		auditInfo := NewAuditInfo()
+		if err := json.Unmarshal(data, auditInfo); err != nil {
+			return fmt.Errorf("Could not unmarshal audit file %s: %s", path, err)
+		}
+		return addReplayTask(tasks, producers, outPath, auditInfo)
+	})
+	if err != nil {
+		return fmt.Errorf("Could not read audit files in %s: %s", dir, err)
+	}
+
+	ordered, err := orderReplayTasks(tasks, producers)
+	if err != nil {
+		return err
+	}
+	wf := NewWorkflow("replay", 1)
+	for _, task := range ordered {
+		if err := task.run(wf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addReplayTasksFromLog adds the tasks of all the outputs in the central audit log
+// at logPath (see AuditModeCentral), as with addReplayTask
+func addReplayTasksFromLog(tasks map[string]*replayTask, producers map[string]string, logPath string) error {
+	store := &centralAuditStore{logPath: logPath}
+	store.mx.Lock()
+	err := store.loadIndex()
+	store.mx.Unlock()
+	if err != nil {
+		return err
+	}
+	outPaths := []string{}
+	for outPath := range store.index {
+		outPaths = append(outPaths, outPath)
+	}
+	sort.Strings(outPaths)
+	for _, outPath := range outPaths {
+		auditInfo := NewAuditInfo()
+		if err := json.Unmarshal(store.index[outPath], auditInfo); err != nil {
+			return fmt.Errorf("Could not unmarshal audit info of %s in the audit log %s: %s", outPath, logPath, err)
+		}
+		if err := addReplayTask(tasks, producers, outPath, auditInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addReplayTask adds outPath as an output of the task recorded in auditInfo,
+// and recursively does the same for the upstream outputs in it. Audit info
+// without a command, such as for pre-existing files, is skipped.
+func addReplayTask(tasks map[string]*replayTask, producers map[string]string, outPath string, auditInfo *AuditInfo) error {
+	if auditInfo == nil || auditInfo.Command == "" || auditInfo.PreExisting {
+		return nil
+	}
+	outPath = filepath.Clean(outPath)
+	id := auditInfo.TaskID
+	if id == "" {
+		id = auditInfo.Command
+	}
+	if otherID, ok := producers[outPath]; ok && otherID != id {
+		return fmt.Errorf("Output %s was produced by more than one task, with the commands: %s, and: %s", outPath, tasks[otherID].auditInfo.Command, auditInfo.Command)
+	}
+	task, ok := tasks[id]
+	if !ok {
+		task = &replayTask{id: id, auditInfo: auditInfo}
+		for inPath := range auditInfo.Upstream {
+			task.inPaths = append(task.inPaths, filepath.Clean(inPath))
+		}
+		sort.Strings(task.inPaths)
+		tasks[id] = task
+	}
+	if _, ok := producers[outPath]; !ok {
+		producers[outPath] = id
+		task.outPaths = append(task.outPaths, outPath)
+		sort.Strings(task.outPaths)
+	}
+	for inPath, upstream := range auditInfo.Upstream {
+		if err := addReplayTask(tasks, producers, inPath, upstream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderReplayTasks sorts the tasks so that every task comes after the tasks
+// producing its inputs. Independent tasks are sorted by ID, to make the order
+// deterministic.
+func orderReplayTasks(tasks map[string]*replayTask, producers map[string]string) ([]*replayTask, error) {
+	ids := []string{}
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	ordered := []*replayTask{}
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+	var visit func(id string) error
+	visit = func(id string) error {
+		if done[id] {
+			return nil
+		}
+		if visiting[id] {
+			return fmt.Errorf("Could not order the tasks to replay, as they have circular dependencies, involving the command: %s", tasks[id].auditInfo.Command)
+		}
+		visiting[id] = true
+		for _, inPath := range tasks[id].inPaths {
+			if upstreamID, ok := producers[inPath]; ok {
+				if err := visit(upstreamID); err != nil {
+					return err
+				}
+			}
+		}
+		visiting[id] = false
+		done[id] = true
+		ordered = append(ordered, tasks[id])
+		return nil
+	}
+	for _, id := range ids {
+		if err := visit(id); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// run executes the command of the task, unless all of its outputs exist, and
+// moves the outputs from their temporary paths in place
+func (rt *replayTask) run(wf *Workflow) error {
+	allExist := true
+	for _, outPath := range rt.outPaths {
+		if _, err := os.Stat(outPath); err != nil {
+			allExist = false
+		}
+	}
+	if allExist {
+		Info.Printf("Replay: Outputs already exist, so skipping command: %s\n", rt.auditInfo.Command)
+		return nil
+	}
+	for _, inPath := range rt.inPaths {
+		if _, err := os.Stat(inPath); err != nil {
+			return fmt.Errorf("Replay: Input %s is missing, for the command: %s", inPath, rt.auditInfo.Command)
+		}
+	}
+	if str.Contains(rt.auditInfo.Command, ".fifo") {
+		return fmt.Errorf("Replay: Streaming outputs can not be replayed, in the command: %s", rt.auditInfo.Command)
+	}
+	for _, outPath := range rt.outPaths {
+		if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+			return fmt.Errorf("Replay: Could not create directory for %s: %s", outPath, err)
+		}
+	}
+
+	t := &SciTask{
+		Name:     "replay",
+		ID:       rt.id,
+		Command:  rt.auditInfo.Command,
+		CondaEnv: rt.auditInfo.CondaEnv,
+		Modules:  rt.auditInfo.Modules,
+		workflow: wf,
+	}
+	Info.Printf("Replay: Executing command: %s\n", t.Command)
+	if err := t.ExecuteCommand(); err != nil {
+		return err
+	}
+
+	for _, outPath := range rt.outPaths {
+		tempPath := replayTempPath(t.Command, outPath)
+		if tempPath != "" {
+			if _, err := os.Stat(tempPath); err == nil {
+				if err := os.Rename(tempPath, outPath); err != nil {
+					return fmt.Errorf("Replay: Could not move %s in place: %s", tempPath, err)
+				}
+			}
+		}
+		if _, err := os.Stat(outPath); err != nil {
+			return fmt.Errorf("Replay: Output %s was not created, by the command: %s", outPath, t.Command)
+		}
+	}
+	return nil
+}
+
+// replayTempPath returns the temporary path of outPath used in cmd (see
+// InformationPacket.GetTempPath), or an empty string if there is none, such as
+// for outputs that were not atomized
+func replayTempPath(cmd string, outPath string) string {
+	tempPathRegex := regexp.MustCompile(`(?:^|[^\w./-])(` + regexp.QuoteMeta(outPath) + `(?:\.[0-9]+-[a-z0-9]+)?\.tmp)`)
+	if m := tempPathRegex.FindStringSubmatch(cmd); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package scipipe
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayFromAudit(t *testing.T) {
+	initTestLogs()
+	dir := "/tmp/replay_test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wf := NewWorkflow("TestReplayFromAuditWf", 4)
+	foo := wf.NewProc("foo", "echo {p:word} > {o:foo}")
+	foo.SetPathPattern("foo", dir+"/{p:word}.txt")
+	foo.ParamPort("word").ConnectStr("hej", "hello")
+	upper := wf.NewProc("upper", "tr a-z A-Z < {i:in} > {o:upper}")
+	upper.SetPathExtend("in", "upper", ".upper.txt")
+	upper.In("in").Connect(foo.Out("foo"))
+	wf.ConnectLast(upper.Out("upper"))
+	wf.Run()
+
+	// The intermediate outputs are removed along with their audit files, so
+	// that their tasks are only known from the Upstream audit info
+	for _, word := range []string{"hej", "hello"} {
+		os.Remove(dir + "/" + word + ".txt")
+		os.Remove(dir + "/" + word + ".txt.audit.json")
+		os.Remove(dir + "/" + word + ".txt.upper.txt")
+	}
+
+	err := ReplayFromAudit(dir)
+	assert.Nil(t, err)
+	for _, word := range []string{"hej", "hello"} {
+		content, err := ioutil.ReadFile(dir + "/" + word + ".txt")
+		assert.Nil(t, err)
+		assert.Equal(t, word+"\n", string(content))
+		content, err = ioutil.ReadFile(dir + "/" + word + ".txt.upper.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"hej": "HEJ\n", "hello": "HELLO\n"}[word], string(content))
+		_, err = os.Stat(dir + "/" + word + ".txt.upper.txt.tmp")
+		assert.True(t, os.IsNotExist(err), "Temporary output should have been moved in place")
+	}
+
+	// Replaying again does nothing, as all the outputs exist
+	assert.Nil(t, ReplayFromAudit(dir))
+}
+
+func TestReplayFromAudit_CentralLog(t *testing.T) {
+	initTestLogs()
+	dir := "/tmp/replay_central_test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wf := NewWorkflow("TestReplayFromAuditCentralWf", 4)
+	wf.AuditMode = AuditModeCentral
+	wf.AuditLogPath = dir + "/wf.audit.jsonl"
+	foo := wf.NewProc("foo", "mkdir -p "+dir+"; echo foo > {o:foo}")
+	foo.SetPathStatic("foo", dir+"/foo.txt")
+	upper := wf.NewProc("upper", "tr a-z A-Z < {i:in} > {o:upper}")
+	upper.SetPathExtend("in", "upper", ".upper.txt")
+	upper.In("in").Connect(foo.Out("foo"))
+	wf.ConnectLast(upper.Out("upper"))
+	wf.Run()
+
+	os.Remove(dir + "/foo.txt")
+	os.Remove(dir + "/foo.txt.upper.txt")
+
+	err := ReplayFromAudit(dir)
+	assert.Nil(t, err)
+	content, err := ioutil.ReadFile(dir + "/foo.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo\n", string(content))
+	content, err = ioutil.ReadFile(dir + "/foo.txt.upper.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "FOO\n", string(content))
+}
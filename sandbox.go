@@ -0,0 +1,203 @@
+package scipipe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	str "strings"
+)
+
+// ----------------------------------------------------------------------------
+// Sandbox
+// ----------------------------------------------------------------------------
+
+// sandboxDirName is the name of the directory, in the working directory, under
+// which the sandboxes of tasks are created
+const sandboxDirName = ".scipipe-sandbox"
+
+// sandbox is a fresh directory that is mounted over the working directory,
+// in a private mount namespace, while the command of a task is executed, so
+// that the command only sees the declared inputs of the task in the working
+// directory (bind-mounted read-only), and everything it writes there ends up
+// in the sandbox directory.
+//
+// Afterwards, the declared outputs (their temporary paths) are collected back
+// by moving them to the same paths in the real working directory, from where
+// they are atomized as usual. Any other files written in the sandbox are
+// reported as undeclared side effects, and removed with the sandbox.
+//
+// Only paths within the working directory are sandboxed. Inputs and outputs
+// outside of it (such as absolute paths elsewhere) are used in place, as
+// without a sandbox.
+type sandbox struct {
+	dir      string
+	workDir  string
+	inPaths  []string
+	outPaths []string
+}
+
+// checkSandboxSupported returns an error if sandboxes are not supported on
+// the current platform
+func checkSandboxSupported() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("Sandbox is only supported on Linux (using mount namespaces), not on %s", runtime.GOOS)
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		return errors.New("Sandbox requires the unshare command (from util-linux), which was not found in PATH")
+	}
+	return nil
+}
+
+// newSandbox creates a sandbox for the task t, with placeholders for its
+// inputs, and the directories of its outputs
+func (t *SciTask) newSandbox() (*sandbox, error) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get working directory for sandbox: %s", err)
+	}
+	sb := &sandbox{
+		dir:     filepath.Join(workDir, sandboxDirName, t.Name+"."+randSeqLC(12)),
+		workDir: workDir,
+	}
+	if err := os.MkdirAll(sb.dir, 0777); err != nil {
+		return nil, fmt.Errorf("Could not create sandbox directory %s: %s", sb.dir, err)
+	}
+
+	// Inputs, as well as FIFOs of streaming outputs, are bind-mounted from
+	// the working directory, onto placeholders in the sandbox
+	mountedPaths := []string{}
+	for _, iip := range t.InTargets {
+		if iip.doStream {
+			mountedPaths = append(mountedPaths, iip.GetFifoPath())
+		} else {
+			mountedPaths = append(mountedPaths, iip.GetPath())
+		}
+	}
+	for _, oip := range t.OutTargets {
+		if oip.doStream {
+			mountedPaths = append(mountedPaths, oip.GetFifoPath())
+		} else if relPath, ok := sb.relPath(oip.GetTempPath()); ok {
+			sb.outPaths = append(sb.outPaths, relPath)
+			if err := os.MkdirAll(filepath.Join(sb.dir, filepath.Dir(relPath)), 0777); err != nil {
+				return sb, fmt.Errorf("Could not create output directory in sandbox: %s", err)
+			}
+		}
+	}
+	for _, path := range mountedPaths {
+		relPath, ok := sb.relPath(path)
+		if !ok || path == "" {
+			continue
+		}
+		if err := sb.createPlaceholder(relPath); err != nil {
+			return sb, err
+		}
+		sb.inPaths = append(sb.inPaths, relPath)
+	}
+	return sb, nil
+}
+
+// relPath returns path relative to the working directory, and whether it is
+// within the working directory at all
+func (sb *sandbox) relPath(path string) (string, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	relPath, err := filepath.Rel(sb.workDir, absPath)
+	if err != nil || relPath == "." || relPath == ".." || str.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relPath, true
+}
+
+// createPlaceholder creates an empty file or directory in the sandbox, at the
+// same relative path as the file or directory at relPath in the working
+// directory, for it to be bind-mounted onto
+func (sb *sandbox) createPlaceholder(relPath string) error {
+	placeholder := filepath.Join(sb.dir, relPath)
+	if err := os.MkdirAll(filepath.Dir(placeholder), 0777); err != nil {
+		return fmt.Errorf("Could not create directory in sandbox: %s", err)
+	}
+	fi, err := os.Stat(filepath.Join(sb.workDir, relPath))
+	if err != nil {
+		return fmt.Errorf("Could not find input to expose in sandbox: %s", err)
+	}
+	if fi.IsDir() {
+		err = os.MkdirAll(placeholder, 0777)
+	} else {
+		var f *os.File
+		f, err = os.Create(placeholder)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Could not create placeholder for input in sandbox: %s", err)
+	}
+	return nil
+}
+
+// wrapCommand wraps cmd so that it is executed with shell in a private mount
+// namespace, with the sandbox mounted over the working directory
+func (sb *sandbox) wrapCommand(shell []string, cmd string) string {
+	script := []string{"set -e"}
+	for _, relPath := range sb.inPaths {
+		src := shellQuote(filepath.Join(sb.workDir, relPath))
+		dst := shellQuote(filepath.Join(sb.dir, relPath))
+		script = append(script, "mount --bind "+src+" "+dst, "mount -o remount,bind,ro "+dst)
+	}
+	script = append(script,
+		"mount --rbind "+shellQuote(sb.dir)+" "+shellQuote(sb.workDir),
+		"cd "+shellQuote(sb.workDir),
+		"exec "+shellCommandLine(shell, cmd))
+	return "unshare --user --map-root-user --mount " + shellCommandLine(shell, str.Join(script, "\n"))
+}
+
+// collectOutputs moves the declared outputs of the task from the sandbox to
+// the working directory, and reports any other files written in the sandbox
+func (sb *sandbox) collectOutputs(taskName string) error {
+	for _, relPath := range sb.outPaths {
+		src := filepath.Join(sb.dir, relPath)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		dst := filepath.Join(sb.workDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return fmt.Errorf("Could not create directory for output collected from sandbox: %s", err)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("Could not collect output %s from sandbox: %s", relPath, err)
+		}
+	}
+	known := map[string]bool{}
+	for _, relPath := range append(append([]string{}, sb.inPaths...), sb.outPaths...) {
+		known[relPath] = true
+	}
+	undeclared := []string{}
+	filepath.Walk(sb.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		relPath, _ := filepath.Rel(sb.dir, path)
+		if !known[relPath] {
+			undeclared = append(undeclared, relPath)
+		}
+		return nil
+	})
+	if len(undeclared) > 0 {
+		Warning.Printf("Task:%-12s Wrote undeclared file(s) in sandbox, which are removed: %s\n", taskName, str.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// remove removes the sandbox directory, with anything left in it
+func (sb *sandbox) remove() {
+	if err := os.RemoveAll(sb.dir); err != nil {
+		Warning.Printf("Could not remove sandbox directory %s: %s\n", sb.dir, err)
+	}
+	// Remove the parent directory too, if no other sandboxes are left in it
+	os.Remove(filepath.Dir(sb.dir))
+}
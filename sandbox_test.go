@@ -0,0 +1,48 @@
+package scipipe
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandbox(t *testing.T) {
+	initTestLogs()
+	if err := checkSandboxSupported(); err != nil {
+		t.Skip(err)
+	}
+	if err := exec.Command("unshare", "--user", "--map-root-user", "--mount", "true").Run(); err != nil {
+		t.Skip("Unprivileged mount namespaces not available: ", err)
+	}
+
+	err := ioutil.WriteFile("sandbox_in.txt", []byte("input\n"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile("sandbox_undeclared.txt", []byte("undeclared\n"), 0644)
+	assert.Nil(t, err)
+	defer cleanFiles("sandbox_in.txt", "sandbox_undeclared.txt", "sandbox_in.txt.out.txt")
+
+	wf := NewWorkflow("TestSandboxWf", 4)
+	src := NewIPGen(wf, "src", "sandbox_in.txt")
+	cat := wf.NewProc("cat", "cat {i:in} > {o:out}; cat sandbox_undeclared.txt >> {o:out} || true; (echo x >> {i:in}) 2> /dev/null || echo read-only >> {o:out}; echo side > sandbox_side.txt")
+	cat.SetPathExtend("in", "out", ".out.txt")
+	cat.Sandbox = true
+	cat.In("in").Connect(src.Out)
+	wf.ConnectLast(cat.Out("out"))
+	wf.Run()
+
+	dat, err := ioutil.ReadFile("sandbox_in.txt.out.txt")
+	assert.Nil(t, err, "Declared output should be collected from sandbox")
+	assert.Equal(t, "input\nread-only\n", string(dat), "Only the declared input should be visible, read-only, in the sandbox")
+
+	dat, err = ioutil.ReadFile("sandbox_in.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "input\n", string(dat), "Input should not be modified")
+
+	for _, f := range []string{"sandbox_side.txt", sandboxDirName} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Should not exist after sandboxed run: "+f)
+	}
+}
@@ -1,13 +1,20 @@
 package scipipe
 
 import (
+	"bytes"
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -369,6 +376,462 @@ func TestPassOnKeys(t *testing.T) {
 	cleanFiles("/tmp/hey.txt", "/tmp/hey.txt.you.txt")
 }
 
+func TestRerunIfInputsNewer(t *testing.T) {
+	initTestLogs()
+
+	inPath := "/tmp/rerun_in.txt"
+	outPath := "/tmp/rerun_in.txt.out.txt"
+	ioutil.WriteFile(inPath, []byte("new\n"), 0644)
+	ioutil.WriteFile(outPath, []byte("old\n"), 0644)
+	anHourAgo := time.Now().Add(-1 * time.Hour)
+	os.Chtimes(outPath, anHourAgo, anHourAgo)
+
+	wf := NewWorkflow("TestRerunIfInputsNewerWf", 4)
+	ipg := NewIPGen(wf, "ipg", inPath)
+	cat := wf.NewProc("cat", "cat {i:in} > {o:out}")
+	cat.SetPathExtend("in", "out", ".out.txt")
+	cat.RerunIfInputsNewer = true
+	cat.In("in").Connect(ipg.Out)
+	wf.ConnectLast(cat.Out("out"))
+	wf.Run()
+
+	dat, err := ioutil.ReadFile(outPath)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "new\n", string(dat), "Stale output was not re-created")
+
+	cleanFiles(inPath, outPath)
+}
+
+func TestRerunIfChanged(t *testing.T) {
+	initTestLogs()
+
+	inPath := "/tmp/rerunchanged_in.txt"
+	outPath := "/tmp/rerunchanged_out.txt"
+	runsPath := "/tmp/rerunchanged_runs.txt"
+	cleanFiles(outPath, outPath+".audit.json", runsPath)
+	ioutil.WriteFile(inPath, []byte("hej\n"), 0644)
+	run := func(suffix string) {
+		wf := NewWorkflow("TestRerunIfChangedWf", 4)
+		ipg := NewIPGen(wf, "ipg", inPath)
+		cat := wf.NewProc("cat", "echo run >> "+runsPath+"; cat {i:in} > {o:out}; echo {p:suffix} >> {o:out}")
+		cat.SetPathStatic("out", outPath)
+		cat.RerunIfChanged = true
+		cat.In("in").Connect(ipg.Out)
+		cat.ParamPort("suffix").ConnectStr(suffix)
+		wf.ConnectLast(cat.Out("out"))
+		wf.Run()
+	}
+	numRuns := func() int {
+		dat, _ := ioutil.ReadFile(runsPath)
+		return strings.Count(string(dat), "run")
+	}
+
+	run("a")
+	assert.Equal(t, 1, numRuns())
+	run("a")
+	assert.Equal(t, 1, numRuns(), "Task should be skipped when command and inputs are unchanged")
+	run("b")
+	assert.Equal(t, 2, numRuns(), "Task should be re-run when a parameter has changed")
+	ioutil.WriteFile(inPath, []byte("hopp\n"), 0644)
+	run("b")
+	assert.Equal(t, 3, numRuns(), "Task should be re-run when an input has changed")
+	dat, err := ioutil.ReadFile(outPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "hopp\nb\n", string(dat), "Output was not re-created")
+
+	cleanFiles(inPath, outPath, outPath+".audit.json", runsPath)
+}
+
+func TestRerunIfChangedIgnoresRunID(t *testing.T) {
+	initTestLogs()
+
+	outPath := "/tmp/rerunchanged_runid_out.txt"
+	runsPath := "/tmp/rerunchanged_runid_runs.txt"
+	cleanFiles(outPath, outPath+".audit.json", runsPath)
+	run := func(runID string) {
+		wf := NewWorkflow("TestRerunIfChangedIgnoresRunIDWf", 4)
+		wf.RunID = runID
+		echo := wf.NewProc("echo", "echo run >> "+runsPath+"; echo {runid} > {o:out}")
+		echo.SetPathStatic("out", outPath)
+		echo.RerunIfChanged = true
+		wf.ConnectLast(echo.Out("out"))
+		wf.Run()
+	}
+
+	run("run1")
+	run("run2")
+	dat, _ := ioutil.ReadFile(runsPath)
+	assert.Equal(t, 1, strings.Count(string(dat), "run"), "Task should be skipped when only the run ID has changed")
+
+	cleanFiles(outPath, outPath+".audit.json", runsPath)
+}
+
+func TestRerunIfChangedGlobal(t *testing.T) {
+	initTestLogs()
+
+	outPath := "/tmp/rerunchanged_global_out.txt"
+	cleanFiles(outPath)
+	run := func(reference string) {
+		wf := NewWorkflow("TestRerunIfChangedGlobalWf", 4)
+		wf.SetGlobal("reference", reference)
+		echo := wf.NewProc("echo", "echo {g:reference} > {o:out}")
+		echo.SetPathStatic("out", outPath)
+		echo.RerunIfChanged = true
+		wf.ConnectLast(echo.Out("out"))
+		wf.Run()
+	}
+
+	run("hg19")
+	run("hg38")
+	dat, err := ioutil.ReadFile(outPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "hg38\n", string(dat), "Task should be re-run when a global it uses has changed")
+
+	cleanFiles(outPath)
+}
+
+func TestRunIf(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestRunIfWf", 4)
+	echo := wf.NewProc("echo", "echo {p:sample} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/runif_" + t.Param("sample") + ".txt" })
+	echo.RunIf = func(t *SciTask) bool { return t.Param("sample") != "control" }
+	echo.ParamPort("sample").ConnectStr("tumor", "control", "normal")
+
+	cat := wf.NewProc("cat", "cat {i:in} > {o:out}")
+	cat.SetPathExtend("in", "out", ".cat.txt")
+	cat.In("in").Connect(echo.Out("out"))
+	wf.ConnectLast(cat.Out("out"))
+	wf.Run()
+
+	for _, f := range []string{"/tmp/runif_tumor.txt.cat.txt", "/tmp/runif_normal.txt.cat.txt"} {
+		_, err := os.Stat(f)
+		assert.Nil(t, err, "File missing: "+f)
+	}
+	_, err := os.Stat("/tmp/runif_control.txt")
+	assert.NotNil(t, err, "File of skipped task should not exist")
+
+	cleanFiles("/tmp/runif_tumor.txt", "/tmp/runif_normal.txt", "/tmp/runif_tumor.txt.cat.txt", "/tmp/runif_normal.txt.cat.txt")
+}
+
+func TestRunIf_PassThroughKeepsInPortsInSync(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestRunIfPassThroughWf", 4)
+	echo := wf.NewProc("echo", "echo {p:sample} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/runifpt_" + t.Param("sample") + ".txt" })
+	echo.ParamPort("sample").ConnectStr("tumor", "control", "normal")
+
+	upper := wf.NewProc("upper", "tr a-z A-Z < {i:in} > {o:out}")
+	upper.SetPathExtend("in", "out", ".upper.txt")
+	upper.RunIf = func(t *SciTask) bool { return !strings.Contains(t.InPath("in"), "control") }
+	upper.RunIfPassThrough = map[string]string{"out": "in"}
+	upper.In("in").Connect(echo.Out("out"))
+
+	join := wf.NewProc("join", "paste -d ' ' {i:upper} {i:orig} > {o:out}")
+	join.SetPathExtend("orig", "out", ".join.txt")
+	join.In("upper").Connect(upper.Out("out"))
+	join.In("orig").Connect(echo.Out("out"))
+	wf.ConnectLast(join.Out("out"))
+	wf.Run()
+
+	expected := map[string]string{
+		"tumor":   "TUMOR tumor\n",
+		"control": "control control\n",
+		"normal":  "NORMAL normal\n",
+	}
+	for sample, content := range expected {
+		dat, err := ioutil.ReadFile("/tmp/runifpt_" + sample + ".txt.join.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(dat), "Wrong joined content for sample "+sample)
+	}
+	_, err := os.Stat("/tmp/runifpt_control.txt.upper.txt")
+	assert.NotNil(t, err, "File of skipped task should not exist")
+
+	for _, sample := range []string{"tumor", "control", "normal"} {
+		path := "/tmp/runifpt_" + sample + ".txt"
+		cleanFiles(path, path+".upper.txt", path+".join.txt")
+	}
+}
+
+func TestOnTaskComplete(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestOnTaskCompleteWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/ontaskcomplete_" + t.Param("msg") + ".txt" })
+	mx := sync.Mutex{}
+	completed := 0
+	echo.OnTaskComplete = func(task *SciTask, err error) {
+		mx.Lock()
+		completed++
+		mx.Unlock()
+		assert.Nil(t, err)
+		panic("Panics in the callback should be recovered")
+	}
+	echo.ParamPort("msg").ConnectStr("a", "b", "c")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, 3, completed, "Wrong number of completed tasks")
+	cleanFiles("/tmp/ontaskcomplete_a.txt", "/tmp/ontaskcomplete_b.txt", "/tmp/ontaskcomplete_c.txt")
+}
+
+func TestMaxLaunchesPerSecond(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestMaxLaunchesPerSecondWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/ratelimit_" + t.Param("msg") + ".txt" })
+	echo.MaxLaunchesPerSecond = 10
+	echo.ParamPort("msg").ConnectStr("a", "b", "c", "d")
+	wf.ConnectLast(echo.Out("out"))
+
+	startTime := time.Now()
+	wf.Run()
+	elapsed := time.Since(startTime)
+
+	// The first task is launched immediately, and the following three at
+	// least 100 ms apart
+	assert.True(t, elapsed >= 300*time.Millisecond, "Tasks were launched too fast, in %s", elapsed)
+	cleanFiles("/tmp/ratelimit_a.txt", "/tmp/ratelimit_b.txt", "/tmp/ratelimit_c.txt", "/tmp/ratelimit_d.txt")
+}
+
+func TestMaxConcurrentTasks(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestMaxConcurrentTasksWf", 16)
+	sleep := wf.NewProc("sleep", "sleep 0.{p:n}; echo {p:n} > {o:out}")
+	sleep.SetPathPattern("out", "/tmp/maxconc_{p:n}.txt")
+	sleep.MaxConcurrentTasks = 2
+	running, maxRunning := 0, 0
+	mx := sync.Mutex{}
+	sleep.CustomExecute = func(task *SciTask) {
+		mx.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mx.Unlock()
+		assert.Nil(t, task.ExecuteCommand())
+		mx.Lock()
+		running--
+		mx.Unlock()
+	}
+	// Later tasks sleep shorter, so finish in another order than they were
+	// created in
+	sleep.ParamPort("n").ConnectStr("4", "3", "2", "1")
+
+	outPaths := []string{}
+	outPort := NewFilePort()
+	outPort.Connect(sleep.Out("out"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ip := outPort.Recv(); ip != nil; ip = outPort.Recv() {
+			outPaths = append(outPaths, ip.GetPath())
+		}
+	}()
+	wf.Run()
+	<-done
+
+	assert.Equal(t, 2, maxRunning, "Wrong max number of tasks executing at the same time")
+	assert.Equal(t, []string{"/tmp/maxconc_4.txt", "/tmp/maxconc_3.txt", "/tmp/maxconc_2.txt", "/tmp/maxconc_1.txt"}, outPaths, "Outputs not sent in the order the tasks were created")
+	for _, n := range []string{"1", "2", "3", "4"} {
+		cleanFiles("/tmp/maxconc_"+n+".txt", "/tmp/maxconc_"+n+".txt.audit.json")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	infoLog := &bytes.Buffer{}
+	InitLog(ioutil.Discard, ioutil.Discard, infoLog, ioutil.Discard, ioutil.Discard, os.Stderr)
+	defer initTestLogs()
+	os.RemoveAll("/tmp/dryrun")
+
+	wf := NewWorkflow("TestDryRunWf", 4)
+	wf.DryRun = true
+	foo := wf.NewProc("foo", "echo foo > {o:out}")
+	foo.SetPathStatic("out", "/tmp/dryrun/foo.txt")
+	bar := wf.NewProc("bar", "sed 's/foo/bar/' {i:in} > {o:out}")
+	bar.SetPathExtend("in", "out", ".bar.txt")
+	bar.In("in").Connect(foo.Out("out"))
+	wf.ConnectLast(bar.Out("out"))
+	err := wf.Run()
+
+	assert.Nil(t, err)
+	assert.Contains(t, infoLog.String(), "Would execute command: echo foo > /tmp/dryrun/foo.txt.tmp\n")
+	assert.Contains(t, infoLog.String(), "Would execute command: sed 's/foo/bar/' /tmp/dryrun/foo.txt > /tmp/dryrun/foo.txt.bar.txt.tmp\n")
+	_, statErr := os.Stat("/tmp/dryrun")
+	assert.True(t, os.IsNotExist(statErr), "No files or directories should be created in a dry run")
+}
+
+func TestExecuteCommandInCustomExecute(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestExecuteCommandInCustomExecuteWf", 4)
+	echo := wf.NewProc("echo", "echo hej > {o:out}")
+	echo.SetPathStatic("out", "/tmp/executecommand.txt")
+	wrapped := false
+	echo.CustomExecute = func(task *SciTask) {
+		err := task.ExecuteCommand()
+		assert.Nil(t, err)
+		wrapped = true
+	}
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.True(t, wrapped, "CustomExecute was not run")
+	dat, err := ioutil.ReadFile("/tmp/executecommand.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "hej\n", string(dat), "Wrong content in output of wrapped command")
+	cleanFiles("/tmp/executecommand.txt")
+}
+
+func TestCondaEnvAndModules(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestCondaEnvAndModulesWf", 4)
+	task := NewSciTask(wf, "t", "echo 'hej' > out.txt", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	assert.Equal(t, "echo 'hej' > out.txt", task.envCommand(), "Command should not be wrapped without env")
+
+	task.CondaEnv = "my env"
+	task.Modules = []string{"samtools/1.9", "bwa"}
+	expected := "module load 'samtools/1.9' 'bwa' && conda run --no-capture-output -n 'my env' 'bash' '-c' 'echo '\"'\"'hej'\"'\"' > out.txt'"
+	assert.Equal(t, expected, task.envCommand(), "Wrong wrapping of command in conda env and modules")
+}
+
+func TestCommandAlternatives(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestCommandAlternativesWf", 4)
+	echo := wf.NewProc("echo", "gpu_tool_not_existing {p:msg} > {o:out}")
+	echo.CommandAlternatives = []string{"exit 1; echo {p:msg} > {o:out}", "echo {p:msg} cpu > {o:out}"}
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/cmdalt_" + t.Param("msg") + ".txt" })
+	echo.ParamPort("msg").ConnectStr("hej")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	dat, err := ioutil.ReadFile("/tmp/cmdalt_hej.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "hej cpu\n", string(dat), "Output not produced by the working alternative")
+
+	auditInfo := &AuditInfo{}
+	err = json.Unmarshal([]byte(NewInformationPacket("/tmp/cmdalt_hej.txt").ReadAuditFile()), auditInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo hej cpu > /tmp/cmdalt_hej.txt.tmp", auditInfo.Command, "Audit info should contain the executed alternative")
+	cleanFiles("/tmp/cmdalt_hej.txt")
+}
+
+func TestTaskDirFormatter(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestTaskDirFormatterWf", 4)
+	echo := wf.NewProc("echo", "echo {p:sample} > {o:out}")
+	echo.SetPathStatic("out", "out.txt")
+	echo.TaskDirFormatter = func(t *SciTask) string { return "/tmp/taskdir_" + t.Param("sample") }
+	echo.ParamPort("sample").ConnectStr("a", "b")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	for _, sample := range []string{"a", "b"} {
+		dat, err := ioutil.ReadFile("/tmp/taskdir_" + sample + "/out.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, sample+"\n", string(dat), "Wrong content in output in task dir")
+		_, err = os.Stat("/tmp/taskdir_" + sample + "/out.txt.audit.json")
+		assert.Nil(t, err, "Audit file missing in task dir")
+		os.RemoveAll("/tmp/taskdir_" + sample)
+	}
+}
+
+func TestTaskDirFormatter_AbsolutePath(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestTaskDirFormatterAbsWf", 4)
+	echo := wf.NewProc("echo", "echo {p:sample} > {o:out}")
+	echo.SetPathStatic("out", "/tmp/out.txt")
+	echo.TaskDirFormatter = func(t *SciTask) string { return "/tmp/taskdir_" + t.Param("sample") }
+
+	task := NewSciTask(wf, "echo", "echo a", nil, nil, nil, map[string]string{"sample": "a"}, "", ExecModeLocal, 1)
+	assert.Panics(t, func() { echo.taskDirPathFormatters()["out"](task) }, "An absolute output path should not be accepted with a task dir")
+}
+
+func TestRunID(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestRunIDWf", 4)
+	wf.RunID = "run1"
+	echo := wf.NewProc("echo", "echo {runid} > {o:out}")
+	echo.SetPathStatic("out", "/tmp/runid_{runid}.txt")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/runid_run1.txt")
+	assert.Equal(t, "run1\n", string(ip.Read()), "Run ID not replaced in command")
+	assert.Equal(t, "run1", ip.GetAuditInfo().RunID, "Run ID not recorded in audit info")
+	cleanFiles(ip.GetPath())
+}
+
+func TestRunID_NotReplacedInValues(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestRunIDValuesWf", 4)
+	wf.RunID = "run1"
+	echo := wf.NewProc("echo", "echo {runid} {p:msg} > {o:out}")
+	echo.SetPathStatic("out", "/tmp/runid_values.txt")
+	echo.ParamPort("msg").ConnectStr("literal_{runid}")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/runid_values.txt")
+	assert.Equal(t, "run1 literal_{runid}\n", string(ip.Read()), "Run ID should only be replaced in the command pattern")
+	cleanFiles(ip.GetPath())
+}
+
+func TestRunID_DefaultFromClock(t *testing.T) {
+	initTestLogs()
+	_, restore := useMockClock()
+	defer restore()
+
+	wf := NewWorkflow("TestRunIDDefaultWf", 4)
+	assert.Equal(t, "20170101-000000", wf.RunID, "Default run ID should be the time of the clock")
+}
+
+func TestCommandErrorExitCode(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestCommandErrorExitCodeWf", 4)
+	task := NewSciTask(wf, "fail", "echo oops >&2; exit 42", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	err := task.ExecuteCommand()
+
+	cmdErr, ok := err.(*CommandError)
+	assert.True(t, ok, "Error should be a *CommandError")
+	assert.Equal(t, 42, cmdErr.ExitCode, "Wrong exit code")
+	assert.Equal(t, "oops\n", cmdErr.Stderr, "Wrong stderr")
+	assert.Equal(t, "echo oops >&2; exit 42", cmdErr.Command, "Wrong command")
+}
+
+func TestScratchFiles(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestScratchFilesWf", 4)
+	wf.TempDir = "/tmp/scratchfiles_tmp"
+	sorter := wf.NewProc("sorter", "printf 'b\\na\\n' > {t:unsorted}; sort {t:unsorted} > {o:out}; ls {t:unsorted} > /dev/null")
+	sorter.SetPathStatic("out", "/tmp/scratchfiles_sorted.txt")
+	wf.ConnectLast(sorter.Out("out"))
+	wf.Run()
+
+	dat, err := ioutil.ReadFile("/tmp/scratchfiles_sorted.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "a\nb\n", string(dat), "Wrong output using scratch file")
+	files, err := ioutil.ReadDir("/tmp/scratchfiles_tmp/scipipe-scratch")
+	assert.Nil(t, err, "Scratch dir should have been created")
+	assert.Equal(t, 0, len(files), "Scratch files should have been removed")
+
+	cleanFiles("/tmp/scratchfiles_sorted.txt")
+	os.RemoveAll("/tmp/scratchfiles_tmp")
+}
+
 // --------------------------------------------------------------------------------
 // Helper functions
 // --------------------------------------------------------------------------------
@@ -501,3 +964,454 @@ func (p *MapToKeys) Run() {
 		p.Out.Send(ip)
 	}
 }
+
+func TestRequireNonEmptyOutputs(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestRequireNonEmptyOutputsWf", 4)
+	wf.KeepGoing = true
+	empty := wf.NewProc("empty", "touch {o:out}")
+	empty.SetPathStatic("out", "/tmp/nonempty_empty.txt")
+	empty.RequireNonEmptyOutputs = true
+	wf.ConnectLast(empty.Out("out"))
+	err := wf.Run()
+
+	assert.NotNil(t, err, "Task with empty output should fail")
+	for _, f := range []string{"/tmp/nonempty_empty.txt", "/tmp/nonempty_empty.txt.tmp"} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Empty output should not be kept: "+f)
+	}
+
+	wf = NewWorkflow("TestRequireNonEmptyOutputsAlternativeWf", 4)
+	withAlt := wf.NewProc("with_alt", "touch {o:out} {o:other}")
+	withAlt.CommandAlternatives = []string{"echo hej > {o:out}; touch {o:other}"}
+	withAlt.SetPathStatic("out", "/tmp/nonempty_out.txt").SetPathStatic("other", "/tmp/nonempty_other.txt").RequireNonEmptyOutput("out")
+	wf.ConnectLast(withAlt.Out("out"))
+	wf.ConnectLast(withAlt.Out("other"))
+	err = wf.Run()
+
+	assert.Nil(t, err)
+	dat, readErr := ioutil.ReadFile("/tmp/nonempty_out.txt")
+	assert.Nil(t, readErr)
+	assert.Equal(t, "hej\n", string(dat), "Output should be produced by the alternative, after empty output")
+	fi, statErr := os.Stat("/tmp/nonempty_other.txt")
+	assert.Nil(t, statErr, "Empty output of port not requiring non-empty output should be kept")
+	if statErr == nil {
+		assert.Equal(t, int64(0), fi.Size())
+	}
+
+	cleanFiles("/tmp/nonempty_out.txt", "/tmp/nonempty_other.txt")
+}
+
+func TestGlobals(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestGlobalsWf", 4)
+	wf.SetGlobal("reference", "ref.fa")
+	echo := wf.NewProc("echo", "echo {g:reference} {g:reference} > {o:out}")
+	echo.SetPathStatic("out", "/tmp/globals_out.txt")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/globals_out.txt")
+	assert.Equal(t, "ref.fa ref.fa\n", string(ip.Read()), "Global not replaced in command")
+	assert.Equal(t, map[string]string{"reference": "ref.fa"}, ip.GetAuditInfo().Globals, "Global not recorded in audit info")
+	cleanFiles(ip.GetPath())
+
+	assert.Panics(t, func() {
+		NewSciTask(wf, "missing", "echo {g:missing}", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	}, "Missing global should panic")
+}
+
+func TestTaskID(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestTaskIDWf", 4)
+	newTask := func(msg string) *SciTask {
+		return NewSciTask(wf, "echo", "echo {p:msg} > {o:out}", nil, map[string]func(*SciTask) string{
+			"out": func(t *SciTask) string { return "/tmp/task_id_" + t.Param("msg") + ".txt" },
+		}, nil, map[string]string{"msg": msg}, "", ExecModeLocal, 1)
+	}
+
+	task := newTask("hi")
+	assert.Len(t, task.ID, 16)
+	assert.Equal(t, task.ID, newTask("hi").ID, "Same task should get the same ID")
+	assert.NotEqual(t, task.ID, newTask("hello").ID, "Tasks with different params should get different IDs")
+
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/task_id_" + t.Param("msg") + ".txt" })
+	echo.ParamPort("msg").ConnectStr("hi")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/task_id_hi.txt")
+	assert.Equal(t, task.ID, ip.GetAuditInfo().TaskID, "Task ID not recorded in audit info")
+	cleanFiles(ip.GetPath())
+}
+
+func TestOutputModes(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestOutputModesWf", 4)
+	newTask := func() *SciTask {
+		return NewSciTask(wf, "outputs", "echo out1; echo err1 >&2; echo out2; echo err2 >&2; exit 1", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	}
+
+	task := newTask()
+	task.StderrMode = OutputModeMerge
+	cmdErr := task.ExecuteCommand().(*CommandError)
+	assert.Equal(t, "out1\nerr1\nout2\nerr2\n", cmdErr.Stdout, "Stderr should be merged into stdout, in order")
+	assert.Equal(t, "", cmdErr.Stderr)
+
+	task = newTask()
+	task.StdoutMode = OutputModeDiscard
+	cmdErr = task.ExecuteCommand().(*CommandError)
+	assert.Equal(t, "", cmdErr.Stdout, "Stdout should be discarded")
+	assert.Equal(t, "err1\nerr2\n", cmdErr.Stderr)
+
+	task = newTask()
+	task.StdoutMode = OutputModeDiscard
+	task.StderrMode = OutputModeTee
+	task.TeeLogPath = "/tmp/outputmodes_logs/tee.log"
+	cmdErr = task.ExecuteCommand().(*CommandError)
+	assert.Equal(t, "err1\nerr2\n", cmdErr.Stderr, "Teed stderr should still be captured")
+	dat, err := ioutil.ReadFile("/tmp/outputmodes_logs/tee.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "err1\nerr2\n", string(dat), "Teed stderr should be written to the log file")
+
+	os.RemoveAll("/tmp/outputmodes_logs")
+}
+
+func TestMaxTasks(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestMaxTasksWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/maxtasks_" + t.Param("msg") + ".txt" })
+	echo.ParamPort("msg").ConnectStr("a", "b", "c", "d", "e", "f")
+	echo.MaxTasks = 2
+	var completedMx sync.Mutex
+	completed := 0
+	cat := wf.NewProc("cat", "cat {i:in} > {o:out}")
+	cat.SetPathExtend("in", "out", ".cat.txt")
+	cat.OnTaskComplete = func(task *SciTask, err error) {
+		completedMx.Lock()
+		defer completedMx.Unlock()
+		completed++
+	}
+	cat.In("in").Connect(echo.Out("out"))
+	wf.ConnectLast(cat.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, 2, completed, "Exactly MaxTasks tasks should run, also downstream")
+	for _, msg := range []string{"a", "b", "c", "d", "e", "f"} {
+		_, err := os.Stat("/tmp/maxtasks_" + msg + ".txt.cat.txt")
+		if msg == "a" || msg == "b" {
+			assert.Nil(t, err, "Output missing for: "+msg)
+		} else {
+			assert.True(t, os.IsNotExist(err), "No output should exist for: "+msg)
+		}
+	}
+	cleanFiles("/tmp/maxtasks_a.txt", "/tmp/maxtasks_b.txt", "/tmp/maxtasks_a.txt.cat.txt", "/tmp/maxtasks_b.txt.cat.txt")
+}
+
+func TestScript(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestScriptWf", 4)
+	wf.TempDir = "/tmp/script_tmp"
+	foo := wf.NewProc("foo", "echo foo > {o:out}")
+	foo.SetPathStatic("out", "/tmp/script_foo.txt")
+	greet := wf.NewProc("greet", `name='{p:name}'
+cat > {o:out} <<EOF
+Hello "$name"
+EOF
+cat {i:in} >> {o:out}`)
+	greet.Script = true
+	greet.SetPathStatic("out", "/tmp/script_out.txt")
+	greet.In("in").Connect(foo.Out("out"))
+	greet.ParamPort("name").ConnectStr("World")
+	wf.ConnectLast(greet.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/script_out.txt")
+	assert.Equal(t, "Hello \"World\"\nfoo\n", string(ip.Read()), "Wrong output of script")
+	assert.Contains(t, ip.GetAuditInfo().Command, "Hello \"$name\"\nEOF\ncat /tmp/script_foo.txt", "Rendered script not recorded in audit info")
+	scripts, _ := filepath.Glob("/tmp/script_tmp/scipipe-scripts/*")
+	assert.Empty(t, scripts, "Script files should be removed after execution")
+
+	cleanFiles("/tmp/script_foo.txt", "/tmp/script_out.txt", "/tmp/script_foo.txt.audit.json", "/tmp/script_out.txt.audit.json")
+	os.RemoveAll("/tmp/script_tmp")
+}
+
+func TestShell(t *testing.T) {
+	initTestLogs()
+	defaultShell := ShellCommand
+	defer func() { ShellCommand = defaultShell }()
+	ShellCommand = []string{"sh", "-c"}
+
+	wf := NewWorkflow("TestShellWf", 4)
+	dflt := wf.NewProc("default", "echo $0 > {o:out}")
+	dflt.SetPathStatic("out", "/tmp/shell_default.txt")
+	custom := wf.NewProc("custom", "echo $0 > {o:out}")
+	custom.Shell = []string{"bash", "-c"}
+	custom.SetPathStatic("out", "/tmp/shell_custom.txt")
+	wf.ConnectLast(dflt.Out("out"))
+	wf.ConnectLast(custom.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, "sh\n", string(NewInformationPacket("/tmp/shell_default.txt").Read()), "Command not executed with the package-level shell")
+	assert.Equal(t, "bash\n", string(NewInformationPacket("/tmp/shell_custom.txt").Read()), "Command not executed with the shell of the process")
+
+	cleanFiles("/tmp/shell_default.txt", "/tmp/shell_custom.txt", "/tmp/shell_default.txt.audit.json", "/tmp/shell_custom.txt.audit.json")
+}
+
+func TestShellWithCondaEnvAndBatch(t *testing.T) {
+	initTestLogs()
+
+	// A fake conda, executing the command given to "conda run" directly
+	condaDir := "/tmp/shell_fakeconda"
+	err := os.MkdirAll(condaDir, 0777)
+	assert.Nil(t, err)
+	defer os.RemoveAll(condaDir)
+	err = ioutil.WriteFile(condaDir+"/conda", []byte("#!/bin/sh\nshift 4\nexec \"$@\"\n"), 0755)
+	assert.Nil(t, err)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", condaDir+":"+os.Getenv("PATH"))
+
+	wf := NewWorkflow("TestShellWithCondaEnvAndBatchWf", 4)
+	conda := wf.NewProc("conda", "echo ${BASH_VERSION:-nobash} > {o:out}")
+	conda.SetPathStatic("out", "/tmp/shell_conda.txt")
+	conda.Shell = []string{"sh", "-c"}
+	conda.CondaEnv = "myenv"
+	batched := wf.NewProc("batched", "echo ${BASH_VERSION:-nobash} > {o:out}")
+	batched.SetPathStatic("out", "/tmp/shell_batched.txt")
+	batched.Shell = []string{"sh", "-c"}
+	batched.BatchExecutor = NewBatchExecutor(1, time.Millisecond)
+	wf.ConnectLast(conda.Out("out"))
+	wf.ConnectLast(batched.Out("out"))
+	wf.Run()
+
+	for _, path := range []string{"/tmp/shell_conda.txt", "/tmp/shell_batched.txt"} {
+		assert.Equal(t, "nobash\n", string(NewInformationPacket(path).Read()), "Command not executed with the shell of the process: "+path)
+		cleanFiles(path, path+".audit.json")
+	}
+}
+
+func TestOutputFileModeAndGroup(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestOutputFileModeWf", 4)
+	echo := wf.NewProc("echo", "echo hej > {o:out}")
+	echo.SetPathStatic("out", "/tmp/filemode_out.txt")
+	echo.OutputFileMode = 0600
+	echo.OutputGroup = strconv.Itoa(os.Getgid())
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	for _, path := range []string{"/tmp/filemode_out.txt", "/tmp/filemode_out.txt.audit.json"} {
+		fi, err := os.Stat(path)
+		if assert.Nil(t, err, "File missing: "+path) {
+			assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "Wrong mode of file: "+path)
+			assert.Equal(t, uint32(os.Getgid()), fi.Sys().(*syscall.Stat_t).Gid, "Wrong group of file: "+path)
+		}
+	}
+	cleanFiles("/tmp/filemode_out.txt", "/tmp/filemode_out.txt.audit.json")
+}
+
+func TestAggregate(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestAggregateWf", 4)
+	echo := wf.NewProc("echo", "echo {p:msg} > {o:out}")
+	echo.SetPathCustom("out", func(t *SciTask) string { return "/tmp/aggregate_" + t.Param("msg") + ".txt" })
+	echo.ParamPort("msg").ConnectStr("a", "b", "c")
+	summary := wf.NewProc("summary", "echo {i:in:r:,} > {o:list}; cat {i:in:r} > {o:out}")
+	summary.Aggregate = true
+	summary.SetPathStatic("list", "/tmp/aggregate_list.txt")
+	summary.SetPathStatic("out", "/tmp/aggregate_out.txt")
+	summary.In("in").Connect(echo.Out("out"))
+	wf.ConnectLast(summary.Out("list"))
+	wf.ConnectLast(summary.Out("out"))
+	wf.Run()
+
+	list := NewInformationPacket("/tmp/aggregate_list.txt")
+	assert.Equal(t, "/tmp/aggregate_a.txt,/tmp/aggregate_b.txt,/tmp/aggregate_c.txt\n", string(list.Read()), "All inputs should be expanded into the command, with the separator")
+	out := NewInformationPacket("/tmp/aggregate_out.txt")
+	assert.Equal(t, "a\nb\nc\n", string(out.Read()), "A single task should have concatenated all inputs")
+
+	cleanFiles("/tmp/aggregate_a.txt", "/tmp/aggregate_b.txt", "/tmp/aggregate_c.txt", "/tmp/aggregate_list.txt", "/tmp/aggregate_out.txt")
+	cleanFiles("/tmp/aggregate_a.txt.audit.json", "/tmp/aggregate_b.txt.audit.json", "/tmp/aggregate_c.txt.audit.json", "/tmp/aggregate_list.txt.audit.json", "/tmp/aggregate_out.txt.audit.json")
+}
+
+func TestFailOnStderrPattern(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestFailOnStderrPatternWf", 4)
+	wf.KeepGoing = true
+	buggy := wf.NewProc("buggy", "echo hej > {o:out}; echo 'ERROR: something went wrong' >&2; exit 0")
+	buggy.SetPathStatic("out", "/tmp/stderrpattern_out.txt")
+	buggy.FailOnStderrPattern = regexp.MustCompile(`ERROR`)
+	wf.ConnectLast(buggy.Out("out"))
+	err := wf.Run()
+
+	assert.NotNil(t, err, "Task printing an error on stderr should fail")
+	for _, f := range []string{"/tmp/stderrpattern_out.txt", "/tmp/stderrpattern_out.txt.tmp"} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Output of failed task should not be kept: "+f)
+	}
+
+	task := NewSciTask(wf, "ok", "echo 'no errors' >&2", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	task.FailOnStderrPattern = regexp.MustCompile(`ERROR`)
+	assert.Nil(t, task.ExecuteCommand(), "Task with non-matching stderr should not fail")
+}
+
+func TestCommandOutputAndExitStatus(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestCommandOutputAndExitStatusWf", 4)
+	wf.KeepGoing = true
+	failing := wf.NewProc("failing", "echo partial > {o:out}; echo hej; echo 'something went wrong' >&2; exit 3")
+	failing.SetPathStatic("out", "/tmp/exitstatus_out.txt")
+	var failedTask *SciTask
+	failing.OnTaskComplete = func(task *SciTask, err error) {
+		failedTask = task
+	}
+	wf.ConnectLast(failing.Out("out"))
+	err := wf.Run()
+
+	assert.NotNil(t, err, "Task exiting with a non-zero status should fail")
+	if assert.NotNil(t, failedTask) {
+		assert.Equal(t, 3, failedTask.ExitStatus)
+		assert.Equal(t, "hej\n", failedTask.Stdout)
+		assert.Equal(t, "something went wrong\n", failedTask.Stderr)
+	}
+	for _, f := range []string{"/tmp/exitstatus_out.txt", "/tmp/exitstatus_out.txt.tmp"} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Output of failed task should be removed: "+f)
+	}
+
+	task := NewSciTask(wf, "ok", "echo hej; echo hopp >&2", nil, nil, nil, nil, "", ExecModeLocal, 1)
+	assert.Nil(t, task.ExecuteCommand())
+	assert.Equal(t, 0, task.ExitStatus)
+	assert.Equal(t, "hej\n", task.Stdout)
+	assert.Equal(t, "hopp\n", task.Stderr)
+}
+
+func TestParamsFromInputs(t *testing.T) {
+	initTestLogs()
+	os.RemoveAll("/tmp/params_from_inputs")
+
+	wf := NewWorkflow("TestParamsFromInputsWf", 4)
+
+	// The first two samples come from a packet source, carrying their
+	// sample names as params, and the third one from an upstream process
+	src := NewFilePort()
+	write := wf.NewProc("write", "echo {p:sample} > {o:out}")
+	write.SetPathPattern("out", "/tmp/params_from_inputs/{p:sample}.txt")
+	write.ParamPort("sample").ConnectStr("c")
+
+	report := wf.NewProc("report", "echo {p:sample}: $(cat {i:in}){p:suffix} > {o:out}")
+	report.ParamsFromInputs = true
+	report.SetPathPattern("out", "/tmp/params_from_inputs/report_{p:sample}.txt")
+	report.In("in").Connect(src)
+	report.In("in").Connect(write.Out("out"))
+	report.ParamPort("suffix").ConnectStr("!", "!", "!")
+	wf.ConnectLast(report.Out("out"))
+
+	err := os.MkdirAll("/tmp/params_from_inputs", 0777)
+	assert.Nil(t, err)
+	go func() {
+		defer src.Close()
+		for _, sample := range []string{"a", "b"} {
+			ip := NewInformationPacket("/tmp/params_from_inputs/" + sample + ".txt")
+			ip.WriteTempFile([]byte(sample + "\n"))
+			ip.Atomize()
+			ip.AddParam("sample", sample)
+			src.Send(ip)
+		}
+	}()
+	wf.Run()
+
+	for _, sample := range []string{"a", "b", "c"} {
+		ip := NewInformationPacket("/tmp/params_from_inputs/report_" + sample + ".txt")
+		assert.True(t, ip.Exists(), "Report of sample %s is missing", sample)
+		if ip.Exists() {
+			assert.Equal(t, sample+": "+sample+"!\n", string(ip.Read()), "Wrong param values used for sample %s", sample)
+			assert.Equal(t, sample, ip.GetParam("sample"), "Param from input not recorded in audit info of output")
+		}
+	}
+
+	os.RemoveAll("/tmp/params_from_inputs")
+}
+
+func TestSetOutPortNoAtomize(t *testing.T) {
+	initTestLogs()
+	outPath := "/tmp/noatomize_out.txt"
+	cleanFiles(outPath, outPath+".idx", outPath+".audit.json")
+
+	wf := NewWorkflow("TestSetOutPortNoAtomizeWf", 4)
+	// The index file is named after the path that the command writes to
+	idx := wf.NewProc("index", "echo hello > {o:out}; touch {o:out}.idx")
+	idx.SetPathStatic("out", outPath)
+	idx.SetOutPortNoAtomize("out")
+	wf.ConnectLast(idx.Out("out"))
+
+	task := newSciTask(wf, "index", idx.CommandPattern, nil, idx.PathFormatters, idx.OutPortsDoStream, idx.outPortsNoAtom, nil, "", idx.ExecMode, 1)
+	assert.Equal(t, "echo hello > "+outPath+"; touch "+outPath+".idx", task.Command, "Final path should be used in command")
+
+	wf.Run()
+
+	assert.Equal(t, "hello\n", string(NewInformationPacket(outPath).Read()))
+	_, err := os.Stat(outPath + ".idx")
+	assert.Nil(t, err, "Index file should be created next to the final output")
+	cleanFiles(outPath, outPath+".idx", outPath+".audit.json")
+}
+
+func TestCollectMetaFrom(t *testing.T) {
+	initTestLogs()
+	outPath := "/tmp/collect_meta_out.txt"
+	badPath := "/tmp/collect_meta_bad.txt"
+	cleanFiles(outPath, outPath+".audit.json", badPath, badPath+".audit.json")
+
+	wf := NewWorkflow("TestCollectMetaFromWf", 4)
+	mapper := wf.NewProc("mapper", `echo mapped > {o:out}; echo '{"reads_mapped": 42, "tool": "bwa"}' > {o:out}.meta.json`)
+	mapper.SetPathStatic("out", outPath)
+	mapper.CollectMetaFrom("out")
+	wf.ConnectLast(mapper.Out("out"))
+
+	bad := wf.NewProc("bad", `echo bad > {o:out}; echo 'not json' > {o:out}.meta.json`)
+	bad.SetPathStatic("out", badPath)
+	bad.CollectMetaFrom("out")
+	wf.ConnectLast(bad.Out("out"))
+
+	wf.Run()
+
+	keys := NewInformationPacket(outPath).GetKeys()
+	assert.Equal(t, "42", keys["reads_mapped"], "Numeric value from meta file not added as key")
+	assert.Equal(t, "bwa", keys["tool"], "String value from meta file not added as key")
+	metaFiles, _ := filepath.Glob("/tmp/collect_meta_*.meta.json")
+	assert.Empty(t, metaFiles, "Meta files should be removed after being read")
+
+	assert.True(t, NewInformationPacket(badPath).Exists(), "Malformed meta file should not make the task fail")
+	assert.Empty(t, NewInformationPacket(badPath).GetKeys(), "No keys should be added from malformed meta file")
+
+	cleanFiles(outPath, outPath+".audit.json", badPath, badPath+".audit.json")
+}
+
+func TestResourceUsage(t *testing.T) {
+	initTestLogs()
+	outPath := "/tmp/resource_usage_out.txt"
+	cleanFiles(outPath, outPath+".audit.json")
+
+	wf := NewWorkflow("TestResourceUsageWf", 4)
+	busy := wf.NewProc("busy", "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done; echo $i > {o:out}")
+	busy.SetPathStatic("out", outPath)
+	wf.ConnectLast(busy.Out("out"))
+	wf.Run()
+
+	auditInfo := NewInformationPacket(outPath).GetAuditInfo()
+	assert.True(t, auditInfo.MaxRSSKB > 0, "Peak memory use should be recorded")
+	assert.True(t, auditInfo.UserTimeMS+auditInfo.SystemTimeMS > 0, "CPU time should be recorded")
+	cleanFiles(outPath, outPath+".audit.json")
+}
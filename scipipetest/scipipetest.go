@@ -0,0 +1,103 @@
+// Package scipipetest contains helpers for testing scipipe workflows without
+// executing any real shell commands.
+package scipipetest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+)
+
+// MockExecutor replaces the execution of shell commands, in all the processes
+// of a workflow, with a mock execution function. The mock records the
+// (formatted) commands that would have been executed, and synthesizes the
+// output files of each task, with content taken from a map of output paths,
+// or as empty files when no content is provided for a path.
+//
+// Note that streaming (FIFO) outputs are not supported by the mock.
+type MockExecutor struct {
+	OutputContents map[string][]byte
+	mx             sync.Mutex
+	commands       []string
+	outPaths       []string
+}
+
+// NewMockExecutor returns a new MockExecutor, without any output contents
+func NewMockExecutor() *MockExecutor {
+	return &MockExecutor{
+		OutputContents: make(map[string][]byte),
+	}
+}
+
+// SetOutputContent sets the content to be written to the output file with the
+// (final) path, whenever a task producing it is executed
+func (m *MockExecutor) SetOutputContent(path string, content string) {
+	m.OutputContents[path] = []byte(content)
+}
+
+// Mock replaces the execution of all the processes in the workflow wf with
+// the mock execution function. Note that any existing CustomExecute functions
+// are replaced too. This has to be done before the workflow is run.
+func (m *MockExecutor) Mock(wf *scipipe.Workflow) {
+	for _, proc := range wf.Procs() {
+		if sciProc, ok := proc.(*scipipe.SciProcess); ok {
+			sciProc.CustomExecute = m.execute
+		}
+	}
+}
+
+// Run mocks the workflow wf, and runs it
+func (m *MockExecutor) Run(wf *scipipe.Workflow) {
+	m.Mock(wf)
+	wf.Run()
+}
+
+func (m *MockExecutor) execute(t *scipipe.SciTask) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.commands = append(m.commands, t.Command)
+	for _, oip := range t.OutTargets {
+		oip.WriteTempFile(m.OutputContents[oip.GetPath()])
+		m.outPaths = append(m.outPaths, oip.GetPath())
+	}
+}
+
+// Commands returns the commands recorded so far, in the order they were
+// executed
+func (m *MockExecutor) Commands() []string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]string{}, m.commands...)
+}
+
+// OutPaths returns the (final) paths of all the outputs produced so far
+func (m *MockExecutor) OutPaths() []string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]string{}, m.outPaths...)
+}
+
+// AssertCommandExecuted checks that the command cmd was executed, and reports
+// an error on t otherwise
+func (m *MockExecutor) AssertCommandExecuted(t testing.TB, cmd string) bool {
+	for _, c := range m.Commands() {
+		if c == cmd {
+			return true
+		}
+	}
+	t.Errorf("Command was not executed: %s\nExecuted commands: %v", cmd, m.Commands())
+	return false
+}
+
+// AssertOutPathProduced checks that an output with the (final) path was
+// produced, and reports an error on t otherwise
+func (m *MockExecutor) AssertOutPathProduced(t testing.TB, path string) bool {
+	for _, p := range m.OutPaths() {
+		if p == path {
+			return true
+		}
+	}
+	t.Errorf("Output was not produced: %s\nProduced outputs: %v", path, m.OutPaths())
+	return false
+}
@@ -0,0 +1,40 @@
+package scipipetest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scipipe/scipipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockExecutor(t *testing.T) {
+	scipipe.InitLogWarning()
+
+	wf := scipipe.NewWorkflow("TestMockExecutorWf", 4)
+	foo := wf.NewProc("foo", "echo foo > {o:foo}")
+	foo.SetPathStatic("foo", "/tmp/scipipetest_foo.txt")
+	f2b := wf.NewProc("f2b", "sed 's/foo/bar/g' {i:foo} > {o:bar}")
+	f2b.SetPathExtend("foo", "bar", ".bar.txt")
+	f2b.In("foo").Connect(foo.Out("foo"))
+	wf.ConnectLast(f2b.Out("bar"))
+
+	mock := NewMockExecutor()
+	mock.SetOutputContent("/tmp/scipipetest_foo.txt", "foo\n")
+	mock.Run(wf)
+
+	mock.AssertCommandExecuted(t, "echo foo > /tmp/scipipetest_foo.txt.tmp")
+	mock.AssertCommandExecuted(t, "sed 's/foo/bar/g' /tmp/scipipetest_foo.txt > /tmp/scipipetest_foo.txt.bar.txt.tmp")
+	mock.AssertOutPathProduced(t, "/tmp/scipipetest_foo.txt")
+	mock.AssertOutPathProduced(t, "/tmp/scipipetest_foo.txt.bar.txt")
+
+	dat, err := ioutil.ReadFile("/tmp/scipipetest_foo.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo\n", string(dat), "Wrong content in synthesized output")
+
+	for _, f := range []string{"/tmp/scipipetest_foo.txt", "/tmp/scipipetest_foo.txt.bar.txt"} {
+		os.Remove(f)
+		os.Remove(f + ".audit.json")
+	}
+}
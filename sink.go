@@ -30,7 +30,7 @@ func (p *Sink) Name() string {
 
 // Execute the Sink component
 func (p *Sink) Run() {
-	go p.inPort.RunMergeInputs()
+	p.inPort.startMergeInputs()
 	for ip := range p.inPort.InChan {
 		Debug.Printf("Got file in sink: %s\n", ip.GetPath())
 	}
@@ -0,0 +1,176 @@
+package scipipe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	str "strings"
+)
+
+// ================== SLURM ==================
+
+// SlurmExecutor executes the commands of tasks as jobs on a HPC cluster, by
+// submitting them to the SLURM resource manager with sbatch, and waiting for
+// the jobs to finish. The cluster nodes have to share the file system, and the
+// working directory, with the computer the workflow is run on, since the
+// commands read and write the files of the workflow directly.
+//
+// Each command is submitted with its job name set to the name of the task,
+// and the number of CPUs per task set to the CoresPerTask of the process, if
+// larger than one. The command is executed in the shell of the task (see
+// ShellCommand), in the conda environment and with the environment modules of
+// the task, if any. When the workflow is cancelled (see RunWithContext), the
+// running jobs are cancelled with scancel.
+//
+// Processes using features that only work for commands executed locally
+// (Script, Sandbox, CommandAlternatives, FailOnStderrPattern, and other
+// StdoutMode and StderrMode than OutputModeCapture) can not be executed with
+// it, which makes the validation of the workflow fail.
+type SlurmExecutor struct {
+	// Args are additional arguments to sbatch, such as "--partition=core",
+	// "--account=myproject" or "--time=1:00:00"
+	Args []string
+	// Sbatch and Scancel are the programs used for submitting and cancelling
+	// jobs. They default to "sbatch" and "scancel".
+	Sbatch  string
+	Scancel string
+}
+
+func newSlurmExecutor(opts ExecutorOptions) (TaskExecutor, error) {
+	if err := opts.Check("args", "sbatch", "scancel"); err != nil {
+		return nil, err
+	}
+	return &SlurmExecutor{
+		Args:    str.Fields(opts["args"]),
+		Sbatch:  opts["sbatch"],
+		Scancel: opts["scancel"],
+	}, nil
+}
+
+// slurmExecutor returns the executor of tasks with ExecModeSLURM: the
+// executor of the workflow, if it is a SlurmExecutor, and otherwise one with
+// default settings
+func (wf *Workflow) slurmExecutor() *SlurmExecutor {
+	if e, ok := wf.executor.(*SlurmExecutor); ok {
+		return e
+	}
+	return &SlurmExecutor{}
+}
+
+// Supports returns an error if the process p uses features that can not be
+// used with SLURM jobs
+func (e *SlurmExecutor) Supports(p *SciProcess) error {
+	unsupported := []string{}
+	if p.Script {
+		unsupported = append(unsupported, "Script")
+	}
+	if p.Sandbox {
+		unsupported = append(unsupported, "Sandbox")
+	}
+	if len(p.CommandAlternatives) > 0 {
+		unsupported = append(unsupported, "CommandAlternatives")
+	}
+	if p.FailOnStderrPattern != nil {
+		unsupported = append(unsupported, "FailOnStderrPattern")
+	}
+	if p.StdoutMode != OutputModeCapture || p.StderrMode != OutputModeCapture {
+		unsupported = append(unsupported, "other StdoutMode and StderrMode than OutputModeCapture")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Process %s: Can not be executed with SLURM, since it uses: %s", p.name, str.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Execute submits the command of t as a SLURM job, and waits for it to
+// finish. The exit status of the job is set on t, and a CommandError is
+// returned if it failed.
+func (e *SlurmExecutor) Execute(t *SciTask) error {
+	sbatch := e.Sbatch
+	if sbatch == "" {
+		sbatch = "sbatch"
+	}
+	// With --wait, sbatch exits when the job has finished, with the exit
+	// status of the job, while --parsable makes it print the job ID only
+	args := []string{"--parsable", "--wait", "--job-name=" + t.Name}
+	if t.cores > 1 {
+		args = append(args, "--cpus-per-task="+strconv.Itoa(t.cores))
+	}
+	args = append(args, e.Args...)
+	args = append(args, "--wrap="+shellCommandLine(t.shell(), t.envCommand()))
+
+	Audit.Printf("Task:%-12s [%s] Submitting command to SLURM: %s\n", t.Name, t.ID, t.envCommand())
+	// On cancellation, the job is cancelled with scancel, since killing sbatch
+	// would leave the job running on the cluster
+	command := exec.Command(sbatch, args...)
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &bytes.Buffer{}
+	command.Stderr = stderr
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("Could not submit SLURM job with %s: %s", sbatch, err)
+	}
+
+	jobIDs := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		if scanner.Scan() {
+			// The output is on the form "<job ID>[;<cluster name>]"
+			jobIDs <- str.Split(str.TrimSpace(scanner.Text()), ";")[0]
+		}
+		for scanner.Scan() {
+		}
+		done <- command.Wait()
+	}()
+
+	jobID := ""
+	cancelled := false
+	workflowDone := t.workflow.ctx.Done()
+	for {
+		select {
+		case jobID = <-jobIDs:
+			taskLogf(Info, "Task:%-12s Submitted SLURM job %s [%s]\n", t.Name, jobID, t.Command)
+			if cancelled {
+				e.cancel(t, jobID)
+			}
+		case <-workflowDone:
+			workflowDone = nil
+			cancelled = true
+			if jobID != "" {
+				e.cancel(t, jobID)
+			}
+		case err := <-done:
+			t.Stderr = stderr.String()
+			t.ExitStatus = 0
+			if err == nil {
+				return nil
+			}
+			t.ExitStatus = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				t.ExitStatus = exitErr.ExitCode()
+			}
+			return &CommandError{
+				Command:  t.Command,
+				ExitCode: t.ExitStatus,
+				Stderr:   fmt.Sprintf("SLURM job %s failed: %s", jobID, t.Stderr),
+			}
+		}
+	}
+}
+
+// cancel cancels the SLURM job jobID of t
+func (e *SlurmExecutor) cancel(t *SciTask, jobID string) {
+	scancel := e.Scancel
+	if scancel == "" {
+		scancel = "scancel"
+	}
+	Warning.Printf("Task:%-12s Cancelling SLURM job %s\n", t.Name, jobID)
+	if out, err := exec.Command(scancel, jobID).CombinedOutput(); err != nil {
+		Warning.Printf("Task:%-12s Could not cancel SLURM job %s: %s %s\n", t.Name, jobID, err, out)
+	}
+}
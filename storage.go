@@ -0,0 +1,168 @@
+package scipipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	str "strings"
+	"sync"
+)
+
+// ================== Storage ==================
+
+// Storage is a backend for storing files at remote locations, identified by
+// URLs, such as in object storage like S3
+type Storage interface {
+	// Upload uploads the local file at localPath to remoteURL
+	Upload(ctx context.Context, localPath string, remoteURL string) error
+	// Download downloads the file at remoteURL to localPath
+	Download(ctx context.Context, remoteURL string, localPath string) error
+}
+
+var (
+	// storages contains the available storage backends, by URL scheme
+	storages = map[string]Storage{
+		"file": &LocalStorage{},
+		"s3":   &S3Storage{},
+	}
+	storagesMx sync.RWMutex
+)
+
+// RegisterStorage makes the storage backend available for URLs with the
+// scheme (such as "s3" for URLs starting with "s3://"), replacing any existing
+// backend for the scheme
+func RegisterStorage(scheme string, storage Storage) {
+	storagesMx.Lock()
+	defer storagesMx.Unlock()
+	storages[scheme] = storage
+}
+
+// StorageForURL returns the storage backend for the scheme of url
+func StorageForURL(url string) (Storage, error) {
+	parts := str.SplitN(url, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Not a URL with a scheme: %s", url)
+	}
+	storagesMx.RLock()
+	storage, ok := storages[parts[0]]
+	storagesMx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("No storage backend for URL scheme '%s', in URL: %s", parts[0], url)
+	}
+	return storage, nil
+}
+
+// LocalStorage stores files in the local file system, with URLs such as
+// file:///path/to/file
+type LocalStorage struct{}
+
+func (s *LocalStorage) Upload(ctx context.Context, localPath string, remoteURL string) error {
+	return copyFile(localPath, str.TrimPrefix(remoteURL, "file://"))
+}
+
+func (s *LocalStorage) Download(ctx context.Context, remoteURL string, localPath string) error {
+	return copyFile(str.TrimPrefix(remoteURL, "file://"), localPath)
+}
+
+func copyFile(fromPath string, toPath string) error {
+	err := os.MkdirAll(filepath.Dir(toPath), 0777)
+	if err != nil {
+		return err
+	}
+	from, err := os.Open(fromPath)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := os.Create(toPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(to, from); err != nil {
+		to.Close()
+		return err
+	}
+	return to.Close()
+}
+
+// S3Storage stores files in AWS S3 (or compatible object storage), with URLs
+// such as s3://bucket/prefix/file, using the aws command line tool, which has
+// to be installed and configured with credentials
+type S3Storage struct{}
+
+func (s *S3Storage) Upload(ctx context.Context, localPath string, remoteURL string) error {
+	return runAWSCopy(ctx, localPath, remoteURL)
+}
+
+func (s *S3Storage) Download(ctx context.Context, remoteURL string, localPath string) error {
+	return runAWSCopy(ctx, remoteURL, localPath)
+}
+
+func runAWSCopy(ctx context.Context, from string, to string) error {
+	out, err := exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", from, to).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Could not copy %s to %s: %s\nOutput:\n%s", from, to, err, string(out))
+	}
+	return nil
+}
+
+// --------------- Remote outputs of tasks ----------------
+
+// uploadRemoteOutputs uploads the (still temporary) output files of the task,
+// for out-ports with a remote location set, to their remote URLs, together
+// with their audit files. This is done before atomizing, so that outputs that
+// failed to upload are never promoted to their final paths.
+func (t *SciTask) uploadRemoteOutputs() error {
+	for oname, remotePrefix := range t.remoteOutPrefixes {
+		oip, ok := t.OutTargets[oname]
+		if !ok || oip.doStream {
+			continue
+		}
+		remoteURL := remoteURLForPath(remotePrefix, oip.GetPath())
+		storage, err := StorageForURL(remoteURL)
+		if err != nil {
+			return err
+		}
+		Audit.Printf("Task:%-12s Uploading output %s to %s\n", t.Name, oip.GetPath(), remoteURL)
+		if err := storage.Upload(t.workflow.ctx, oip.GetTempPath(), remoteURL); err != nil {
+			return fmt.Errorf("Upload of output failed: %s", err)
+		}
+		if auditFilePath, ok := oip.auditStore().ownFile(oip.GetPath()); ok {
+			remoteAuditURL := remoteURL + str.TrimPrefix(auditFilePath, oip.GetPath())
+			if err := storage.Upload(t.workflow.ctx, auditFilePath, remoteAuditURL); err != nil {
+				return fmt.Errorf("Upload of audit file failed: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// setRemoteOutPaths sets the paths of the outputs uploaded to remote locations
+// to their remote URLs
+func (t *SciTask) setRemoteOutPaths() {
+	for oname, remotePrefix := range t.remoteOutPrefixes {
+		if oip, ok := t.OutTargets[oname]; ok && !oip.doStream {
+			oip.SetPath(remoteURLForPath(remotePrefix, oip.GetPath()))
+		}
+	}
+}
+
+// remoteURLForPath returns the URL under remotePrefix for the output at path.
+// Relative paths are kept as they are under the prefix, so that outputs with
+// the same file name in different directories do not overwrite each other,
+// while absolute paths, and relative paths outside of the working directory,
+// are kept in full, without the leading separator.
+func remoteURLForPath(remotePrefix string, path string) string {
+	relPath := filepath.Clean(path)
+	if filepath.IsAbs(relPath) || relPath == ".." || str.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		absPath, err := filepath.Abs(relPath)
+		if err == nil {
+			relPath = absPath
+		}
+		relPath = str.TrimPrefix(relPath, string(filepath.Separator))
+	}
+	return str.TrimSuffix(remotePrefix, "/") + "/" + filepath.ToSlash(relPath)
+}
@@ -0,0 +1,68 @@
+package scipipe
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOutPortRemote(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestSetOutPortRemoteWf", 4)
+	echo := wf.NewProc("echo", "echo hej > {o:out}")
+	echo.SetPathStatic("out", "/tmp/outportremote.txt")
+	echo.SetOutPortRemote("out", "file:///tmp/outportremote_dir/")
+
+	inPort := NewFilePort()
+	inPort.Connect(echo.Out("out"))
+	go echo.Run()
+	ip := inPort.Recv()
+
+	assert.Equal(t, "file:///tmp/outportremote_dir/tmp/outportremote.txt", ip.GetPath(), "Path of packet should be the remote URL")
+	remote := NewInformationPacket("/tmp/outportremote_dir/tmp/outportremote.txt")
+	assert.Equal(t, "hej\n", string(remote.Read()), "Wrong content in uploaded file")
+	_, err := os.Stat("/tmp/outportremote_dir/tmp/outportremote.txt.audit.json")
+	assert.Nil(t, err, "Audit file not uploaded")
+
+	cleanFiles("/tmp/outportremote.txt")
+	os.RemoveAll("/tmp/outportremote_dir")
+}
+
+func TestRemoteURLForPath(t *testing.T) {
+	assert.Equal(t, "s3://bucket/prefix/sample1/out.txt", remoteURLForPath("s3://bucket/prefix/", "sample1/out.txt"))
+	assert.Equal(t, "s3://bucket/prefix/sample2/out.txt", remoteURLForPath("s3://bucket/prefix", "./sample2/out.txt"), "Outputs with the same name in different directories should get different URLs")
+	assert.Equal(t, "s3://bucket/prefix/data/out.txt", remoteURLForPath("s3://bucket/prefix/", "/data/out.txt"), "Absolute paths should be kept in full")
+	assert.NotContains(t, remoteURLForPath("s3://bucket/prefix/", "../out.txt"), "..", "Paths outside of the working directory should not escape the prefix")
+}
+
+type failingStorage struct{}
+
+func (s *failingStorage) Upload(ctx context.Context, localPath string, remoteURL string) error {
+	return errors.New("upload failed")
+}
+
+func (s *failingStorage) Download(ctx context.Context, remoteURL string, localPath string) error {
+	return errors.New("download failed")
+}
+
+func TestUploadRemoteOutputs_FailureDoesNotPromote(t *testing.T) {
+	initTestLogs()
+	RegisterStorage("failing", &failingStorage{})
+	defer delete(storages, "failing")
+
+	wf := NewWorkflow("TestUploadFailureWf", 4)
+	task := NewSciTask(wf, "echo", "echo hej > {o:out}", nil, map[string]func(*SciTask) string{"out": func(*SciTask) string { return "/tmp/uploadfailure.txt" }}, nil, nil, "", ExecModeLocal, 1)
+	task.remoteOutPrefixes = map[string]string{"out": "failing://bucket/"}
+
+	err := task.ExecuteCommand()
+	assert.Nil(t, err)
+	err = task.uploadRemoteOutputs()
+	assert.NotNil(t, err, "Failing upload should return error")
+	assert.False(t, task.OutTargets["out"].Exists(), "Output should not be atomized")
+
+	os.Remove(task.OutTargets["out"].GetTempPath())
+}
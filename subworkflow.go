@@ -0,0 +1,187 @@
+package scipipe
+
+import (
+	"sort"
+	"sync"
+)
+
+// ----------------------------------------------------------------------------
+// SubWorkflow
+// ----------------------------------------------------------------------------
+
+// SubWorkflow is a set of connected processes, with selected ports of the
+// inner processes exposed as its own ports, which is added to a parent
+// workflow as a single process. This makes it possible to define a part of a
+// pipeline once, typically in a function creating a SubWorkflow, and use it
+// several times in the same or different workflows, connecting it like any
+// other process.
+//
+// The processes of the sub-workflow are added to it, rather than to the parent
+// workflow, with NewProc or AddProc, or by passing Workflow() to the
+// constructors of components. Their names only have to be unique within the
+// sub-workflow. The exposed ports are the ports of the inner processes
+// themselves, so that connecting to them connects directly to the inner
+// processes. Each inner port can only be exposed once, and ports of inner
+// processes that are not exposed have to be connected within the
+// sub-workflow.
+//
+// When the sub-workflow is run by the parent workflow, all of its processes
+// are run, and the tasks of its SciProcesses are executed as part of the
+// parent workflow, sharing its settings and its limit of concurrent tasks, and
+// reporting failures to it. The sub-workflow finishes when all of its
+// processes have finished.
+type SubWorkflow struct {
+	name       string
+	parent     *Workflow
+	inner      *Workflow
+	inPorts    map[string]*FilePort
+	outPorts   map[string]*FilePort
+	paramPorts map[string]*ParamPort
+}
+
+// NewSubWorkflow creates a new, empty, sub-workflow, and adds it to the parent
+// workflow
+func NewSubWorkflow(parent *Workflow, name string) *SubWorkflow {
+	s := &SubWorkflow{
+		name:       name,
+		parent:     parent,
+		inner:      NewWorkflow(name, cap(parent.concurrentTasks)),
+		inPorts:    map[string]*FilePort{},
+		outPorts:   map[string]*FilePort{},
+		paramPorts: map[string]*ParamPort{},
+	}
+	parent.AddProc(s)
+	return s
+}
+
+// Name returns the name of the sub-workflow
+func (s *SubWorkflow) Name() string {
+	return s.name
+}
+
+// Workflow returns the inner workflow of the sub-workflow, which the
+// processes of the sub-workflow are added to, such as by passing it to the
+// constructors of components. It should not be run on its own.
+func (s *SubWorkflow) Workflow() *Workflow {
+	return s.inner
+}
+
+// NewProc creates a new SciProcess in the sub-workflow, like Workflow.NewProc
+func (s *SubWorkflow) NewProc(procName string, commandPattern string) *SciProcess {
+	return s.inner.NewProc(procName, commandPattern)
+}
+
+// AddProc adds the process proc to the sub-workflow
+func (s *SubWorkflow) AddProc(proc Process) {
+	s.inner.AddProc(proc)
+}
+
+// Procs returns the processes in the sub-workflow, by name
+func (s *SubWorkflow) Procs() map[string]Process {
+	return s.inner.Procs()
+}
+
+// ExposeIn exposes the in-port port, of an inner process, as the in-port
+// portName of the sub-workflow
+func (s *SubWorkflow) ExposeIn(portName string, port *FilePort) *SubWorkflow {
+	if _, ok := s.inPorts[portName]; ok {
+		Error.Fatalf("Sub-workflow %s: In-port %s is already exposed\n", s.name, portName)
+	}
+	s.inPorts[portName] = port
+	return s
+}
+
+// ExposeOut exposes the out-port port, of an inner process, as the out-port
+// portName of the sub-workflow
+func (s *SubWorkflow) ExposeOut(portName string, port *FilePort) *SubWorkflow {
+	if _, ok := s.outPorts[portName]; ok {
+		Error.Fatalf("Sub-workflow %s: Out-port %s is already exposed\n", s.name, portName)
+	}
+	s.outPorts[portName] = port
+	return s
+}
+
+// ExposeParam exposes the parameter port port, of an inner process, as the
+// parameter port portName of the sub-workflow
+func (s *SubWorkflow) ExposeParam(portName string, port *ParamPort) *SubWorkflow {
+	if _, ok := s.paramPorts[portName]; ok {
+		Error.Fatalf("Sub-workflow %s: Param port %s is already exposed\n", s.name, portName)
+	}
+	s.paramPorts[portName] = port
+	return s
+}
+
+// In returns the exposed in-port portName
+func (s *SubWorkflow) In(portName string) *FilePort {
+	if s.inPorts[portName] == nil {
+		Error.Fatalf("Sub-workflow %s: No such in-port: %s\n", s.name, portName)
+	}
+	return s.inPorts[portName]
+}
+
+// Out returns the exposed out-port portName
+func (s *SubWorkflow) Out(portName string) *FilePort {
+	if s.outPorts[portName] == nil {
+		Error.Fatalf("Sub-workflow %s: No such out-port: %s\n", s.name, portName)
+	}
+	return s.outPorts[portName]
+}
+
+// ParamPort returns the exposed parameter port portName
+func (s *SubWorkflow) ParamPort(portName string) *ParamPort {
+	if s.paramPorts[portName] == nil {
+		Error.Fatalf("Sub-workflow %s: No such param port: %s\n", s.name, portName)
+	}
+	return s.paramPorts[portName]
+}
+
+// GetInPorts returns the exposed in-ports, by name
+func (s *SubWorkflow) GetInPorts() map[string]*FilePort {
+	return s.inPorts
+}
+
+// GetOutPorts returns the exposed out-ports, by name
+func (s *SubWorkflow) GetOutPorts() map[string]*FilePort {
+	return s.outPorts
+}
+
+// GetParamPorts returns the exposed parameter ports, by name
+func (s *SubWorkflow) GetParamPorts() map[string]*ParamPort {
+	return s.paramPorts
+}
+
+// IsConnected checks that all the ports of all the inner processes are
+// connected, including the exposed ones, which are connected in the parent
+// workflow
+func (s *SubWorkflow) IsConnected() bool {
+	isConnected := true
+	procNames := []string{}
+	for procName := range s.inner.procs {
+		procNames = append(procNames, procName)
+	}
+	sort.Strings(procNames)
+	for _, procName := range procNames {
+		if !s.inner.procs[procName].IsConnected() {
+			Error.Printf("Sub-workflow %s: Process %s is not connected\n", s.name, procName)
+			isConnected = false
+		}
+	}
+	return isConnected
+}
+
+// Run runs all the processes of the sub-workflow, with their tasks executed
+// as part of the parent workflow, and returns when all of them have finished
+func (s *SubWorkflow) Run() {
+	wg := sync.WaitGroup{}
+	for _, proc := range s.inner.procs {
+		if sciProc, ok := proc.(*SciProcess); ok {
+			sciProc.workflow = s.parent
+		}
+		wg.Add(1)
+		go func(proc Process) {
+			defer wg.Done()
+			proc.Run()
+		}(proc)
+	}
+	wg.Wait()
+}
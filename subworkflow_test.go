@@ -0,0 +1,50 @@
+package scipipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTrimSubWorkflow creates a sub-workflow that keeps the first three
+// characters of each line, and upper-cases them
+func newTrimSubWorkflow(wf *Workflow, name string) *SubWorkflow {
+	sub := NewSubWorkflow(wf, name)
+	trim := sub.NewProc("trim", "cut -c 1-3 {i:in} > {o:out}")
+	trim.SetPathExtend("in", "out", ".trim")
+	upper := sub.NewProc("upper", "tr a-z A-Z < {i:in} > {o:out}")
+	upper.SetPathExtend("in", "out", ".upper")
+	upper.In("in").Connect(trim.Out("out"))
+	sub.ExposeIn("in", trim.In("in"))
+	sub.ExposeOut("out", upper.Out("out"))
+	return sub
+}
+
+func TestSubWorkflow(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestSubWorkflowWf", 4)
+	for _, sample := range []string{"a", "b"} {
+		write := wf.NewProc("write_"+sample, "echo "+sample+"bcdef > {o:out}")
+		write.SetPathStatic("out", "/tmp/subwf_"+sample+".txt")
+		trim := newTrimSubWorkflow(wf, "trim_"+sample)
+		trim.In("in").Connect(write.Out("out"))
+		wf.ConnectLast(trim.Out("out"))
+	}
+	assert.Nil(t, wf.Validate(), "Workflow with sub-workflows should be valid")
+
+	err := wf.Run()
+	assert.Nil(t, err)
+
+	for _, sample := range []string{"a", "b"} {
+		path := "/tmp/subwf_" + sample + ".txt.trim.upper"
+		ip := NewInformationPacket(path)
+		assert.True(t, ip.Exists(), "Output of sub-workflow missing: %s", path)
+		if ip.Exists() {
+			assert.Equal(t, strings.ToUpper(sample)+"BC\n", string(ip.Read()), "Wrong output of sub-workflow")
+		}
+		cleanFiles("/tmp/subwf_"+sample+".txt", "/tmp/subwf_"+sample+".txt.trim", path)
+		cleanFiles("/tmp/subwf_"+sample+".txt.audit.json", "/tmp/subwf_"+sample+".txt.trim.audit.json", path+".audit.json")
+	}
+}
@@ -1,19 +1,35 @@
 package scipipe
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	str "strings"
+	"syscall"
 	"time"
 )
 
 // ================== SciTask ==================
 
 type SciTask struct {
-	Name          string
+	Name string
+	// ID identifies the task, stably across runs (see taskID for how it is
+	// computed), for correlating log lines and audit info, which is where it
+	// is exposed, as there is no separate events channel
+	ID            string
 	Command       string
 	ExecMode      ExecMode
 	CustomExecute func(*SciTask)
@@ -25,9 +41,92 @@ type SciTask struct {
 	DataFolder    string
 	workflow      *Workflow
 	cores         int
+	// RerunIfInputsNewer makes the task execute even if its outputs exist,
+	// when they are older than the newest of its inputs
+	RerunIfInputsNewer bool
+	// RerunIfChanged makes the task execute even if its outputs exist, when
+	// its command or inputs have changed since they were created
+	RerunIfChanged bool
+	// BatchExecutor, if set, executes the command together with the
+	// commands of other tasks, as one batch
+	BatchExecutor *BatchExecutor
+	// Executor, if set, executes the command, instead of the executor of the
+	// workflow, or the one of the ExecMode
+	Executor TaskExecutor
+	// OnTaskComplete, if set, is called after the task has been executed
+	OnTaskComplete func(t *SciTask, err error)
+	// CondaEnv is the name of a conda environment to execute the command in
+	CondaEnv string
+	// Modules are environment modules to load before executing the command
+	Modules []string
+	// CommandAlternatives are formatted commands to fall back to, in order,
+	// if the command fails
+	CommandAlternatives []string
+	// RequireNonEmptyOutputs makes the task fail if any of its non-streaming
+	// outputs is empty after the command has finished
+	RequireNonEmptyOutputs bool
+	// Sandbox makes the command execute with a sandbox mounted over the
+	// working directory, exposing only the declared inputs
+	Sandbox bool
+	// Script makes the command be written to a script file, which is
+	// executed with the program of the shell (bash by default)
+	Script bool
+	// Shell, if set, is the shell that the command is executed with,
+	// overriding ShellCommand
+	Shell []string
+	// OutputFileMode, if set, is the file mode that the outputs, and their
+	// audit files, are changed to when atomized
+	OutputFileMode os.FileMode
+	// OutputGroup, if set, is the group (name or ID) that the ownership of
+	// the outputs, and their audit files, is changed to when atomized
+	OutputGroup string
+	// FailOnStderrPattern, if set, makes the task fail if the stderr of the
+	// command matches it, even if the command exits successfully
+	FailOnStderrPattern *regexp.Regexp
+	// StdoutMode and StderrMode specify what is done with the stdout and
+	// stderr of the command
+	StdoutMode OutputMode
+	StderrMode OutputMode
+	// Stdout and Stderr are the output of the command, captured according
+	// to StdoutMode and StderrMode (with OutputModeMerge, both are in Stdout),
+	// and ExitStatus is its exit status (-1 if it did not exit normally, such
+	// as when killed by a signal), set after the command has been executed
+	Stdout     string
+	Stderr     string
+	ExitStatus int
+	// TeeLogPath is the path of the log file to which the output of streams
+	// in OutputModeTee is written, if any
+	TeeLogPath string
+	// Usage is the resources used by the command of the task, after it has
+	// been executed locally
+	Usage ResourceUsage
+	// Devices are the devices acquired from the device pools of the task,
+	// while executing, by the environment variables of the pools
+	Devices     map[string]string
+	devicePools []*DevicePool
+	// processSlots, if set, is the semaphore limiting the concurrent tasks of
+	// the process, in which a slot has been taken for the task, to release
+	// when it has finished
+	processSlots      chan struct{}
+	nonEmptyOutPorts  map[string]bool
+	metaOutPorts      map[string]bool
+	remoteOutPrefixes map[string]string
+	scratchPaths      map[string]string
+	globals           map[string]string
+	prepend           string
+	cancelled         bool
+	failed            bool
+	// skipped is set for tasks for which RunIf of the process returned false
+	skipped bool
 }
 
 func NewSciTask(workflow *Workflow, name string, cmdPat string, inTargets map[string]*InformationPacket, outPathFuncs map[string]func(*SciTask) string, outPortsDoStream map[string]bool, params map[string]string, prepend string, execMode ExecMode, cores int) *SciTask {
+	return newSciTask(workflow, name, cmdPat, inTargets, outPathFuncs, outPortsDoStream, nil, params, prepend, execMode, cores)
+}
+
+// newSciTask creates a new SciTask, like NewSciTask, where the outputs of the
+// out-ports in outPortsNoAtomize are written directly to their final paths
+func newSciTask(workflow *Workflow, name string, cmdPat string, inTargets map[string]*InformationPacket, outPathFuncs map[string]func(*SciTask) string, outPortsDoStream map[string]bool, outPortsNoAtomize map[string]bool, params map[string]string, prepend string, execMode ExecMode, cores int) *SciTask {
 	t := &SciTask{
 		Name:       name,
 		InTargets:  inTargets,
@@ -38,23 +137,28 @@ func NewSciTask(workflow *Workflow, name string, cmdPat string, inTargets map[st
 		Done:       make(chan int),
 		workflow:   workflow,
 		cores:      cores,
+		prepend:    prepend,
 	}
 
 	// Create out targets
-	Debug.Printf("Task:%s: Creating outTargets now ... [%s]", name, cmdPat)
+	taskLogf(Debug, "Task:%s: Creating outTargets now ... [%s]", name, cmdPat)
 	outTargets := make(map[string]*InformationPacket)
 	for oname, ofun := range outPathFuncs {
-		opath := ofun(t)
-		otgt := NewInformationPacket(opath)
+		opath := replaceRunID(ofun(t), workflow.RunID)
+		otgt := workflow.NewInformationPacket(opath)
 		if outPortsDoStream[oname] {
 			otgt.doStream = true
 		}
-		Debug.Printf("Task:%s: Creating outTarget with path %s ...\n", name, otgt.GetPath())
+		if outPortsNoAtomize[oname] {
+			otgt.noAtomize = true
+		}
+		taskLogf(Debug, "Task:%s: Creating outTarget with path %s ...\n", name, otgt.GetPath())
 		outTargets[oname] = otgt
 	}
 	t.OutTargets = outTargets
-	t.Command = formatCommand(cmdPat, inTargets, outTargets, params, prepend)
-	Debug.Printf("Task:%s: Created formatted command: %s [%s]", name, t.Command, cmdPat)
+	t.ID = taskID(name, cmdPat, inTargets, outTargets, params)
+	t.Command = t.formatTaskCommand(cmdPat)
+	taskLogf(Debug, "Task:%s: Created formatted command: %s [%s]", name, t.Command, cmdPat)
 	return t
 }
 
@@ -79,8 +183,20 @@ func (t *SciTask) Param(portName string) string {
 func (t *SciTask) Execute() {
 	defer close(t.Done)
 
-	if !t.anyOutputExists() && t.allFifosInOutTargetsExist() {
-		Debug.Printf("Task:%-12s Executing task. [%s]\n", t.Name, t.Command)
+	if t.workflow.isCancelled() {
+		taskLogf(Debug, "Task:%-12s Workflow cancelled, so not executing task. [%s]\n", t.Name, t.Command)
+		t.cancelled = true
+	} else if t.workflow.isStopped() {
+		taskLogf(Debug, "Task:%-12s Workflow stopped, so not executing task. [%s]\n", t.Name, t.Command)
+		t.cancelled = true
+	} else if t.workflow.DryRun {
+		if t.anyOutputExists() {
+			Info.Printf("Task:%-12s Dry run: Outputs already exist, so would skip command: %s\n", t.Name, t.Command)
+		} else {
+			Info.Printf("Task:%-12s Dry run: Would execute command: %s\n", t.Name, t.Command)
+		}
+	} else if !t.anyOutputExists() && t.allFifosInOutTargetsExist() {
+		taskLogf(Debug, "Task:%-12s Executing task. [%s]\n", t.Name, t.Command)
 
 		// Create directories for out-targets
 		for _, oip := range t.OutTargets {
@@ -89,68 +205,258 @@ func (t *SciTask) Execute() {
 			Check(err, "Could not create directory: "+oipDir)
 		}
 
-		t.workflow.IncConcurrentTasks(t.cores) // Will block if max concurrent tasks is reached
-		startTime := time.Now()
-		if t.CustomExecute != nil {
-			Audit.Printf("Task:%-12s Executing custom execution function.\n", t.Name)
+		// Create directories for scratch files
+		for _, scratchPath := range t.scratchPaths {
+			scratchDir := filepath.Dir(scratchPath)
+			err := os.MkdirAll(scratchDir, 0777)
+			Check(err, "Could not create directory: "+scratchDir)
+		}
+
+		// Wait for free disk space, if the workflow has a disk watch set
+		t.workflow.waitForFreeDisk()
+
+		// Batched tasks are counted against the max concurrent tasks as a
+		// whole batch, by the batch executor
+		isBatched := t.CustomExecute == nil && t.ExecMode == ExecModeLocal && t.BatchExecutor != nil
+		t.acquireDevices() // Will block until devices are free, if using device pools
+		if !isBatched {
+			t.workflow.IncConcurrentTasks(t.cores) // Will block if max concurrent tasks is reached
+		}
+		startTime := clk.Now()
+		var err error
+		if t.workflow.isStopped() {
+			// Stopped while waiting for a free slot
+			err = errWorkflowStopped
+		} else if t.CustomExecute != nil {
+			Audit.Printf("Task:%-12s [%s] Executing custom execution function.\n", t.Name, t.ID)
 			t.CustomExecute(t)
+		} else if isBatched {
+			err = t.BatchExecutor.Execute(t)
+		} else if t.Executor != nil {
+			err = t.Executor.Execute(t)
 		} else {
 			switch t.ExecMode {
 			case ExecModeLocal:
-				t.executeCommand(t.Command)
+				if t.workflow.executor != nil {
+					err = t.workflow.executor.Execute(t)
+				} else {
+					err = t.executeWithAlternatives()
+				}
 			case ExecModeSLURM:
-				Error.Printf("Task:%-12s SLURM Execution mode not implemented!", t.Name)
+				err = t.workflow.slurmExecutor().Execute(t)
 			}
 		}
-		execTime := time.Since(startTime)
-		t.workflow.DecConcurrentTasks(t.cores)
+		execTime := clk.Since(startTime)
+		if !isBatched {
+			t.workflow.DecConcurrentTasks(t.cores)
+		}
+		t.releaseDevices()
+		if err == nil {
+			err = t.checkNonEmptyOutputs()
+		}
+		if err == nil {
+			t.writeAuditInfos(execTime)
+			err = t.uploadRemoteOutputs()
+		}
+		if err != nil {
+			stopped := err == errWorkflowStopped
+			if t.workflow.FailedDir != "" && !t.workflow.isCancelled() && !stopped {
+				t.quarantineTempFiles()
+			}
+			if t.workflow.isCancelled() {
+				Warning.Printf("Task:%-12s Cancelled, so removing temporary outputs. [%s]\n", t.Name, t.Command)
+				t.cancelled = true
+			} else if stopped {
+				taskLogf(Debug, "Task:%-12s Workflow stopped, so not executing task. [%s]\n", t.Name, t.Command)
+				t.cancelled = true
+			} else if t.workflow.KeepGoing {
+				Error.Printf("Task:%-12s [%s] %s", t.Name, t.ID, err)
+				Warning.Printf("Task:%-12s Failed, but keeping going, so removing temporary outputs and skipping downstream tasks. [%s]\n", t.Name, t.Command)
+				t.failed = true
+				t.workflow.addFailedTask(t)
+			} else {
+				Error.Printf("Task:%-12s [%s] %s", t.Name, t.ID, err)
+				t.callOnTaskComplete(err)
+				// The half-written outputs are removed before exiting, so
+				// that they are not mistaken for finished ones later
+				t.removeTempOutputs()
+				os.Exit(126)
+			}
+			t.removeTempOutputs()
+		}
+
+		if !t.cancelled && !t.failed {
+			taskLogf(Debug, "Task:%-12s Atomizing targets. [%s]\n", t.Name, t.Command)
+			t.atomizeTargets()
+			t.applyOutputPermissions()
+			t.setRemoteOutPaths()
+		}
+		t.removeScratchFiles()
+		t.callOnTaskComplete(err)
+	} else if t.workflow.BackfillAudit {
+		t.backfillAuditInfos()
+	}
+	if !t.cancelled && !t.failed && !t.workflow.DryRun {
+		t.releaseInTargets()
+	}
+	// The slot is released before sending Done, since Done is only received
+	// by the process after it has launched all of its tasks
+	if t.processSlots != nil {
+		<-t.processSlots
+	}
+	taskLogf(Debug, "Task:%s: Starting to send Done in t.Execute() ...) [%s]\n", t.Name, t.Command)
+	t.Done <- 1
+	taskLogf(Debug, "Task:%s: Done sending Done, in t.Execute() [%s]\n", t.Name, t.Command)
+}
 
-		// Append audit info for the task to all its output targets
+// --------------- SciTask Helper methods ----------------
 
-		auditInfo := NewAuditInfo()
-		auditInfo.Command = t.Command
-		auditInfo.Params = t.Params
-		execTimeMS := execTime / time.Millisecond
-		auditInfo.ExecTimeMS = execTimeMS
-		// Set the audit infos from incoming IPs into the "Upstream" map
-		for _, iip := range t.InTargets {
-			iipPath := iip.GetPath()
-			iipAuditInfo := iip.GetAuditInfo()
-			auditInfo.Upstream[iipPath] = iipAuditInfo
+// streams tells whether any of the inputs or outputs of the task are streamed
+// through FIFOs
+func (t *SciTask) streams() bool {
+	for _, ip := range t.InTargets {
+		if ip.doStream {
+			return true
 		}
-		// Add the current audit info to output ips and write them to file
-		for _, oip := range t.OutTargets {
-			oip.SetAuditInfo(auditInfo)
-			for _, iip := range t.InTargets {
-				oip.AddKeys(iip.GetKeys())
+	}
+	for _, ip := range t.OutTargets {
+		if ip.doStream {
+			return true
+		}
+	}
+	return false
+}
+
+// callOnTaskComplete calls the OnTaskComplete callback of the task, if set,
+// recovering and logging any panic in it, so that it can not crash the
+// workflow
+func (t *SciTask) callOnTaskComplete(err error) {
+	if t.OnTaskComplete == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			Warning.Printf("Task:%-12s Recovered from panic in OnTaskComplete callback: %v\n", t.Name, r)
+		}
+	}()
+	t.OnTaskComplete(t, err)
+}
+
+// Append audit info for the task to all its output targets, and write them to
+// their audit files
+func (t *SciTask) writeAuditInfos(execTime time.Duration) {
+	auditInfo := NewAuditInfo()
+	auditInfo.Command = t.Command
+	auditInfo.TaskID = t.ID
+	auditInfo.RunID = t.workflow.RunID
+	auditInfo.CondaEnv = t.CondaEnv
+	auditInfo.Modules = t.Modules
+	auditInfo.Params = t.Params
+	auditInfo.Globals = t.globals
+	execTimeMS := execTime / time.Millisecond
+	auditInfo.ExecTimeMS = execTimeMS
+	auditInfo.MaxRSSKB = t.Usage.MaxRSSKB
+	auditInfo.UserTimeMS = t.Usage.UserTimeMS
+	auditInfo.SystemTimeMS = t.Usage.SystemTimeMS
+	auditInfo.CacheKey = t.cacheKey()
+	// Set the audit infos from incoming IPs into the "Upstream" map
+	for _, iip := range t.InTargets {
+		iipPath := iip.GetPath()
+		iipAuditInfo := iip.GetAuditInfo()
+		auditInfo.Upstream[iipPath] = iipAuditInfo
+	}
+	// Add the current audit info to output ips and write them to file
+	for oname, oip := range t.OutTargets {
+		oipAuditInfo := auditInfo
+		if t.metaOutPorts[oname] {
+			// The keys from the meta file are only added for this output, so
+			// it gets a copy of the audit info, with its own keys
+			auditInfoCopy := *auditInfo
+			auditInfoCopy.Keys = map[string]string{}
+			for k, v := range auditInfo.Keys {
+				auditInfoCopy.Keys[k] = v
 			}
-			oip.WriteAuditLogToFile()
+			oipAuditInfo = &auditInfoCopy
+		}
+		oip.SetAuditInfo(oipAuditInfo)
+		for _, iip := range t.InTargets {
+			oip.AddKeys(iip.GetKeys())
+		}
+		if t.metaOutPorts[oname] {
+			t.addMetaKeys(oip)
 		}
+		oip.WriteAuditLogToFile()
+	}
+}
 
-		Debug.Printf("Task:%-12s Atomizing targets. [%s]\n", t.Name, t.Command)
-		t.atomizeTargets()
+// metaFileSuffix is the suffix of the meta files read with CollectMetaFrom
+const metaFileSuffix = ".meta.json"
 
+// addMetaKeys adds the keys in the meta file of the output oip, written by the
+// command, to the keys of oip, and removes the meta file
+func (t *SciTask) addMetaKeys(oip *InformationPacket) {
+	metaPath := oip.GetTempPath() + metaFileSuffix
+	metaJSON, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		Warning.Printf("Task:%-12s Could not read meta file, so not adding any keys from it: %s\n", t.Name, err)
+		return
+	}
+	defer t.removeMetaFile(metaPath)
+	meta := map[string]json.RawMessage{}
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		Warning.Printf("Task:%-12s Malformed meta file %s, so not adding any keys from it: %s\n", t.Name, metaPath, err)
+		return
+	}
+	keys := oip.GetKeys()
+	for k, rawValue := range meta {
+		var v string
+		if err := json.Unmarshal(rawValue, &v); err != nil {
+			v = string(rawValue)
+		}
+		if prev, ok := keys[k]; ok && prev != v {
+			Warning.Printf("Task:%-12s Not changing key %s from %s to %s, as found in meta file: %s\n", t.Name, k, prev, v, metaPath)
+			continue
+		}
+		keys[k] = v
 	}
-	Debug.Printf("Task:%s: Starting to send Done in t.Execute() ...) [%s]\n", t.Name, t.Command)
-	t.Done <- 1
-	Debug.Printf("Task:%s: Done sending Done, in t.Execute() [%s]\n", t.Name, t.Command)
 }
 
-// --------------- SciTask Helper methods ----------------
+func (t *SciTask) removeMetaFile(metaPath string) {
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		Warning.Printf("Task:%-12s Could not remove meta file %s: %s\n", t.Name, metaPath, err)
+	}
+}
+
+// backfillAuditInfos writes audit info for the existing outputs of a skipped
+// task that have none, with Workflow.BackfillAudit
+func (t *SciTask) backfillAuditInfos() {
+	for _, oip := range t.OutTargets {
+		if !oip.doStream && oip.Exists() {
+			backfillAuditInfo(oip)
+		}
+	}
+}
 
 // Check if any output file target, or temporary file targets, exist
 func (t *SciTask) anyOutputExists() (anyFileExists bool) {
 	anyFileExists = false
+	outputsStale := t.RerunIfInputsNewer && t.anyOutputStale()
+	outputsChanged := !outputsStale && t.RerunIfChanged && t.anyOutputChanged()
 	for _, tgt := range t.OutTargets {
 		opath := tgt.GetPath()
-		otmpPath := tgt.GetTempPath()
 		if !tgt.doStream {
 			if _, err := os.Stat(opath); err == nil {
-				Info.Printf("Task:%-12s Output file already exists, so skipping: %s\n", t.Name, opath)
-				anyFileExists = true
+				if outputsStale {
+					taskLogf(Info, "Task:%-12s Output file older than newest input, so re-running: %s\n", t.Name, opath)
+				} else if outputsChanged {
+					taskLogf(Info, "Task:%-12s Command or inputs changed since output file was created, so re-running: %s\n", t.Name, opath)
+				} else {
+					taskLogf(Info, "Task:%-12s Output file already exists, so skipping: %s\n", t.Name, opath)
+					anyFileExists = true
+				}
 			}
-			if _, err := os.Stat(otmpPath); err == nil {
-				Warning.Printf("Task:%-12s Temp   file already exists, so skipping: %s (Note: If resuming from a failed run, clean up .tmp files first).\n", t.Name, otmpPath)
+			if tempPath := tgt.existingTempPath(); tempPath != "" {
+				Warning.Printf("Task:%-12s Temp   file already exists, so skipping: %s (Note: If resuming from a failed run, clean up .tmp files first).\n", t.Name, tempPath)
 				anyFileExists = true
 			}
 		}
@@ -158,6 +464,88 @@ func (t *SciTask) anyOutputExists() (anyFileExists bool) {
 	return
 }
 
+// Check if any existing (non-streaming) output is older than the newest of the
+// task's (non-streaming) inputs
+func (t *SciTask) anyOutputStale() bool {
+	newestInput := time.Time{}
+	for _, iip := range t.InTargets {
+		if iip.doStream || iip.GetPath() == "" || !iip.Exists() {
+			continue
+		}
+		if modTime := iip.GetModTime(); modTime.After(newestInput) {
+			newestInput = modTime
+		}
+	}
+	if newestInput.IsZero() {
+		return false
+	}
+	for _, oip := range t.OutTargets {
+		if oip.doStream || !oip.Exists() {
+			continue
+		}
+		if oip.GetModTime().Before(newestInput) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if any existing (non-streaming) output was created by the task with
+// another command, or other inputs, than now, according to the cache key in
+// its audit info
+func (t *SciTask) anyOutputChanged() bool {
+	cacheKey := t.cacheKey()
+	for _, oip := range t.OutTargets {
+		if oip.doStream || !oip.Exists() {
+			continue
+		}
+		if oip.GetAuditInfo().CacheKey != cacheKey {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey returns a hash of the ID of the task (see taskID), which covers
+// the command pattern, parameters and paths, together with the values of the
+// globals used, the prepend string, the environment (conda environment,
+// modules and shell) and whether the command is run as a script, and the sizes
+// and modification times of the (non-streaming) inputs. Run IDs and scratch
+// paths are left out, since they differ between runs without changing what
+// the command does.
+func (t *SciTask) cacheKey() string {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			fmt.Fprintf(h, "%d:%s;", len(part), part)
+		}
+	}
+	write(t.ID)
+	globalNames := []string{}
+	for name := range t.globals {
+		globalNames = append(globalNames, name)
+	}
+	sort.Strings(globalNames)
+	for _, name := range globalNames {
+		write("g", name, t.globals[name])
+	}
+	write("prepend", t.prepend, "conda", t.CondaEnv)
+	write("modules", str.Join(t.Modules, " "), "shell", str.Join(t.Shell, " "))
+	write("script", strconv.FormatBool(t.Script))
+	for _, inName := range sortedKeys(t.InTargets) {
+		iip := t.InTargets[inName]
+		if iip.doStream || iip.GetPath() == "" {
+			continue
+		}
+		fi, err := os.Stat(iip.GetPath())
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\t%d\t%d\n", inName, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // Check if any FIFO files for this tasks exist, for out-ports specified to support streaming
 func (t *SciTask) anyFifosExist() (anyFifosExist bool) {
 	anyFifosExist = false
@@ -186,20 +574,337 @@ func (t *SciTask) allFifosInOutTargetsExist() bool {
 	return true
 }
 
-func (t *SciTask) executeCommand(cmd string) {
-	Audit.Printf("Task:%-12s Executing command: %s\n", t.Name, cmd)
-	out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
+// ExecuteCommand executes the formatted command of the task (t.Command) in the
+// shell, in the same way as is done by default when no CustomExecute function
+// is set. It can be used inside a CustomExecute function, to wrap the default
+// execution with custom logic, rather than replacing it. A non-nil error is
+// returned if the command fails.
+func (t *SciTask) ExecuteCommand() error {
+	cmd := t.envCommand()
+	if t.Script {
+		scriptPath, err := t.writeScript()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(scriptPath)
+		cmd = t.wrapInEnv(shellQuote(t.shell()[0]) + " " + shellQuote(scriptPath))
+	}
+	Audit.Printf("Task:%-12s [%s] Executing command: %s\n", t.Name, t.ID, cmd)
+	var sb *sandbox
+	if t.Sandbox {
+		var err error
+		sb, err = t.newSandbox()
+		if sb != nil {
+			defer sb.remove()
+		}
+		if err != nil {
+			return err
+		}
+		cmd = sb.wrapCommand(t.shell(), cmd)
+	}
+	shell := t.shell()
+	command := exec.CommandContext(t.workflow.ctx, shell[0], append(shell[1:len(shell):len(shell)], cmd)...)
+	if t.workflow.killsProcessGroups() {
+		killProcessGroupOnCancel(command)
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	var teeLog io.Writer
+	if t.TeeLogPath != "" && (t.StdoutMode == OutputModeTee || t.StderrMode == OutputModeTee) {
+		logFile, err := openTeeLog(t.TeeLogPath)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		teeLog = logFile
+	}
+	command.Stdout = outputWriter(t.StdoutMode, stdout, os.Stdout, teeLog)
+	if t.StderrMode == OutputModeMerge {
+		command.Stderr = command.Stdout
+	} else {
+		command.Stderr = outputWriter(t.StderrMode, stderr, os.Stderr, teeLog)
+	}
+	err := command.Run()
+	t.Usage = newResourceUsage(command.ProcessState)
+	t.Stdout = stdout.String()
+	t.Stderr = stderr.String()
+	t.ExitStatus = 0
+	if err != nil {
+		t.ExitStatus = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			t.ExitStatus = exitErr.ExitCode()
+		}
+		return &CommandError{
+			Command:  cmd,
+			ExitCode: t.ExitStatus,
+			Stdout:   t.Stdout,
+			Stderr:   t.Stderr,
+		}
+	}
+	if t.FailOnStderrPattern != nil {
+		stderrOutput := stderr.String()
+		if t.StderrMode == OutputModeMerge {
+			stderrOutput = stdout.String()
+		}
+		if loc := t.FailOnStderrPattern.FindStringIndex(stderrOutput); loc != nil {
+			return fmt.Errorf("Command exited successfully, but its stderr matched FailOnStderrPattern (%s), with: %s\nCommand:\n%s\n\nStderr:\n%s\n", t.FailOnStderrPattern, stderrOutput[loc[0]:loc[1]], cmd, stderrOutput)
+		}
+	}
+	if sb != nil {
+		return sb.collectOutputs(t.Name)
+	}
+	return nil
+}
+
+// ResourceUsage is the resources used by a command, including the processes
+// it started: its peak memory use (maximum resident set size), in kilobytes,
+// and the CPU time it spent in user and system mode, in milliseconds
+type ResourceUsage struct {
+	MaxRSSKB     int64
+	UserTimeMS   int64
+	SystemTimeMS int64
+}
+
+// newResourceUsage returns the resource usage of the exited process state,
+// which is all zeros if it is not available on the platform
+func newResourceUsage(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		return ResourceUsage{}
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return ResourceUsage{}
+	}
+	maxRSSKB := int64(rusage.Maxrss)
+	if runtime.GOOS == "darwin" {
+		maxRSSKB /= 1024 // Reported in bytes rather than kilobytes
+	}
+	return ResourceUsage{
+		MaxRSSKB:     maxRSSKB,
+		UserTimeMS:   rusage.Utime.Nano() / int64(time.Millisecond),
+		SystemTimeMS: rusage.Stime.Nano() / int64(time.Millisecond),
+	}
+}
+
+// outputWriter returns the writer for an output stream of a command, in the
+// output mode mode, given the buffer capturing it, the terminal stream, and
+// the tee log file (which may be nil)
+func outputWriter(mode OutputMode, buf *bytes.Buffer, terminal io.Writer, teeLog io.Writer) io.Writer {
+	switch mode {
+	case OutputModeDiscard:
+		return ioutil.Discard
+	case OutputModeTee:
+		writers := []io.Writer{buf, terminal}
+		if teeLog != nil {
+			writers = append(writers, teeLog)
+		}
+		return io.MultiWriter(writers...)
+	}
+	return buf
+}
+
+// openTeeLog opens the tee log file at path for appending, creating it, and
+// its directory, if needed
+func openTeeLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, fmt.Errorf("Could not create directory for log file %s: %s", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open log file %s: %s", path, err)
+	}
+	return f, nil
+}
+
+// CommandError is the error returned when the command of a task fails. It
+// contains the exit code of the command (or -1, if the command did not exit
+// normally, such as when killed by a signal), together with its output, so
+// that decisions can be made based on the exit code, such as retrying only
+// when killed for using too much memory (exit code 137).
+type CommandError struct {
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("Command failed, with exit code %d!\nCommand:\n%s\n\nOutput:\n%s%s\n", e.ExitCode, e.Command, e.Stdout, e.Stderr)
+}
+
+// executeWithAlternatives executes the command of the task, and if it fails,
+// falls back to the command alternatives in order, until one of them succeeds.
+// The command that was executed last is kept in t.Command, so that it ends up
+// in the audit info.
+func (t *SciTask) executeWithAlternatives() error {
+	err := t.executeAndCheckCommand()
+	for _, altCmd := range t.CommandAlternatives {
+		if err == nil || t.workflow.isCancelled() {
+			break
+		}
+		Warning.Printf("Task:%-12s Command failed, so trying next alternative command: %s\n", t.Name, altCmd)
+		t.removeTempFiles()
+		t.Command = altCmd
+		err = t.executeAndCheckCommand()
+	}
+	return err
+}
+
+// executeAndCheckCommand executes the command of the task, and checks that
+// the outputs are non-empty, if required
+func (t *SciTask) executeAndCheckCommand() error {
+	err := t.ExecuteCommand()
 	if err != nil {
-		Error.Printf("Command failed!\nCommand:\n%s\n\nOutput:\n%s\n\n", cmd, string(out))
-		os.Exit(126)
+		return err
+	}
+	return t.checkNonEmptyOutputs()
+}
+
+// checkNonEmptyOutputs returns an error if any of the non-streaming outputs
+// of the task, for which non-empty outputs are required, is empty
+func (t *SciTask) checkNonEmptyOutputs() error {
+	for oname, oip := range t.OutTargets {
+		if oip.doStream || !(t.RequireNonEmptyOutputs || t.nonEmptyOutPorts[oname]) {
+			continue
+		}
+		fi, err := os.Stat(oip.GetTempPath())
+		if err == nil && fi.Size() == 0 {
+			return fmt.Errorf("Output for out-port %s is empty, although the command succeeded: %s", oname, oip.GetTempPath())
+		}
+	}
+	return nil
+}
+
+// shell returns the shell that the command of the task is executed with
+func (t *SciTask) shell() []string {
+	if len(t.Shell) > 0 {
+		return t.Shell
+	}
+	return ShellCommand
+}
+
+// envCommand returns the command of the task, wrapped with wrapInEnv
+func (t *SciTask) envCommand() string {
+	return t.wrapInEnv(t.Command)
+}
+
+// wrapInEnv wraps cmd so that it is executed in the conda environment and
+// with the environment modules of the task, if any. Since "conda run"
+// executes a program rather than a shell command, the command is passed to a
+// new shell (of the task) inside the environment. The devices acquired by the
+// task are exported first, so that they are given to the command however it
+// is executed, such as in a batch or as a SLURM job.
+func (t *SciTask) wrapInEnv(cmd string) string {
+	if t.CondaEnv != "" {
+		cmd = "conda run --no-capture-output -n " + shellQuote(t.CondaEnv) + " " + shellCommandLine(t.shell(), cmd)
+	}
+	if len(t.Modules) > 0 {
+		quotedModules := []string{}
+		for _, module := range t.Modules {
+			quotedModules = append(quotedModules, shellQuote(module))
+		}
+		cmd = "module load " + str.Join(quotedModules, " ") + " && " + cmd
+	}
+	if len(t.Devices) > 0 {
+		exports := []string{}
+		for _, envVar := range t.deviceEnv() {
+			nameValue := str.SplitN(envVar, "=", 2)
+			exports = append(exports, nameValue[0]+"="+shellQuote(nameValue[1]))
+		}
+		cmd = "export " + str.Join(exports, " ") + " && " + cmd
+	}
+	return cmd
+}
+
+// writeScript writes the command of the task to a new script file, in the
+// TempDir of the workflow, and returns its path
+func (t *SciTask) writeScript() (string, error) {
+	scriptDir := filepath.Join(t.workflow.TempDir, "scipipe-scripts")
+	if err := os.MkdirAll(scriptDir, 0777); err != nil {
+		return "", fmt.Errorf("Could not create directory for script: %s", err)
+	}
+	scriptPath := filepath.Join(scriptDir, t.Name+"."+randSeqLC(12)+".sh")
+	if err := ioutil.WriteFile(scriptPath, []byte(t.Command+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("Could not write script %s: %s", scriptPath, err)
+	}
+	taskLogf(Debug, "Task:%-12s Wrote script %s:\n%s\n", t.Name, scriptPath, t.Command)
+	return scriptPath, nil
+}
+
+// Make the command run in its own process group, so that the whole group,
+// including any child processes of the shell, is killed when the command is
+// cancelled
+func killProcessGroupOnCancel(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// Remove the temporary output files and FIFOs of a task that did not complete
+func (t *SciTask) removeTempOutputs() {
+	for _, tgt := range t.OutTargets {
+		if tgt.doStream && tgt.FifoFileExists() {
+			tgt.RemoveFifo()
+		}
+	}
+	t.removeTempFiles()
+	for oname, tgt := range t.OutTargets {
+		if t.metaOutPorts[oname] {
+			t.removeMetaFile(tgt.GetTempPath() + metaFileSuffix)
+		}
+	}
+}
+
+// Remove the temporary (non-streaming) output files of a task
+func (t *SciTask) removeTempFiles() {
+	for _, tgt := range t.OutTargets {
+		if !tgt.doStream && tgt.TempFileExists() {
+			err := os.Remove(tgt.GetTempPath())
+			Check(err, "Could not remove temp file: "+tgt.GetTempPath())
+		}
+	}
+}
+
+// quarantineTempFiles moves the temporary (non-streaming) output files of a
+// failed task to the FailedDir of the workflow
+func (t *SciTask) quarantineTempFiles() {
+	workDir, err := os.Getwd()
+	Check(err, "Could not get working directory")
+	for _, tgt := range t.OutTargets {
+		if tgt.doStream || !tgt.TempFileExists() {
+			continue
+		}
+		absPath, err := filepath.Abs(tgt.GetPath())
+		Check(err, "Could not get absolute path of: "+tgt.GetPath())
+		relPath, err := filepath.Rel(workDir, absPath)
+		if err != nil || relPath == ".." || str.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			relPath = str.TrimPrefix(absPath, string(filepath.Separator))
+		}
+		failedPath := filepath.Join(t.workflow.FailedDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(failedPath), 0777); err != nil {
+			Warning.Printf("Task:%-12s Could not create directory for failed output %s: %s\n", t.Name, failedPath, err)
+			continue
+		}
+		os.RemoveAll(failedPath) // Any output of a previous failed run
+		if err := os.Rename(tgt.GetTempPath(), failedPath); err != nil {
+			Warning.Printf("Task:%-12s Could not move failed output %s to %s: %s\n", t.Name, tgt.GetTempPath(), failedPath, err)
+			continue
+		}
+		taskLogf(Info, "Task:%-12s Moved output of failed task to: %s\n", t.Name, failedPath)
 	}
 }
 
 // Create FIFO files for all out-ports that are specified to support streaming
 func (t *SciTask) createFifos() {
-	Debug.Printf("Task:%s: Now creating fifos for task [%s]\n", t.Name, t.Command)
+	taskLogf(Debug, "Task:%s: Now creating fifos for task [%s]\n", t.Name, t.Command)
 	for _, otgt := range t.OutTargets {
 		if otgt.doStream {
+			// The FIFOs are created before the task is executed, which is
+			// where directories for the outputs are otherwise created
+			fifoDir := filepath.Dir(otgt.GetFifoPath())
+			err := os.MkdirAll(fifoDir, 0777)
+			Check(err, "Could not create directory: "+fifoDir)
 			otgt.CreateFifo()
 		}
 	}
@@ -208,7 +913,9 @@ func (t *SciTask) createFifos() {
 // Rename temporary output files to their proper file names
 func (t *SciTask) atomizeTargets() {
 	for _, tgt := range t.OutTargets {
-		if !tgt.doStream {
+		if tgt.noAtomize {
+			Debug.Printf("Target is written directly to its final path, so not atomizing: %s", tgt.GetPath())
+		} else if !tgt.doStream {
 			Debug.Printf("Atomizing file: %s -> %s", tgt.GetTempPath(), tgt.GetPath())
 			tgt.Atomize()
 			Debug.Printf("Done atomizing file: %s -> %s", tgt.GetTempPath(), tgt.GetPath())
@@ -218,15 +925,63 @@ func (t *SciTask) atomizeTargets() {
 	}
 }
 
+// applyOutputPermissions changes the mode and group of the (atomized,
+// non-streaming) outputs of the task, and of their audit files, to
+// OutputFileMode and OutputGroup, if set
+func (t *SciTask) applyOutputPermissions() {
+	if t.OutputFileMode == 0 && t.OutputGroup == "" {
+		return
+	}
+	gid := -1
+	if t.OutputGroup != "" {
+		var err error
+		gid, err = lookupGID(t.OutputGroup)
+		Check(err, "Could not look up output group")
+	}
+	for _, oip := range t.OutTargets {
+		if oip.doStream {
+			continue
+		}
+		paths := []string{oip.GetPath()}
+		if auditFilePath, ok := oip.auditStore().ownFile(oip.GetPath()); ok {
+			paths = append(paths, auditFilePath)
+		}
+		for _, path := range paths {
+			if t.OutputFileMode != 0 {
+				err := os.Chmod(path, t.OutputFileMode)
+				Check(err, "Could not change mode of output: "+path)
+			}
+			if gid != -1 {
+				if err := os.Chown(path, -1, gid); err != nil {
+					Warning.Printf("Task:%-12s Could not change group of output %s to %s: %s\n", t.Name, path, t.OutputGroup, err)
+				}
+			}
+		}
+	}
+}
+
+// lookupGID returns the numeric ID of group, which can be either a group
+// name or a numeric ID
+func lookupGID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	grp, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, fmt.Errorf("Could not find group %s: %s", group, err)
+	}
+	return strconv.Atoi(grp.Gid)
+}
+
 // Clean up any remaining FIFOs
 // TODO: this is actually not really used anymore ...
 func (t *SciTask) cleanUpFifos() {
 	for _, tgt := range t.OutTargets {
 		if tgt.doStream {
-			Debug.Printf("Task:%s: Cleaning up FIFO for output target: %s [%s]\n", t.Name, tgt.GetFifoPath(), t.Command)
+			taskLogf(Debug, "Task:%s: Cleaning up FIFO for output target: %s [%s]\n", t.Name, tgt.GetFifoPath(), t.Command)
 			tgt.RemoveFifo()
 		} else {
-			Debug.Printf("Task:%s: output target is not FIFO, so not removing any FIFO: %s [%s]\n", t.Name, tgt.GetPath(), t.Command)
+			taskLogf(Debug, "Task:%s: output target is not FIFO, so not removing any FIFO: %s [%s]\n", t.Name, tgt.GetPath(), t.Command)
 		}
 	}
 }
@@ -238,6 +993,124 @@ var (
 
 // ================== Helper functions==================
 
+// taskID returns an ID for a task, as the first 16 hex digits of a SHA-256
+// hash of, in order: the process name, the (unformatted) command pattern, the
+// in-port names and input paths, the parameter names and values, and the
+// out-port names and (final) output paths, with each group sorted by name.
+// The same task thus gets the same ID across runs, as long as it has the
+// same inputs, parameters and outputs, while for instance run IDs, scratch
+// paths and temporary paths do not affect it. Note that the content of the
+// inputs is not part of the hash, only their paths.
+func taskID(procName string, cmdPat string, inTargets map[string]*InformationPacket, outTargets map[string]*InformationPacket, params map[string]string) string {
+	hash := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			// Write the length before each part, so that different splits
+			// of the same string do not give the same hash
+			fmt.Fprintf(hash, "%d:%s;", len(part), part)
+		}
+	}
+	write(procName, cmdPat)
+	for _, name := range sortedKeys(inTargets) {
+		write("i", name, inTargets[name].GetPath())
+	}
+	paramNames := []string{}
+	for name := range params {
+		paramNames = append(paramNames, name)
+	}
+	sort.Strings(paramNames)
+	for _, name := range paramNames {
+		write("p", name, params[name])
+	}
+	for _, name := range sortedKeys(outTargets) {
+		write("o", name, outTargets[name].GetPath())
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// sortedKeys returns the keys of the map ips, sorted
+func sortedKeys(ips map[string]*InformationPacket) []string {
+	keys := []string{}
+	for k := range ips {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatTaskCommand formats the command pattern cmdPat with the inputs,
+// outputs and parameters of the task. The run ID is replaced in the pattern
+// (and the prepend string) before the other place-holders are filled in, so
+// that paths and parameter values containing "{runid}" are kept as they are.
+func (t *SciTask) formatTaskCommand(cmdPat string) string {
+	runID := t.workflow.RunID
+	return t.replaceTaskPlaceHolders(formatCommand(replaceRunID(cmdPat, runID), t.InTargets, t.OutTargets, t.Params, replaceRunID(t.prepend, runID)))
+}
+
+// replaceTaskPlaceHolders replaces the place-holders in cmd that are not
+// resolved by formatCommand, but depend on the workflow or task: globals and
+// scratch files
+func (t *SciTask) replaceTaskPlaceHolders(cmd string) string {
+	return t.replaceScratchPlaceHolders(t.replaceGlobalPlaceHolders(cmd))
+}
+
+// replaceGlobalPlaceHolders replaces the global place-holders ({g:key}) in cmd
+// with the values set with SetGlobal on the workflow, and records the values
+// used, for the audit info
+func (t *SciTask) replaceGlobalPlaceHolders(cmd string) string {
+	for _, m := range getShellCommandPlaceHolderRegex().FindAllStringSubmatch(cmd, -1) {
+		if m[1] != "g" {
+			continue
+		}
+		key := m[2]
+		val, ok := t.workflow.Global(key)
+		if !ok {
+			msg := fmt.Sprint("Missing global '", key, "' for command '", cmd, "'. Set it with SetGlobal on the workflow")
+			Check(errors.New(msg), msg)
+		}
+		if t.globals == nil {
+			t.globals = make(map[string]string)
+		}
+		t.globals[key] = val
+		cmd = str.Replace(cmd, m[0], val, -1)
+	}
+	return cmd
+}
+
+// replaceScratchPlaceHolders replaces the scratch file place-holders
+// ({t:name}) in cmd with paths to scratch files unique to the task, in the
+// temp dir of the workflow. The same name gives the same path within a task.
+func (t *SciTask) replaceScratchPlaceHolders(cmd string) string {
+	for _, m := range getShellCommandPlaceHolderRegex().FindAllStringSubmatch(cmd, -1) {
+		if m[1] != "t" {
+			continue
+		}
+		name := m[2]
+		if t.scratchPaths == nil {
+			t.scratchPaths = make(map[string]string)
+		}
+		if _, ok := t.scratchPaths[name]; !ok {
+			t.scratchPaths[name] = filepath.Join(t.workflow.TempDir, "scipipe-scratch", t.Name+"."+name+"."+randSeqLC(12))
+		}
+		cmd = str.Replace(cmd, m[0], t.scratchPaths[name], -1)
+	}
+	return cmd
+}
+
+// Remove the scratch files of the task, if they exist
+func (t *SciTask) removeScratchFiles() {
+	for _, scratchPath := range t.scratchPaths {
+		err := os.RemoveAll(scratchPath)
+		Check(err, "Could not remove scratch file: "+scratchPath)
+	}
+}
+
+// replaceRunID replaces the place-holder {runid} in s with the run ID of the
+// workflow
+func replaceRunID(s string, runID string) string {
+	return str.Replace(s, "{runid}", runID, -1)
+}
+
 func formatCommand(cmd string, inTargets map[string]*InformationPacket, outTargets map[string]*InformationPacket, params map[string]string, prepend string) string {
 
 	// Debug.Println("Formatting command with the following data:")
@@ -247,8 +1120,17 @@ func formatCommand(cmd string, inTargets map[string]*InformationPacket, outTarge
 	// Debug.Println("outTargets:", outTargets)
 	// Debug.Println("params:", params)
 
+	streamed := map[string]bool{}
+	for name, iip := range inTargets {
+		if iip.doStream {
+			streamed[name] = true
+		}
+	}
+	checkStreamingInputRefs(cmd, streamed)
+
 	r := getShellCommandPlaceHolderRegex()
 	ms := r.FindAllStringSubmatch(cmd, -1)
+	subStreamPaths := map[string][]string{}
 	for _, m := range ms {
 		var reduceInputs bool = false
 
@@ -256,9 +1138,11 @@ func formatCommand(cmd string, inTargets map[string]*InformationPacket, outTarge
 		typ := m[1]
 		name := m[2]
 		sep := " " // Default
-		if len(m) > 3 {
-			sep = m[5]
+		if m[3] != "" {
 			reduceInputs = true
+			if m[5] != "" {
+				sep = m[5]
+			}
 		}
 		Debug.Printf("Found the following parts in the command: (type: '%s', name: '%s', sep: '%s', reduceInputs: %v). Command: %s\n", typ, name, sep, reduceInputs, cmd)
 		var filePath string
@@ -274,23 +1158,23 @@ func formatCommand(cmd string, inTargets map[string]*InformationPacket, outTarge
 					filePath = outTargets[name].GetFifoPath()
 				}
 			}
-		} else if typ == "i" {
+		} else if typ == "i" || typ == "is" {
 			// In-ports
 			if inTargets[name] == nil {
 				msg := fmt.Sprint("Missing intarget for inport '", name, "' for command '", cmd, "'")
 				Check(errors.New(msg), msg)
 			} else if inTargets[name].GetPath() == "" && reduceInputs {
-				ips := []*InformationPacket{}
-				for ip := range inTargets[name].SubStream.InChan {
-					Debug.Println("Got ip: ", ip)
-					ips = append(ips, ip)
-				}
-				Debug.Println("Got ips: ", ips)
-				paths := []string{}
-				Debug.Println("Got paths: ", paths)
-				for _, ip := range ips {
-					paths = append(paths, ip.GetPath())
+				// The sub-stream can only be received once, so keep the
+				// paths for any further place-holders for the same port
+				if _, ok := subStreamPaths[name]; !ok {
+					paths := []string{}
+					for ip := range inTargets[name].SubStream.InChan {
+						Debug.Println("Got ip: ", ip)
+						paths = append(paths, ip.GetPath())
+					}
+					subStreamPaths[name] = paths
 				}
+				paths := subStreamPaths[name]
 				Debug.Println("Got paths: ", paths)
 				filePath = str.Join(paths, sep)
 				Debug.Println("Got filePath: ", filePath)
@@ -305,6 +1189,10 @@ func formatCommand(cmd string, inTargets map[string]*InformationPacket, outTarge
 				}
 			}
 			Debug.Printf("filePath determined to: %s, for command '%s'\n", filePath, cmd)
+		} else if typ == "t" || typ == "g" {
+			// Scratch files and globals are replaced per task, in
+			// replaceTaskPlaceHolders
+			continue
 		} else if typ == "p" {
 			if params[name] == "" {
 				msg := fmt.Sprint("Missing param value param '", name, "' for command '", cmd, "'")
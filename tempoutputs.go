@@ -0,0 +1,96 @@
+package scipipe
+
+import (
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// Temporary (intermediate) outputs
+// ----------------------------------------------------------------------------
+
+type tempOutput struct {
+	ip   *InformationPacket
+	refs int
+}
+
+// registerTempOutput registers the output ip, of an out-port marked with
+// MarkOutputTemp, as being sent to consumers number of in-ports, each of which
+// has to release it before it is deleted
+func (wf *Workflow) registerTempOutput(ip *InformationPacket, consumers int) {
+	wf.tempOutputsMx.Lock()
+	defer wf.tempOutputsMx.Unlock()
+	to, ok := wf.tempOutputs[ip.GetPath()]
+	if !ok {
+		to = &tempOutput{ip: ip}
+		wf.tempOutputs[ip.GetPath()] = to
+	}
+	to.refs += consumers
+}
+
+// retainTempOutput adds references to the output ip for consumers number of
+// additional in-ports, if it is a temporary output, such as when it is passed
+// through by a task skipped with RunIf. Paths not registered as temporary
+// outputs are ignored.
+func (wf *Workflow) retainTempOutput(ip *InformationPacket, consumers int) {
+	wf.tempOutputsMx.Lock()
+	defer wf.tempOutputsMx.Unlock()
+	if to, ok := wf.tempOutputs[ip.GetPath()]; ok {
+		to.refs += consumers
+	}
+}
+
+// releaseTempOutput releases one reference to the temporary output ip, if it
+// is one, and deletes it when no references are left. Paths not registered as
+// temporary outputs are ignored.
+func (wf *Workflow) releaseTempOutput(ip *InformationPacket) {
+	wf.tempOutputsMx.Lock()
+	defer wf.tempOutputsMx.Unlock()
+	to, ok := wf.tempOutputs[ip.GetPath()]
+	if !ok {
+		return
+	}
+	to.refs--
+	if to.refs <= 0 {
+		delete(wf.tempOutputs, ip.GetPath())
+		removeTempOutputFiles(to.ip)
+	}
+}
+
+// cleanTempOutputs deletes the temporary outputs that are still left at the
+// end of a run, such as the ones consumed by processes that do not release
+// their inputs (anything else than a SciProcess, including the sink). If any
+// tasks failed, the outputs are kept for debugging, unless ForceTempCleanup
+// is set.
+func (wf *Workflow) cleanTempOutputs(anyFailed bool) {
+	wf.tempOutputsMx.Lock()
+	defer wf.tempOutputsMx.Unlock()
+	if anyFailed && !wf.ForceTempCleanup {
+		if len(wf.tempOutputs) > 0 {
+			Warning.Printf("%s: Keeping %d temporary output(s), since the workflow failed\n", wf.name, len(wf.tempOutputs))
+		}
+		return
+	}
+	for path, to := range wf.tempOutputs {
+		removeTempOutputFiles(to.ip)
+		delete(wf.tempOutputs, path)
+	}
+}
+
+// removeTempOutputFiles removes the file of ip, together with its audit file
+func removeTempOutputFiles(ip *InformationPacket) {
+	Debug.Printf("Removing temporary output: %s\n", ip.GetPath())
+	if err := os.Remove(ip.GetPath()); err != nil && !os.IsNotExist(err) {
+		Warning.Printf("Could not remove temporary output %s: %s\n", ip.GetPath(), err)
+	}
+	if err := ip.auditStore().remove(ip.GetPath()); err != nil {
+		Warning.Printf("Could not remove audit file of temporary output %s: %s\n", ip.GetPath(), err)
+	}
+}
+
+// releaseInTargets releases the in-targets of the task, so that the ones that
+// are temporary outputs can be deleted, once all their consumers are done
+func (t *SciTask) releaseInTargets() {
+	for _, iip := range t.InTargets {
+		t.workflow.releaseTempOutput(iip)
+	}
+}
@@ -3,10 +3,13 @@ package scipipe
 import (
 	// "github.com/go-errors/errors"
 	//"os"
+	"errors"
 	"math/rand"
 	"os"
 	"os/exec"
 	re "regexp"
+	"strconv"
+	str "strings"
 	"time"
 )
 
@@ -35,15 +38,63 @@ func CheckErr(err error) {
 	}
 }
 
+// portNamePattern matches valid port names, which can consist of letters,
+// digits, underscores, dots and hyphens, such as "align.bam" or "sorted-bam"
+const portNamePattern = "[A-Za-z0-9_.-]+"
+
 // Return the regular expression used to parse the place-holder syntax for in-, out- and
 // parameter ports, that can be used to instantiate a SciProcess.
 func getShellCommandPlaceHolderRegex() *re.Regexp {
-	regex := "{(o|os|i|is|p):([^{}:]+)(:r(:([^{}:]))?)?}"
+	regex := "{(o|os|i|is|p|t|g):(" + portNamePattern + ")(:r(:([^{}:]))?)?}"
 	r, err := re.Compile(regex)
 	Check(err, "Could not compile regex: "+regex)
 	return r
 }
 
+// checkPlaceHolders makes sure that all things in the command that look like
+// place-holders are also valid place-holders, so that invalid port names
+// (such as empty ones, or ones containing whitespace) are not silently left
+// unreplaced in the command.
+func checkPlaceHolders(cmd string) {
+	candidateRegex := re.MustCompile("{(o|os|i|is|p|t|g):[^{}]*}")
+	validRegex := re.MustCompile("^" + getShellCommandPlaceHolderRegex().String() + "$")
+	for _, candidate := range candidateRegex.FindAllString(cmd, -1) {
+		if !validRegex.MatchString(candidate) {
+			msg := "Invalid place-holder '" + candidate + "' in command '" + cmd + "'. Port names can only contain letters, digits, '_', '.' and '-'"
+			Check(errors.New(msg), msg)
+		}
+	}
+}
+
+// checkStreamingInputRefs makes sure that in-ports receiving streams are
+// referenced only once in the command, since a FIFO file can only be read
+// once. The in-ports receiving streams are the ones referenced with
+// {is:PORTNAME} in the command, together with the ones in streamed, such as
+// the in-ports of a task that actually receive streams. Non-streaming
+// in-ports can safely be referenced any number of times.
+func checkStreamingInputRefs(cmd string, streamed map[string]bool) {
+	refs := map[string]int{}
+	streaming := map[string]bool{}
+	for name := range streamed {
+		streaming[name] = true
+	}
+	for _, m := range getShellCommandPlaceHolderRegex().FindAllStringSubmatch(cmd, -1) {
+		typ, name := m[1], m[2]
+		if typ == "i" || typ == "is" {
+			refs[name]++
+			if typ == "is" {
+				streaming[name] = true
+			}
+		}
+	}
+	for name := range streaming {
+		if refs[name] > 1 {
+			msg := "In-port '" + name + "' receives a stream (FIFO), which can only be read once, but is referenced " + strconv.Itoa(refs[name]) + " times in command '" + cmd + "'"
+			Check(errors.New(msg), msg)
+		}
+	}
+}
+
 var letters = []byte("abcdefghijklmnopqrstuvwxyz0123456789")
 
 func randSeqLC(n int) string {
@@ -55,3 +106,28 @@ func randSeqLC(n int) string {
 	}
 	return string(b)
 }
+
+// shellQuote quotes s in single quotes, so that it is passed as one single
+// argument in bash, without any expansions
+func shellQuote(s string) string {
+	return "'" + str.Replace(s, "'", "'\"'\"'", -1) + "'"
+}
+
+// shellCommandLine returns a command line executing cmd with shell (the
+// program and its arguments, such as ShellCommand), with all parts quoted
+func shellCommandLine(shell []string, cmd string) string {
+	quoted := []string{}
+	for _, part := range append(append([]string{}, shell...), cmd) {
+		quoted = append(quoted, shellQuote(part))
+	}
+	return str.Join(quoted, " ")
+}
+
+// checkPortName makes sure that a port name is valid, that is, non-empty and
+// containing only letters, digits, '_', '.' and '-'
+func checkPortName(name string) {
+	if !re.MustCompile("^" + portNamePattern + "$").MatchString(name) {
+		msg := "Invalid port name '" + name + "'. Port names can only contain letters, digits, '_', '.' and '-'"
+		Check(errors.New(msg), msg)
+	}
+}
@@ -33,13 +33,40 @@ func TestRegexPatternMatchesCases(t *testing.T) {
 	placeHolders := []string{
 		"{i:hej}",
 		"{is:hej}",
+		"{g:hej}",
 		"{o:hej}",
 		"{os:hej}",
 		"{i:hej:r}",
 		"{i:hej:r: }",
 		"{i:hej:r:,}",
+		"{i:align.bam}",
+		"{o:sorted-bam}",
+		"{p:ref_v2.1}",
+		"{t:scratch}",
 	}
 	for _, ph := range placeHolders {
 		assert.True(t, r.Match([]byte(ph)), "Regex does not match placeholder: "+ph)
 	}
 }
+
+func TestCheckPlaceHolders_InvalidNamesPanic(t *testing.T) {
+	for _, cmd := range []string{"cat {i:} > {o:out}", "cat {i:in file} > {o:out}"} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("Invalid place-holder did not panic, in command: " + cmd)
+				}
+			}()
+			checkPlaceHolders(cmd)
+		}()
+	}
+}
+
+func TestCheckStreamingInputRefs(t *testing.T) {
+	assert.NotPanics(t, func() { checkStreamingInputRefs("paste {i:in} {i:in} > {o:out}", nil) }, "Non-streaming in-ports should be allowed to be referenced multiple times")
+	assert.NotPanics(t, func() { checkStreamingInputRefs("cat {is:in} > {o:out}", nil) }, "Streaming in-port referenced once should be allowed")
+	for _, cmd := range []string{"paste {is:in} {is:in} > {o:out}", "paste {is:in} {i:in} > {o:out}"} {
+		assert.Panics(t, func() { checkStreamingInputRefs(cmd, nil) }, "Streaming in-port referenced twice should panic, in command: "+cmd)
+	}
+	assert.Panics(t, func() { checkStreamingInputRefs("paste {i:in} {i:in} > {o:out}", map[string]bool{"in": true}) }, "In-port receiving a stream referenced twice should panic")
+}
@@ -6,8 +6,16 @@
 package scipipe
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	re "regexp"
+	"sort"
+	str "strings"
 	"sync"
+	"syscall"
 )
 
 // ----------------------------------------------------------------------------
@@ -21,6 +29,89 @@ type Workflow struct {
 	concurrentTasksMx sync.Mutex
 	sink              *Sink
 	driver            Process
+	ctx               context.Context
+	cancel            context.CancelFunc
+	// cancellable tells whether the context the workflow is run with can be
+	// cancelled, in which case tasks are killed along with their
+	// sub-processes when it is
+	cancellable bool
+	// HandleSignals makes Run catch SIGINT and SIGTERM, upon which running
+	// tasks are killed, their temporary outputs and FIFOs removed, after
+	// which the program exits with a non-zero exit code.
+	HandleSignals bool
+	// RunID identifies the current run of the workflow, and replaces the
+	// place-holder {runid} in commands and output paths, so that outputs of
+	// different runs can be kept apart. It defaults to a timestamp of when the
+	// workflow was created, and is recorded in the audit info.
+	RunID string
+	// KeepGoing makes the workflow continue when a task fails, instead of
+	// exiting, much like "make -k". The outputs of the failed task are not
+	// sent downstream, so that tasks depending on them are skipped, while
+	// independent branches of the workflow continue. Run returns an error
+	// summarizing the failed tasks at the end. Note that downstream
+	// processes with multiple in-ports, where only one of them depends on
+	// the failed task, will get out of sync.
+	KeepGoing bool
+	// TempDir is the directory in which scratch files, for {t:name}
+	// place-holders in commands, are created. It defaults to the temp dir of
+	// the operating system.
+	TempDir string
+	// AuditMode specifies how the audit info of outputs is stored: in JSON
+	// files next to the outputs (the default), gzip-compressed such files, or
+	// in a central audit log for the whole workflow, at AuditLogPath (which
+	// defaults to the name of the workflow plus ".audit.jsonl"). It applies
+	// to the packets created with NewInformationPacket on the workflow, such
+	// as the outputs of its tasks and the packets of IPGen. Plain packets,
+	// created with the NewInformationPacket function, read audit info in any
+	// of the layouts, including the central audit logs of the workflows
+	// created in the same program.
+	AuditMode    AuditMode
+	AuditLogPath string
+	// ForceTempCleanup makes outputs marked as temporary, with
+	// MarkOutputTemp, be deleted at the end of the run even if tasks failed.
+	// By default they are kept when the run fails, for debugging.
+	ForceTempCleanup bool
+	// BackfillAudit makes tasks that are skipped, since their outputs already
+	// exist, write a minimal audit info for each existing output that has
+	// none, such as outputs produced before auditing was enabled, or by
+	// external processes. The synthesized audit info is marked with
+	// PreExisting, and contains the path and SHA-256 checksum of the output,
+	// so that the audit info of downstream outputs stays complete.
+	BackfillAudit bool
+	// DryRun makes the tasks of SciProcesses log their fully formatted
+	// commands, with the paths of inputs and outputs filled in, to the Info
+	// log, instead of executing them. Their outputs are still sent
+	// downstream, as if the commands had been executed, so that the commands
+	// of the whole workflow are shown, but no files, FIFOs or directories are
+	// created, and no temporary outputs removed. Tasks whose outputs already
+	// exist are reported as skipped. Other kinds of processes, such as
+	// components, are run as usual.
+	DryRun bool
+	// FailedDir, if set, is a directory to which the temporary outputs of
+	// failed tasks are moved, for inspection, instead of being removed (with
+	// KeepGoing) or left next to the other outputs. The outputs are placed at
+	// the same paths relative to FailedDir as their final paths are relative
+	// to the working directory, while absolute paths outside of the working
+	// directory are placed as they are under FailedDir.
+	FailedDir     string
+	failedTasks   []string
+	failedTasksMx sync.Mutex
+	diskWatch     *diskWatch
+	dependencies  map[string][]string
+	tempOutputs   map[string]*tempOutput
+	tempOutputsMx sync.Mutex
+	globals       map[string]string
+	globalsMx     sync.RWMutex
+	flagBindings  []*flagBinding
+	executor      TaskExecutor
+	stop          chan struct{}
+	stopOnce      sync.Once
+	runDone       chan struct{}
+	runMx         sync.Mutex
+	audits        auditStore
+	auditsMode    AuditMode
+	auditsLogPath string
+	auditsMx      sync.Mutex
 }
 
 func NewWorkflow(name string, maxConcurrentTasks int) *Workflow {
@@ -28,20 +119,41 @@ func NewWorkflow(name string, maxConcurrentTasks int) *Workflow {
 		InitLogInfo()
 	}
 	sink := NewSink(name + "_default_sink")
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Workflow{
 		name:            name,
 		procs:           map[string]Process{},
 		concurrentTasks: make(chan struct{}, maxConcurrentTasks),
 		sink:            sink,
 		driver:          sink,
+		ctx:             ctx,
+		cancel:          cancel,
+		RunID:           clk.Now().Format("20060102-150405"),
+		TempDir:         os.TempDir(),
+		dependencies:    map[string][]string{},
+		tempOutputs:     map[string]*tempOutput{},
+		globals:         map[string]string{},
+		stop:            make(chan struct{}),
 	}
 }
 
-func (wf *Workflow) AddProc(proc Process) {
+// AddProc adds the process proc to the workflow, and returns it. The name of
+// the process has to be unique within the workflow.
+func (wf *Workflow) AddProc(proc Process) Process {
 	if wf.procs[proc.Name()] != nil {
 		Error.Fatalf(wf.name+" workflow: A process with name '%s' already exists in the workflow! Use a more unique name!\n", proc.Name())
 	}
 	wf.procs[proc.Name()] = proc
+	return proc
+}
+
+// AddProc adds proc to the workflow, like Workflow.AddProc, but returns it as
+// its concrete type, so that a process constructed outside of the workflow
+// can be registered and configured further in the same expression, such as
+// with: AddProc(wf, &MyProc{...}).Out.Connect(...)
+func AddProc[P Process](wf *Workflow, proc P) P {
+	wf.AddProc(proc)
+	return proc
 }
 
 func (wf *Workflow) NewProc(procName string, commandPattern string) *SciProcess {
@@ -49,16 +161,29 @@ func (wf *Workflow) NewProc(procName string, commandPattern string) *SciProcess
 	return proc
 }
 
+// AddProcs adds all of procs to the workflow. As for AddProc, the names of the
+// processes have to be unique.
 func (wf *Workflow) AddProcs(procs ...Process) {
 	for _, proc := range procs {
-		wf.procs[proc.Name()] = proc
+		wf.AddProc(proc)
 	}
 }
 
+// Proc returns the process with the name procName, or nil if there is no such
+// process in the workflow
 func (wf *Workflow) Proc(procName string) Process {
 	return wf.procs[procName]
 }
 
+// SciProc returns the process with the name procName, as a *SciProcess, so
+// that it can be configured further, such as with the chainable path
+// formatting methods. It returns nil if there is no such process in the
+// workflow, or if it is not a *SciProcess.
+func (wf *Workflow) SciProc(procName string) *SciProcess {
+	proc, _ := wf.procs[procName].(*SciProcess)
+	return proc
+}
+
 func (wf *Workflow) Procs() map[string]Process {
 	return wf.procs
 }
@@ -111,27 +236,315 @@ func (wf *Workflow) ConnectLast(outPort *FilePort) {
 	wf.driver = wf.sink
 }
 
-func (wf *Workflow) Run() {
-	if len(wf.procs) == 0 {
-		Error.Println(wf.name + ": The workflow is empty. Did you forget to add the processes to it?")
-		os.Exit(1)
+// ConnectMany connects all of outPorts to the single in-port inPort, so that
+// the outputs of all of them are merged into inPort. The merging is started by
+// the process owning inPort when it runs, and inPort is closed only when all of
+// the out-ports have been closed, that is, when all the producers are done.
+func (wf *Workflow) ConnectMany(inPort *FilePort, outPorts ...*FilePort) {
+	if len(outPorts) == 0 {
+		Error.Fatalf("%s: ConnectMany needs at least one out-port to connect\n", wf.name)
 	}
-	if wf.sink == nil {
-		Error.Println(wf.name + ": sink is nil!")
-		os.Exit(1)
+	for _, outPort := range outPorts {
+		inPort.Connect(outPort)
 	}
-	for _, proc := range wf.procs {
-		if !proc.IsConnected() {
-			Error.Println(wf.name + ": Not everything connected. Workflow shutting down.")
-			os.Exit(1)
+}
+
+// AddDependency makes the process after wait to start until the process
+// before has completed, even though no files are passed between them. This can
+// be used for ordering of side effects, such as indexing a reference genome
+// before aligning against it. Note that any processes sending data to after
+// might also have to wait, when the buffers of the connections fill up, so
+// before should not depend on data from processes upstream of after.
+func (wf *Workflow) AddDependency(after Process, before Process) {
+	if after.Name() == before.Name() {
+		Error.Fatalf("%s: A process can not depend on itself: %s\n", wf.name, after.Name())
+	}
+	wf.dependencies[after.Name()] = append(wf.dependencies[after.Name()], before.Name())
+}
+
+// checkDependencies makes sure that all processes in dependencies are part of
+// the workflow, and that there are no cycles among the dependencies
+func (wf *Workflow) checkDependencies() {
+	for after, befores := range wf.dependencies {
+		for _, name := range append([]string{after}, befores...) {
+			if _, ok := wf.procs[name]; !ok && name != wf.driver.Name() {
+				Error.Fatalf("%s: Process in dependency not found in workflow: %s\n", wf.name, name)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string)
+	visit = func(name string) {
+		if state[name] == visiting {
+			Error.Fatalf("%s: Cycle in dependencies between processes, including: %s\n", wf.name, name)
+		}
+		if state[name] == visited {
+			return
+		}
+		state[name] = visiting
+		for _, before := range wf.dependencies[name] {
+			visit(before)
 		}
+		state[name] = visited
+	}
+	for after := range wf.dependencies {
+		visit(after)
+	}
+}
+
+// Run runs the workflow, and returns when the driver process (by default the
+// sink) has finished. If the workflow is not valid (see Validate), or flags
+// bound with BindFlags are missing, the error is returned without running
+// anything. When KeepGoing is set, an error
+// summarizing the failed tasks is returned if any task failed, otherwise
+// failing tasks make the program exit.
+func (wf *Workflow) Run() error {
+	return wf.RunWithContext(context.Background())
+}
+
+// RunWithContext runs the workflow like Run, until ctx is cancelled, upon
+// which running tasks are killed, along with any sub-processes of their
+// commands, their temporary outputs and FIFOs are removed, and no more tasks
+// are executed. It then returns an error telling that the workflow was
+// cancelled, as soon as all processes have finished. Temporary outputs (see
+// MarkOutputTemp) are kept, as when tasks fail.
+//
+// Since the commands are executed in their own process groups, to be able to
+// kill them with their sub-processes, they do not receive the SIGINT of a
+// Ctrl-C in the terminal, so ctx should be cancelled on that, such as with
+// signal.NotifyContext, or HandleSignals be used.
+func (wf *Workflow) RunWithContext(ctx context.Context) error {
+	wf.runMx.Lock()
+	wf.runDone = make(chan struct{})
+	wf.ctx, wf.cancel = context.WithCancel(ctx)
+	wf.cancellable = ctx.Done() != nil
+	wf.runMx.Unlock()
+	defer close(wf.runDone)
+
+	if err := wf.connectFlags(); err != nil {
+		return err
+	}
+	if err := wf.Validate(); err != nil {
+		return err
+	}
+	wf.checkDependencies()
+	done := map[string]chan struct{}{wf.driver.Name(): make(chan struct{})}
+	for pname := range wf.procs {
+		done[pname] = make(chan struct{})
+	}
+	runProc := func(proc Process) {
+		for _, before := range wf.dependencies[proc.Name()] {
+			Debug.Printf("%s: Process %s waiting for process %s to complete\n", wf.name, proc.Name(), before)
+			<-done[before]
+		}
+		proc.Run()
+		close(done[proc.Name()])
 	}
 	for pname, proc := range wf.procs {
 		if proc != wf.driver { // Don't start the driver process in background
 			Debug.Printf(wf.name+": Starting process %s in new go-routine", pname)
-			go proc.Run()
+			go runProc(proc)
 		}
 	}
+	var caughtSignal chan os.Signal
+	if wf.HandleSignals {
+		stopHandling := make(chan struct{})
+		defer close(stopHandling)
+		caughtSignal = wf.handleSignals(stopHandling)
+	}
 	Debug.Printf(wf.name + ": Starting sink in main go-routine")
-	wf.driver.Run()
+	runProc(wf.driver)
+
+	select {
+	case sig := <-caughtSignal:
+		Error.Printf("%s: Workflow was cancelled by signal %s\n", wf.name, sig)
+		osExit(128 + int(sig.(syscall.Signal)))
+	default:
+	}
+
+	wf.failedTasksMx.Lock()
+	defer wf.failedTasksMx.Unlock()
+	wf.cleanTempOutputs(len(wf.failedTasks) > 0 || wf.isStopped() || wf.isCancelled())
+	if len(wf.failedTasks) > 0 {
+		Error.Printf("%s: %d task(s) failed:\n%s\n", wf.name, len(wf.failedTasks), str.Join(wf.failedTasks, "\n"))
+		return fmt.Errorf("%s: %d task(s) failed:\n%s", wf.name, len(wf.failedTasks), str.Join(wf.failedTasks, "\n"))
+	}
+	if wf.isCancelled() {
+		return fmt.Errorf("%s: Workflow was cancelled: %s", wf.name, wf.ctx.Err())
+	}
+	if wf.isStopped() {
+		return fmt.Errorf("%s: Workflow was stopped, before all tasks were run", wf.name)
+	}
+	return nil
 }
+
+// Stop stops the workflow gracefully, and is meant to be called from another
+// go-routine than the one running it. Tasks that have already started are
+// allowed to complete, and their outputs are kept, while no new tasks are
+// started, and the remaining inputs of all processes are drained and
+// discarded, so that the whole workflow can finish. Temporary outputs (see
+// MarkOutputTemp) are kept, as when tasks fail, so that a later run can resume
+// from where it stopped. Stop blocks until Run has returned (with an error
+// telling that the workflow was stopped), if it was running. Use
+// HandleSignals, or RunWithContext, to instead kill running tasks.
+func (wf *Workflow) Stop() {
+	wf.runMx.Lock()
+	wf.stopOnce.Do(func() {
+		Info.Printf("%s: Stopping workflow, after running tasks have completed ...\n", wf.name)
+		close(wf.stop)
+	})
+	runDone := wf.runDone
+	wf.runMx.Unlock()
+	if runDone != nil {
+		<-runDone
+	}
+}
+
+// errWorkflowStopped is the error of tasks that were not executed, since the
+// workflow was stopped with Stop
+var errWorkflowStopped = errors.New("Workflow stopped")
+
+func (wf *Workflow) isStopped() bool {
+	select {
+	case <-wf.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetGlobal sets the global value of key, to be used in place of {g:key}
+// place-holders in the commands of any process in the workflow, without the
+// need to connect any ports. This is meant for static configuration shared by
+// many processes, such as paths to reference data. The values used by each
+// task are recorded in its audit info.
+func (wf *Workflow) SetGlobal(key string, value string) {
+	if !re.MustCompile("^" + portNamePattern + "$").MatchString(key) {
+		Error.Fatalf("%s: Invalid global key '%s'. Keys can only contain letters, digits, '_', '.' and '-'\n", wf.name, key)
+	}
+	wf.globalsMx.Lock()
+	defer wf.globalsMx.Unlock()
+	wf.globals[key] = value
+}
+
+// Global returns the global value of key, set with SetGlobal, and whether it
+// was set
+func (wf *Workflow) Global(key string) (string, bool) {
+	wf.globalsMx.RLock()
+	defer wf.globalsMx.RUnlock()
+	value, ok := wf.globals[key]
+	return value, ok
+}
+
+// auditStore returns the store of the audit info of the outputs of the
+// workflow, according to AuditMode and AuditLogPath. The same store is
+// returned as long as they are not changed.
+func (wf *Workflow) auditStore() auditStore {
+	wf.auditsMx.Lock()
+	defer wf.auditsMx.Unlock()
+	logPath := wf.AuditLogPath
+	if logPath == "" {
+		logPath = wf.name + ".audit.jsonl"
+	}
+	if wf.audits == nil || wf.auditsMode != wf.AuditMode || wf.auditsLogPath != logPath {
+		wf.audits = newAuditStore(wf.AuditMode, logPath)
+		wf.auditsMode = wf.AuditMode
+		wf.auditsLogPath = logPath
+	}
+	return wf.audits
+}
+
+// NewInformationPacket creates a new InformationPacket for path, whose audit
+// info is read and written according to the AuditMode of the workflow
+func (wf *Workflow) NewInformationPacket(path string) *InformationPacket {
+	ip := NewInformationPacket(path)
+	ip.audits = wf.auditStore()
+	return ip
+}
+
+// Validate checks that the workflow is ready to run: that it is not empty,
+// and that all the ports of all its processes are connected, so that no
+// outputs are silently lost (the unconnected ports are logged as errors), and
+// that the ParamSpec of each process only has constraints for existing param
+// ports, and that the executor of each process supports the features it uses.
+// It is called by Run, but can also be called separately, before running.
+func (wf *Workflow) Validate() error {
+	if len(wf.procs) == 0 {
+		return errors.New(wf.name + ": The workflow is empty. Did you forget to add the processes to it?")
+	}
+	if wf.sink == nil {
+		return errors.New(wf.name + ": sink is nil!")
+	}
+	notConnected := []string{}
+	for pname, proc := range wf.procs {
+		if !proc.IsConnected() {
+			notConnected = append(notConnected, pname)
+		}
+	}
+	if len(notConnected) > 0 {
+		sort.Strings(notConnected)
+		return fmt.Errorf("%s: Not everything connected, in processes: %s", wf.name, str.Join(notConnected, ", "))
+	}
+	procNames := []string{}
+	for pname := range wf.procs {
+		procNames = append(procNames, pname)
+	}
+	sort.Strings(procNames)
+	for _, pname := range procNames {
+		if p, ok := wf.procs[pname].(*SciProcess); ok {
+			if err := p.checkParamSpec(); err != nil {
+				return fmt.Errorf("%s: %s", wf.name, err)
+			}
+			if err := p.checkExecutor(); err != nil {
+				return fmt.Errorf("%s: %s", wf.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addFailedTask records that the task t failed, when
+// running with KeepGoing
+func (wf *Workflow) addFailedTask(t *SciTask) {
+	wf.failedTasksMx.Lock()
+	defer wf.failedTasksMx.Unlock()
+	wf.failedTasks = append(wf.failedTasks, fmt.Sprintf("Task %s: %s", t.Name, t.Command))
+}
+
+// handleSignals starts catching SIGINT and SIGTERM, and cancels the workflow
+// when one is caught. The caught signal is sent on the returned channel.
+func (wf *Workflow) handleSignals(stop chan struct{}) chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	caught := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			Warning.Printf("%s: Caught signal %s, so cancelling running tasks and cleaning up ...\n", wf.name, sig)
+			caught <- sig
+			wf.cancel()
+		case <-stop:
+		}
+	}()
+	return caught
+}
+
+func (wf *Workflow) isCancelled() bool {
+	return wf.ctx.Err() != nil
+}
+
+// killsProcessGroups tells whether commands are executed in their own process
+// groups, so that they can be killed along with their sub-processes, when the
+// workflow is cancelled
+func (wf *Workflow) killsProcessGroups() bool {
+	return wf.HandleSignals || wf.cancellable
+}
+
+// osExit is used for exiting, so that it can be replaced in tests
+var osExit = os.Exit
@@ -1,9 +1,16 @@
 package scipipe
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSetWfName(t *testing.T) {
@@ -35,6 +42,39 @@ func TestAddProc(t *testing.T) {
 	assert.IsType(t, &BogusProcess{}, wf.procs["bogusproc2"], "Process 2 was not of the right type!")
 }
 
+func TestHandleSignals(t *testing.T) {
+	InitLogError()
+
+	exitCodes := make(chan int, 1)
+	osExit = func(code int) { exitCodes <- code }
+	defer func() { osExit = os.Exit }()
+
+	wf := NewWorkflow("TestHandleSignalsWf", 4)
+	wf.HandleSignals = true
+	slp := wf.NewProc("sleep", "echo started > {o:out} && sleep 30")
+	slp.SetPathStatic("out", "/tmp/signals_out.txt")
+	wf.ConnectLast(slp.Out("out"))
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+	startTime := time.Now()
+	wf.Run()
+
+	assert.True(t, time.Since(startTime) < 10*time.Second, "Workflow did not stop promptly on signal")
+	select {
+	case code := <-exitCodes:
+		assert.NotEqual(t, 0, code, "Exit code should be non-zero after signal")
+	default:
+		t.Error("Workflow did not exit after signal")
+	}
+	_, err := os.Stat("/tmp/signals_out.txt.tmp")
+	assert.True(t, os.IsNotExist(err), "Temp file was not removed after signal")
+	_, err = os.Stat("/tmp/signals_out.txt")
+	assert.True(t, os.IsNotExist(err), "Output file should not exist after signal")
+}
+
 // --------------------------------
 // Helper stuff
 // --------------------------------
@@ -67,3 +107,297 @@ func (p *BogusProcess) Name() string {
 func (p *BogusProcess) IsConnected() bool {
 	return true
 }
+
+func TestDiskWatch(t *testing.T) {
+	initTestLogs()
+
+	checks := 0
+	origFreeDiskBytes := freeDiskBytes
+	defer func() { freeDiskBytes = origFreeDiskBytes }()
+	freeDiskBytes = func(path string) (uint64, error) {
+		checks++
+		if checks < 3 {
+			return 1024, nil
+		}
+		return 10 * 1024, nil
+	}
+
+	wf := NewWorkflow("TestDiskWatchWf", 4)
+	wf.SetDiskWatch("/tmp", 2048, 10*time.Millisecond)
+	echo := wf.NewProc("echo", "echo hej > {o:out}")
+	echo.SetPathStatic("out", "/tmp/diskwatch.txt")
+	wf.ConnectLast(echo.Out("out"))
+	wf.Run()
+
+	assert.Equal(t, 3, checks, "Task should wait until free disk space is above threshold")
+	_, err := os.Stat("/tmp/diskwatch.txt")
+	assert.Nil(t, err, "Output file missing")
+	cleanFiles("/tmp/diskwatch.txt")
+}
+
+func TestConnectMany(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestConnectManyWf", 4)
+	producers := []*FilePort{}
+	outFiles := []string{}
+	for _, name := range []string{"a", "b", "c"} {
+		p := wf.NewProc("produce_"+name, "echo "+name+" > {o:out}")
+		p.SetPathStatic("out", "/tmp/connectmany_"+name+".txt")
+		producers = append(producers, p.Out("out"))
+		outFiles = append(outFiles, "/tmp/connectmany_"+name+".txt", "/tmp/connectmany_"+name+".txt.copy.txt")
+	}
+	consumer := wf.NewProc("consume", "cat {i:in} > {o:out}")
+	consumer.SetPathExtend("in", "out", ".copy.txt")
+	wf.ConnectMany(consumer.In("in"), producers...)
+	wf.ConnectLast(consumer.Out("out"))
+	wf.Run()
+
+	for _, f := range outFiles {
+		_, err := os.Stat(f)
+		assert.Nil(t, err, "File missing: "+f)
+	}
+	cleanFiles(outFiles...)
+}
+
+func TestAddDependency(t *testing.T) {
+	initTestLogs()
+
+	logPath := "/tmp/adddependency_log.txt"
+	wf := NewWorkflow("TestAddDependencyWf", 4)
+	index := wf.NewProc("index", "sleep 0.3; echo index >> "+logPath+"; echo done > {o:out}")
+	index.SetPathStatic("out", "/tmp/adddependency_index.txt")
+	align := wf.NewProc("align", "echo align >> "+logPath+"; echo done > {o:out}")
+	align.SetPathStatic("out", "/tmp/adddependency_align.txt")
+	wf.ConnectLast(index.Out("out"))
+	wf.ConnectLast(align.Out("out"))
+	wf.AddDependency(align, index)
+	wf.Run()
+
+	log := NewInformationPacket(logPath)
+	assert.Equal(t, "index\nalign\n", string(log.Read()), "Processes did not run in dependency order")
+	cleanFiles(logPath, "/tmp/adddependency_index.txt", "/tmp/adddependency_align.txt")
+}
+
+func TestKeepGoing(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestKeepGoingWf", 4)
+	wf.KeepGoing = true
+
+	failing := wf.NewProc("failing", "echo fail > {o:out}; exit 1")
+	failing.SetPathStatic("out", "/tmp/keepgoing_fail.txt")
+	failingDownstream := wf.NewProc("failing_downstream", "cat {i:in} > {o:out}")
+	failingDownstream.SetPathExtend("in", "out", ".cat.txt")
+	failingDownstream.In("in").Connect(failing.Out("out"))
+
+	working := wf.NewProc("working", "echo ok > {o:out}")
+	working.SetPathStatic("out", "/tmp/keepgoing_ok.txt")
+	workingDownstream := wf.NewProc("working_downstream", "cat {i:in} > {o:out}")
+	workingDownstream.SetPathExtend("in", "out", ".cat.txt")
+	workingDownstream.In("in").Connect(working.Out("out"))
+
+	wf.ConnectLast(failingDownstream.Out("out"))
+	wf.ConnectLast(workingDownstream.Out("out"))
+	err := wf.Run()
+
+	assert.NotNil(t, err, "Run should return an error when a task failed")
+	assert.Contains(t, err.Error(), "Task failing", "Error should list the failed task")
+	for _, f := range []string{"/tmp/keepgoing_fail.txt", "/tmp/keepgoing_fail.txt.tmp", "/tmp/keepgoing_fail.txt.cat.txt"} {
+		_, statErr := os.Stat(f)
+		assert.NotNil(t, statErr, "File of failed branch should not exist: "+f)
+	}
+	_, statErr := os.Stat("/tmp/keepgoing_ok.txt.cat.txt")
+	assert.Nil(t, statErr, "Output of independent branch missing")
+
+	cleanFiles("/tmp/keepgoing_ok.txt", "/tmp/keepgoing_ok.txt.cat.txt")
+}
+
+func TestChainedProcessConfiguration(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestChainedProcessConfigurationWf", 4)
+	foo := wf.NewProc("foo", "echo foo > {o:out}").SetPathStatic("out", "/tmp/chained_foo.txt")
+	bar := wf.NewProc("bar", "sed 's/foo/bar/' {i:in} > {o:out}").SetPathExtend("in", "out", ".bar.txt")
+	wf.SciProc("bar").In("in").Connect(foo.Out("out"))
+	wf.ConnectLast(bar.Out("out"))
+	wf.Run()
+
+	ip := NewInformationPacket("/tmp/chained_foo.txt.bar.txt")
+	assert.Equal(t, "bar\n", string(ip.Read()), "Wrong output of chained processes")
+	cleanFiles("/tmp/chained_foo.txt", ip.GetPath())
+}
+
+func TestAddProc_ConcreteType(t *testing.T) {
+	wf := NewWorkflow("TestAddProcConcreteTypeWf", 4)
+	gen := AddProc(wf, &IPGen{name: "gen", Out: NewFilePort(), FilePaths: []string{"a.txt"}})
+	assert.Equal(t, []string{"a.txt"}, gen.FilePaths, "AddProc should return the process as its concrete type")
+	assert.Equal(t, Process(gen), wf.Proc("gen"), "AddProc should register the process")
+}
+
+func TestProcByName_Missing(t *testing.T) {
+	wf := NewWorkflow("TestProcByNameMissingWf", 4)
+	wf.NewProc("foo", "echo foo > {o:out}")
+	assert.Nil(t, wf.Proc("bar"), "Proc should return nil for a missing process")
+	assert.Nil(t, wf.SciProc("bar"), "SciProc should return nil for a missing process")
+	assert.NotNil(t, wf.SciProc("foo"))
+}
+
+func TestMarkOutputTemp(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestMarkOutputTempWf", 4)
+	foo := wf.NewProc("foo", "echo foo > {o:out}").SetPathStatic("out", "/tmp/markoutputtemp_foo.txt").MarkOutputTemp("out")
+	bar := wf.NewProc("bar", "sed 's/foo/bar/' {i:in} > {o:out}").SetPathExtend("in", "out", ".bar.txt")
+	baz := wf.NewProc("baz", "sed 's/foo/baz/' {i:in} > {o:out}").SetPathExtend("in", "out", ".baz.txt")
+	bar.In("in").Connect(foo.Out("out"))
+	baz.In("in").Connect(foo.Out("out"))
+	wf.ConnectLast(bar.Out("out"))
+	wf.ConnectLast(baz.Out("out"))
+	err := wf.Run()
+	assert.Nil(t, err)
+
+	for _, f := range []string{"/tmp/markoutputtemp_foo.txt", "/tmp/markoutputtemp_foo.txt.audit.json"} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Temporary output should be deleted after being consumed: "+f)
+	}
+	for _, f := range []string{"/tmp/markoutputtemp_foo.txt.bar.txt", "/tmp/markoutputtemp_foo.txt.baz.txt"} {
+		_, statErr := os.Stat(f)
+		assert.Nil(t, statErr, "Output of consumer of temporary output missing: "+f)
+	}
+
+	cleanFiles("/tmp/markoutputtemp_foo.txt.bar.txt", "/tmp/markoutputtemp_foo.txt.baz.txt")
+}
+
+func TestMarkOutputTemp_KeptOnFailure(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestMarkOutputTempKeptOnFailureWf", 4)
+	wf.KeepGoing = true
+	foo := wf.NewProc("foo", "echo foo > {o:out}").SetPathStatic("out", "/tmp/markoutputtemp_kept.txt").MarkOutputTemp("out")
+	fail := wf.NewProc("fail", "cat {i:in} > {o:out}; exit 1").SetPathExtend("in", "out", ".fail.txt")
+	fail.In("in").Connect(foo.Out("out"))
+	wf.ConnectLast(fail.Out("out"))
+	err := wf.Run()
+	assert.NotNil(t, err)
+
+	_, statErr := os.Stat("/tmp/markoutputtemp_kept.txt")
+	assert.Nil(t, statErr, "Temporary output should be kept when its consumer failed")
+
+	cleanFiles("/tmp/markoutputtemp_kept.txt")
+}
+
+func TestValidate(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestValidateWf", 4)
+	foo := wf.NewProc("foo", "echo foo > {o:out}; echo bar > {o:forgotten}")
+	foo.SetPathStatic("out", "/tmp/validate_foo.txt").SetPathStatic("forgotten", "/tmp/validate_forgotten.txt")
+	wf.ConnectLast(foo.Out("out"))
+
+	err := wf.Validate()
+	assert.NotNil(t, err, "Workflow with unconnected out-port should not validate")
+	assert.Contains(t, err.Error(), "foo", "Error should name the process with the unconnected port")
+	assert.Equal(t, err, wf.Run(), "Run should return the validation error")
+	_, statErr := os.Stat("/tmp/validate_foo.txt")
+	assert.True(t, os.IsNotExist(statErr), "Nothing should be run in an invalid workflow")
+
+	wf.ConnectLast(foo.Out("forgotten"))
+	assert.Nil(t, wf.Validate(), "Fully connected workflow should validate")
+}
+
+func TestFailedDir(t *testing.T) {
+	initTestLogs()
+
+	wf := NewWorkflow("TestFailedDirWf", 4)
+	wf.KeepGoing = true
+	wf.FailedDir = "/tmp/faileddir_quarantine"
+	failing := wf.NewProc("failing", "echo partial > {o:out}; exit 1")
+	failing.SetPathStatic("out", "faileddir_out/out.txt")
+	wf.ConnectLast(failing.Out("out"))
+	err := wf.Run()
+
+	assert.NotNil(t, err, "Failing task should make the run fail")
+	for _, f := range []string{"faileddir_out/out.txt", "faileddir_out/out.txt.tmp"} {
+		_, statErr := os.Stat(f)
+		assert.True(t, os.IsNotExist(statErr), "Failed output should not be left in output dir: "+f)
+	}
+	quarantined := NewInformationPacket("/tmp/faileddir_quarantine/faileddir_out/out.txt")
+	if assert.True(t, quarantined.Exists(), "Failed output should be moved to the failed dir, preserving its relative path") {
+		assert.Equal(t, "partial\n", string(quarantined.Read()))
+	}
+	os.RemoveAll("faileddir_out")
+	os.RemoveAll("/tmp/faileddir_quarantine")
+}
+
+func TestStop(t *testing.T) {
+	initTestLogs()
+	os.RemoveAll("/tmp/stop_test")
+
+	wf := NewWorkflow("TestStopWf", 2)
+	values := []string{}
+	for i := 0; i < 20; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+	slow := wf.NewProc("slow", "sleep 0.2; echo {p:i} > {o:out}")
+	slow.SetPathPattern("out", "/tmp/stop_test/slow_{p:i}.txt")
+	slow.ParamPort("i").ConnectStr(values...)
+	copyProc := wf.NewProc("copy", "cat {i:in} > {o:out}")
+	copyProc.SetPathExtend("in", "out", ".copy")
+	copyProc.In("in").Connect(slow.Out("out"))
+	wf.ConnectLast(copyProc.Out("out"))
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- wf.Run()
+	}()
+	time.Sleep(300 * time.Millisecond)
+	wf.Stop()
+
+	select {
+	case err := <-runErr:
+		assert.Error(t, err, "Run should return an error, when stopped")
+	default:
+		t.Fatal("Run should have returned when Stop returns")
+	}
+	slowOutputs, _ := filepath.Glob("/tmp/stop_test/slow_*.txt")
+	assert.True(t, len(slowOutputs) >= 2, "Tasks started before stopping should complete, but got %d outputs", len(slowOutputs))
+	assert.True(t, len(slowOutputs) < len(values), "No new tasks should be started after stopping, but got %d outputs", len(slowOutputs))
+	tempFiles, _ := filepath.Glob("/tmp/stop_test/*.tmp")
+	assert.Empty(t, tempFiles, "No temporary files should be left after stopping")
+
+	os.RemoveAll("/tmp/stop_test")
+}
+
+func TestRunWithContext(t *testing.T) {
+	InitLogError()
+	os.RemoveAll("/tmp/runwithcontext")
+
+	wf := NewWorkflow("TestRunWithContextWf", 4)
+	streamer := wf.NewProc("streamer", "sleep 30; echo hej > {os:out}")
+	streamer.SetPathStatic("out", "/tmp/runwithcontext/streamed.txt")
+	reader := wf.NewProc("reader", "cat {i:in} > {o:out}")
+	reader.SetPathStatic("out", "/tmp/runwithcontext/read.txt")
+	reader.In("in").Connect(streamer.Out("out"))
+	sleeper := wf.NewProc("sleeper", "echo started > {o:out}; sleep 30")
+	sleeper.SetPathStatic("out", "/tmp/runwithcontext/slept.txt")
+	wf.ConnectLast(reader.Out("out"))
+	wf.ConnectLast(sleeper.Out("out"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	startTime := time.Now()
+	err := wf.RunWithContext(ctx)
+
+	assert.Error(t, err, "RunWithContext should return an error, when cancelled")
+	assert.True(t, time.Since(startTime) < 10*time.Second, "Workflow did not return promptly when cancelled")
+	for _, pattern := range []string{"/tmp/runwithcontext/*.fifo", "/tmp/runwithcontext/*.tmp", "/tmp/runwithcontext/*.txt"} {
+		leftovers, _ := filepath.Glob(pattern)
+		assert.Empty(t, leftovers, "No files should be left after cancelling")
+	}
+
+	os.RemoveAll("/tmp/runwithcontext")
+}